@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/theluminousartemis/snippetbin/internal/logging"
+	"github.com/theluminousartemis/snippetbin/internal/mailer"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+const tokenPurposeChangeEmail = "change-email"
+
+// reauthTimeout is how long confirming it's them lets a user take actions
+// that could lock them out of their account, such as adding a passkey.
+const reauthTimeout = 10 * time.Minute
+
+type accountUsernameForm struct {
+	Username    string            `form:"username" validate:"required,min=3"`
+	FieldErrors map[string]string `form:"-"`
+}
+
+func (app *application) accountUsername(w http.ResponseWriter, r *http.Request) {
+	user, err := app.store.Users.GetByID(r.Context(), app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = accountUsernameForm{Username: user.Username}
+	app.render(w, r, http.StatusOK, "username.html", data)
+}
+
+func (app *application) accountUsernamePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var form accountUsernameForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Username = strings.TrimSpace(form.Username)
+	rerender := func(msg string) {
+		form.FieldErrors = map[string]string{"username": msg}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "username.html", data)
+	}
+	if err := validate.Struct(form); err != nil {
+		if ve, ok := err.(validator.ValidationErrors); ok && ve[0].Tag() == "min" {
+			rerender("This field must be at least 3 characters long")
+		} else {
+			rerender("This field cannot be blank")
+		}
+		return
+	}
+
+	id := app.sessionManager.GetInt(ctx, "authenticatedUserID")
+	if err := app.store.Users.UpdateUsername(ctx, id, form.Username, app.clientIP(r)); err != nil {
+		if errors.Is(err, store.ErrDuplicateUsername) {
+			rerender("Username is already in use")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "username changed", slog.Int("user_id", id))
+	app.sessionManager.Put(ctx, "flash", "Your username has been changed.")
+	http.Redirect(w, r, "/account/", http.StatusSeeOther)
+}
+
+type accountEmailForm struct {
+	Email       string            `form:"email" validate:"required,email"`
+	FieldErrors map[string]string `form:"-"`
+}
+
+func (app *application) accountEmail(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountEmailForm{}
+	app.render(w, r, http.StatusOK, "email.html", data)
+}
+
+// accountEmailPost starts a change of email address. Nothing changes until
+// the link sent to the new address is opened, and the old address is told
+// about the request in case it was not the user who made it. Users confirm
+// it's them beforehand; see requireReauthentication.
+func (app *application) accountEmailPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var form accountEmailForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Email = strings.TrimSpace(form.Email)
+	rerender := func(field, msg string) {
+		form.FieldErrors = map[string]string{field: msg}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "email.html", data)
+	}
+	if err := validate.Struct(form); err != nil {
+		form.FieldErrors = make(map[string]string)
+		if ve, ok := err.(validator.ValidationErrors); ok {
+			for _, fe := range ve {
+				field := strings.ToLower(fe.Field())
+				switch fe.Tag() {
+				case "required":
+					form.FieldErrors[field] = "This field cannot be blank"
+				case "email":
+					form.FieldErrors[field] = "This field must be a valid email address"
+				}
+			}
+		}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "email.html", data)
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if strings.EqualFold(form.Email, user.Email) {
+		rerender("email", "This is already your email address")
+		return
+	}
+	if _, err := app.store.Users.GetByEmail(ctx, form.Email); err == nil {
+		rerender("email", "Email is already in use")
+		return
+	} else if !errors.Is(err, store.ErrInvalidCredentials) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.store.Audit.Insert(ctx, &store.AuditEvent{
+		UserID:   user.ID,
+		Action:   store.AuditEmailChangeRequested,
+		OldValue: user.Email,
+		NewValue: form.Email,
+		IP:       app.clientIP(r),
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	token := app.tokens.Sign(tokenPurposeChangeEmail, app.emailVerificationTTL, strconv.Itoa(user.ID), user.Email, form.Email)
+	app.sendEmail(user.ID, mailer.Message{
+		To:      form.Email,
+		Subject: "Confirm your new Snippetbin email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please confirm you want to use this email address for your Snippetbin account by opening the link below. It expires in %s.\n\n"+
+			"%s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n",
+			user.Username, app.emailVerificationTTL, app.baseURL+"/user/email/confirm?token="+url.QueryEscape(token)),
+	})
+	app.sendEmail(user.ID, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Snippetbin email address is being changed",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to change the email address of your account to %s. It changes once the link we sent there is opened.\n\n"+
+			"If this was not you, reset your password at %s and log out your other sessions.\n",
+			user.Username, form.Email, app.baseURL+"/user/password/forgot"),
+	})
+	logging.FromContext(ctx).InfoContext(ctx, "email change requested", slog.Int("user_id", user.ID))
+	app.sessionManager.Put(ctx, "flash", "We've sent a confirmation link to "+form.Email+". Your email address changes once you open it.")
+	http.Redirect(w, r, "/account/", http.StatusSeeOther)
+}
+
+// userConfirmEmailChange finishes a change of email address from the link
+// sent to the new address. The token names the old address too, so it
+// stops working once it has been used or the address has changed another
+// way.
+func (app *application) userConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	fail := func(msg string) {
+		data := app.newTemplateData(r)
+		data.Flash = msg
+		app.render(w, r, http.StatusBadRequest, "verify.html", data)
+	}
+
+	fields, err := app.tokens.Verify(tokenPurposeChangeEmail, r.URL.Query().Get("token"))
+	if err != nil || len(fields) != 3 {
+		fail("This confirmation link is invalid or has expired.")
+		return
+	}
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		fail("This confirmation link is invalid or has expired.")
+		return
+	}
+	if err := app.store.Users.UpdateEmail(ctx, id, fields[1], fields[2], app.clientIP(r)); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			fail("This confirmation link is invalid or has expired.")
+		case errors.Is(err, store.ErrDuplicateEmail):
+			fail("This email address is now used by another account.")
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "email changed", slog.Int("user_id", id))
+
+	app.sessionManager.Put(ctx, "flash", "Your email address has been changed.")
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/account/", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+type accountConfirmForm struct {
+	Password       string            `form:"password"`
+	Code           string            `form:"code"`
+	Next           string            `form:"next"`
+	TwoFactor      bool              `form:"-"`
+	NonFieldErrors []string          `form:"-"`
+	FieldErrors    map[string]string `form:"-"`
+}
+
+// reauthenticated reports whether the user confirmed it's them in this
+// session within reauthTimeout.
+func (app *application) reauthenticated(ctx context.Context) bool {
+	at := app.sessionManager.GetInt64(ctx, "reauthenticatedAt")
+	return at != 0 && time.Since(time.Unix(at, 0)) <= reauthTimeout
+}
+
+// requireReauthentication sends users who haven't confirmed it's them
+// within reauthTimeout to the confirm page, and from there back to the
+// page they asked for. A form posted too late is dropped rather than
+// replayed.
+func (app *application) requireReauthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.reauthenticated(r.Context()) {
+			http.Redirect(w, r, "/account/confirm?next="+url.QueryEscape(r.URL.Path), http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// localPath returns next if it is a path on this site, and the account
+// page otherwise, so it can't be used to send users elsewhere.
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/account/"
+	}
+	return next
+}
+
+func (app *application) accountConfirm(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = accountConfirmForm{Next: localPath(r.URL.Query().Get("next")), TwoFactor: user.TwoFactorEnabled}
+	app.render(w, r, http.StatusOK, "confirm.html", data)
+}
+
+// accountConfirmPost checks the user's password, or a code from their
+// authenticator app, and then sends them on to next. Failures count
+// towards the same limits as failed logins.
+func (app *application) accountConfirmPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var form accountConfirmForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	form.Next = localPath(form.Next)
+	form.TwoFactor = user.TwoFactorEnabled
+	if !form.TwoFactor {
+		form.Code = ""
+	}
+	rerender := func(status int, msg string) {
+		form.NonFieldErrors = []string{msg}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, status, "confirm.html", data)
+	}
+	if form.Password == "" && form.Code == "" {
+		if form.TwoFactor {
+			rerender(http.StatusUnprocessableEntity, "Enter your password or a code from your authenticator app")
+		} else {
+			rerender(http.StatusUnprocessableEntity, "Enter your password")
+		}
+		return
+	}
+
+	ip := app.clientIP(r)
+	if wait := app.loginWait(ctx, user.Email, ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(wait), 1)))
+		rerender(http.StatusTooManyRequests, "Too many failed attempts. Please try again later.")
+		return
+	}
+	var ok bool
+	method := "password"
+	if form.Password != "" {
+		withPassword, err := app.store.Users.GetByEmail(ctx, user.Email)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		err = withPassword.Password.Compare(form.Password)
+		if err != nil && !errors.Is(err, store.ErrInvalidCredentials) {
+			app.serverError(w, r, err)
+			return
+		}
+		ok = err == nil
+	} else {
+		method = "totp"
+		_, ok, err = app.checkSecondFactor(ctx, user.ID, form.Code)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	if !ok {
+		app.recordLoginFailure(ctx, user.Email, ip, user)
+		if form.Password != "" {
+			rerender(http.StatusUnprocessableEntity, "Password is incorrect")
+		} else {
+			rerender(http.StatusUnprocessableEntity, "That code is not right or has already been used")
+		}
+		return
+	}
+
+	app.sessionManager.Put(ctx, "reauthenticatedAt", time.Now().Unix())
+	logging.FromContext(ctx).InfoContext(ctx, "user reauthenticated", slog.Int("user_id", user.ID), slog.String("method", method))
+	http.Redirect(w, r, form.Next, http.StatusSeeOther)
+}
+
+type accountDeleteForm struct {
+	Password      string            `form:"password"`
+	PurgeSnippets bool              `form:"purgeSnippets"`
+	FieldErrors   map[string]string `form:"-"`
+}
+
+func (app *application) accountDelete(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountDeleteForm{}
+	data.Reauthenticated = app.reauthenticated(r.Context())
+	app.render(w, r, http.StatusOK, "delete.html", data)
+}
+
+// accountDeletePost deletes the user's account and logs them out. They
+// confirm it's them with their password, or beforehand on the confirm
+// page, which users who only log in with single sign-on have to use.
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var form accountDeleteForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	reauthenticated := app.reauthenticated(ctx)
+	rerender := func(field, msg string) {
+		form.FieldErrors = map[string]string{field: msg}
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.Reauthenticated = reauthenticated
+		app.render(w, r, http.StatusUnprocessableEntity, "delete.html", data)
+	}
+
+	user, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !reauthenticated {
+		if form.Password == "" {
+			rerender("password", "This field cannot be blank")
+			return
+		}
+		withPassword, err := app.store.Users.GetByEmail(ctx, user.Email)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if err := withPassword.Password.Compare(form.Password); err != nil {
+			if errors.Is(err, store.ErrInvalidCredentials) {
+				rerender("password", "Password is incorrect")
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+	}
+	if err := app.store.Users.Delete(ctx, user.ID, form.PurgeSnippets); err != nil {
+		if errors.Is(err, store.ErrLastOwner) {
+			rerender("orgs", "You are the only owner of an organization that has other members. Make one of them an owner first.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "account deleted", slog.Int("user_id", user.ID), slog.Bool("snippets_purged", form.PurgeSnippets))
+	app.sendEmail(user.ID, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Snippetbin account was deleted",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Your account and the personal data stored with it have been deleted, as you asked.\n\n"+
+			"If this was not you, please reply to this email.\n",
+			user.Username),
+	})
+
+	// The sessions went with the account; make that visible here too.
+	if err := app.sessionManager.RenewToken(ctx); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Remove(ctx, "authenticatedUserID")
+	app.sessionManager.Remove(ctx, "sessionID")
+	app.sessionManager.Put(ctx, "flash", "Your account has been deleted.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// accountExport is everything stored about a user, as downloaded from
+// /account/export. Snippet contents are left out: they are encrypted with
+// keys that only exist in their links.
+type accountExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+	User       struct {
+		ID                    int        `json:"id"`
+		Username              string     `json:"username"`
+		Email                 string     `json:"email"`
+		EmailVerifiedAt       *time.Time `json:"email_verified_at"`
+		CreatedAt             time.Time  `json:"created_at"`
+		TwoFactorEnabled      bool       `json:"two_factor_enabled"`
+		Role                  store.Role `json:"role"`
+		DisabledAt            *time.Time `json:"disabled_at"`
+		PasswordResetRequired bool       `json:"password_reset_required"`
+	} `json:"user"`
+	Sessions      []exportedSession      `json:"sessions"`
+	Passkeys      []exportedPasskey      `json:"passkeys"`
+	Identities    []exportedIdentity     `json:"identities"`
+	Organizations []exportedOrganization `json:"organizations"`
+	Snippets      []exportedSnippet      `json:"snippets"`
+	AuditLog      []exportedEvent        `json:"audit_log"`
+}
+
+type exportedSession struct {
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+type exportedPasskey struct {
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type exportedIdentity struct {
+	Issuer      string     `json:"issuer"`
+	Subject     string     `json:"subject"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+type exportedOrganization struct {
+	ID   int           `json:"id"`
+	Name string        `json:"name"`
+	Role store.OrgRole `json:"role"`
+}
+
+type exportedSnippet struct {
+	ID      int64     `json:"id"`
+	Title   string    `json:"title"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+type exportedEvent struct {
+	Action    string    `json:"action"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// optionalTime is nil for the zero time, which is how the store reports
+// that something never happened.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// accountExportDownload sends the user their personal data as a JSON
+// file.
+func (app *application) accountExportDownload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	sessions, err := app.store.Sessions.ListByUser(ctx, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	passkeys, err := app.store.WebAuthn.ListByUser(ctx, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	identities, err := app.store.Identities.ListByUser(ctx, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	orgs, err := app.store.Organizations.ListByUser(ctx, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	snippets, err := app.store.Snippets.ListByUser(ctx, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	events, err := app.store.Audit.ListByUser(ctx, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	export := accountExport{
+		ExportedAt:    time.Now().UTC(),
+		Sessions:      []exportedSession{},
+		Passkeys:      []exportedPasskey{},
+		Identities:    []exportedIdentity{},
+		Organizations: []exportedOrganization{},
+		Snippets:      []exportedSnippet{},
+		AuditLog:      []exportedEvent{},
+	}
+	export.User.ID = user.ID
+	export.User.Username = user.Username
+	export.User.Email = user.Email
+	export.User.EmailVerifiedAt = optionalTime(user.EmailVerifiedAt)
+	export.User.CreatedAt = user.CreatedAt
+	export.User.TwoFactorEnabled = user.TwoFactorEnabled
+	export.User.Role = user.Role
+	export.User.DisabledAt = optionalTime(user.DisabledAt)
+	export.User.PasswordResetRequired = user.PasswordResetRequired
+	for _, s := range sessions {
+		export.Sessions = append(export.Sessions, exportedSession{CreatedAt: s.CreatedAt, LastSeenAt: s.LastSeenAt, IP: s.IP, UserAgent: s.UserAgent})
+	}
+	for _, p := range passkeys {
+		export.Passkeys = append(export.Passkeys, exportedPasskey{Name: p.Name, CreatedAt: p.CreatedAt, LastUsedAt: optionalTime(p.LastUsedAt)})
+	}
+	for _, i := range identities {
+		export.Identities = append(export.Identities, exportedIdentity{Issuer: i.Issuer, Subject: i.Subject, CreatedAt: i.CreatedAt, LastLoginAt: optionalTime(i.LastLoginAt)})
+	}
+	for _, o := range orgs {
+		export.Organizations = append(export.Organizations, exportedOrganization{ID: o.ID, Name: o.Name, Role: o.Role})
+	}
+	for _, s := range snippets {
+		export.Snippets = append(export.Snippets, exportedSnippet{ID: s.ID, Title: s.Title, Created: s.Created, Expires: s.Expires})
+	}
+	for _, e := range events {
+		export.AuditLog = append(export.AuditLog, exportedEvent{Action: e.Action, OldValue: e.OldValue, NewValue: e.NewValue, IP: e.IP, CreatedAt: e.CreatedAt})
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="snippetbin-account.json"`)
+	w.Header().Set("Cache-Control", "no-store")
+	if err := app.writeJSON(w, http.StatusOK, export); err != nil {
+		app.logger.Error("writing account export", slog.String("error", err.Error()))
+	}
+}
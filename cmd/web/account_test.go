@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+func TestAccountExport(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/account/export")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	newOrg(t, ts, "365", "")
+	_, _, body := ts.get(t, "/snippet/create")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("title", "Notes")
+	form.Add("content", "secret")
+	form.Add("expires", "7")
+	code, _, _ = ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, header, body := ts.get(t, "/account/export")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+	assert.Equal(t, header.Get("Content-Disposition"), `attachment; filename="snippetbin-account.json"`)
+	var export accountExport
+	if err := json.Unmarshal([]byte(body), &export); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, export.User.Email, store.MockUser.Email)
+	assert.Equal(t, export.User.Username, store.MockUser.Username)
+	assert.Equal(t, export.User.Role, store.RoleUser)
+	assert.Equal(t, export.User.PasswordResetRequired, false)
+	assert.StringContains(t, body, `"disabled_at":null`)
+	assert.Equal(t, len(export.Sessions), 1)
+	assert.Equal(t, len(export.Passkeys), 0)
+	assert.Equal(t, len(export.Snippets), 1)
+	assert.Equal(t, export.Snippets[0].Title, "Notes")
+	assert.StringContains(t, body, `"identities":[]`)
+	assert.Equal(t, len(export.Organizations), 1)
+	assert.Equal(t, export.Organizations[0].Name, "Acme")
+	assert.Equal(t, export.Organizations[0].Role, store.OrgRoleOwner)
+	// Neither the password hash nor the snippet content is exported.
+	assert.Equal(t, strings.Contains(body, `"password"`), false)
+	assert.Equal(t, strings.Contains(body, "$2a$"), false)
+	assert.Equal(t, strings.Contains(body, "secret"), false)
+}
+
+func TestAccountConfirm(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, store.MockUser.Email, store.MockUserPassword)
+
+	_, _, body := ts.get(t, "/account/")
+	assert.StringContains(t, body, "Confirm it's you</a> to add a passkey.")
+	assert.Equal(t, strings.Contains(body, "data-passkey='register'"), false)
+
+	// Only paths on this site are followed afterwards.
+	code, _, body := ts.get(t, "/account/confirm?next=//evil.example/")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "name='next' value='/account/'")
+
+	confirm := func(password string) (int, http.Header, string) {
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		form.Add("password", password)
+		form.Add("next", "/account/sessions")
+		return ts.postForm(t, "/account/confirm", form)
+	}
+	code, _, respBody := confirm("")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, respBody, "Enter your password")
+	code, _, respBody = confirm("wrongpassword")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, respBody, "Password is incorrect")
+	code, header, _ := confirm(store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/sessions")
+
+	_, _, body = ts.get(t, "/account/")
+	assert.StringContains(t, body, "data-passkey='register'")
+
+	// Logging in again starts over.
+	ts.postForm(t, "/user/logout", url.Values{"csrf_token": {extractCSRFToken(t, body)}})
+	ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	_, _, body = ts.get(t, "/account/")
+	assert.Equal(t, strings.Contains(body, "data-passkey='register'"), false)
+}
+
+func TestAccountDelete(t *testing.T) {
+	tests := []struct {
+		name  string
+		purge bool
+		kept  int
+	}{
+		{"Keep snippets", false, 1},
+		{"Purge snippets", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, newConfig(t))
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+			other := newTestServer(t, app.routes())
+			defer other.Close()
+			ctx := context.Background()
+
+			ts.login(t, store.MockUser.Email, store.MockUserPassword)
+			other.login(t, store.MockUser.Email, store.MockUserPassword)
+			if _, err := app.store.Snippets.Insert(ctx, &store.Snippet{Title: "Mine", UserID: store.MockUser.ID}); err != nil {
+				t.Fatal(err)
+			}
+
+			code, _, body := ts.get(t, "/account/delete")
+			assert.Equal(t, code, http.StatusOK)
+			deleteAccount := func(password string) (int, http.Header, string) {
+				form := url.Values{}
+				form.Add("csrf_token", extractCSRFToken(t, body))
+				form.Add("password", password)
+				if tt.purge {
+					form.Add("purgeSnippets", "true")
+				}
+				return ts.postForm(t, "/account/delete", form)
+			}
+
+			code, _, respBody := deleteAccount("wrongpassword")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.StringContains(t, respBody, "Password is incorrect")
+			code, _, respBody = deleteAccount("")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.StringContains(t, respBody, "This field cannot be blank")
+
+			code, header, _ := deleteAccount(store.MockUserPassword)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/")
+
+			// Every session is gone, and so is the account.
+			for _, s := range []*testServer{ts, other} {
+				code, header, _ = s.get(t, "/account/")
+				assert.Equal(t, code, http.StatusSeeOther)
+				assert.Equal(t, header.Get("Location"), "/user/login")
+			}
+			code, _ = ts.login(t, store.MockUser.Email, store.MockUserPassword)
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+			snippets, err := app.store.Snippets.ListByUser(ctx, store.MockUser.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, len(snippets), 0)
+			// Kept snippets live on without an owner.
+			snippets, err = app.store.Snippets.ListByUser(ctx, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, len(snippets), tt.kept)
+
+			app.wg.Wait()
+			sent := app.mailer.(*testMailer).sent()
+			assert.Equal(t, len(sent), 1)
+			assert.StringContains(t, sent[0].Subject, "account was deleted")
+		})
+	}
+}
+
+func TestAccountUsername(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, store.MockUser.Email, store.MockUserPassword)
+
+	code, _, body := ts.get(t, "/account/username")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, store.MockUser.Username)
+
+	tests := []struct {
+		name     string
+		username string
+		wantCode int
+		wantBody string
+	}{
+		{"Blank", "  ", http.StatusUnprocessableEntity, "This field cannot be blank"},
+		{"Too short", "ab", http.StatusUnprocessableEntity, "at least 3 characters"},
+		{"Taken", store.MockUnverifiedUser.Username, http.StatusUnprocessableEntity, "Username is already in use"},
+		{"Valid", "renamed", http.StatusSeeOther, ""},
+		{"Unchanged", "renamed", http.StatusSeeOther, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", extractCSRFToken(t, body))
+			form.Add("username", tt.username)
+			code, _, respBody := ts.postForm(t, "/account/username", form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, respBody, tt.wantBody)
+		})
+	}
+
+	user, err := app.store.Users.GetByID(context.Background(), store.MockUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Username, "renamed")
+	events, err := app.store.Audit.ListByUser(context.Background(), store.MockUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Action, store.AuditUsernameChanged)
+	assert.Equal(t, events[0].OldValue, store.MockUser.Username)
+	assert.Equal(t, events[0].NewValue, "renamed")
+}
+
+func TestAccountEmailChange(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ctx := context.Background()
+	ts.login(t, store.MockUser.Email, store.MockUserPassword)
+
+	// Users confirm it's them first, with the same limits as logging in.
+	code, header, body := ts.get(t, "/account/email")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/confirm?next=%2Faccount%2Femail")
+	changeEmail := func(email string) (int, string) {
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		form.Add("email", email)
+		code, _, respBody := ts.postForm(t, "/account/email", form)
+		return code, respBody
+	}
+	_, _, body = ts.get(t, "/account/")
+	code, _ = changeEmail("new@example.com")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	assert.Equal(t, ts.reauthenticate(t, store.MockUserPassword), http.StatusSeeOther)
+	code, _, body = ts.get(t, "/account/email")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(body, "name='password'"), false)
+
+	code, respBody := changeEmail("not-an-email")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, respBody, "valid email address")
+	code, respBody = changeEmail(store.MockUnverifiedUser.Email)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, respBody, "Email is already in use")
+	code, respBody = changeEmail(store.MockUser.Email)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, respBody, "already your email address")
+
+	code, _ = changeEmail("new@example.com")
+	assert.Equal(t, code, http.StatusSeeOther)
+	// Nothing changes until the new address is confirmed.
+	user, err := app.store.Users.GetByID(ctx, store.MockUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Email, store.MockUser.Email)
+
+	app.wg.Wait()
+	sent := app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 2)
+	var link string
+	for _, msg := range sent {
+		switch msg.To {
+		case "new@example.com":
+			_, after, _ := strings.Cut(msg.Body, "https://snippetbin.test")
+			link, _, _ = strings.Cut(after, "\n")
+		case store.MockUser.Email:
+			assert.StringContains(t, msg.Body, "new@example.com")
+		default:
+			t.Fatalf("unexpected email to %s", msg.To)
+		}
+	}
+	assert.StringContains(t, link, "/user/email/confirm?token=")
+
+	code, header, _ = ts.get(t, link)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/")
+	user, err = app.store.Users.GetByID(ctx, store.MockUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Email, "new@example.com")
+	_, err = app.store.Users.GetByEmail(ctx, store.MockUser.Email)
+	assert.Equal(t, err, store.ErrInvalidCredentials)
+
+	// The link works once.
+	code, _, _ = ts.get(t, link)
+	assert.Equal(t, code, http.StatusBadRequest)
+
+	events, err := app.store.Audit.ListByUser(ctx, store.MockUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Action, store.AuditEmailChanged)
+	assert.Equal(t, events[1].Action, store.AuditEmailChangeRequested)
+	assert.Equal(t, events[0].OldValue, store.MockUser.Email)
+	assert.Equal(t, events[0].NewValue, "new@example.com")
+}
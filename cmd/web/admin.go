@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/theluminousartemis/snippetbin/internal/ratelimiter"
+)
+
+// requireAdminToken lets through only requests bearing the configured admin
+// token. Anything that reaches the admin listener can read from it, but
+// changing runtime settings needs the token, and is refused outright while
+// none is configured.
+func (app *application) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.adminToken == "" {
+			app.writeJSON(w, http.StatusForbidden, map[string]string{"error": "runtime changes are disabled; set admin.token to enable them"})
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.adminToken)) != 1 {
+			app.logger.Warn("admin request rejected", slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.String("remote_addr", r.RemoteAddr))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing admin token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimiterSettings is the JSON form of a policy's ratelimiter.Config.
+// Fields left out of an update keep their current value; the policy is
+// taken from the URL.
+type rateLimiterSettings struct {
+	Policy               string  `json:"policy"`
+	Enabled              *bool   `json:"enabled"`
+	RequestsPerTimeFrame *int    `json:"requests_per_time_frame"`
+	Timeframe            *string `json:"timeframe"`
+	Burst                *int    `json:"burst"`
+}
+
+// policyLimiter returns the limiter for the {policy} URL parameter, or the
+// default policy's limiter when there is none, and writes a 404 for an
+// unknown policy.
+func (app *application) policyLimiter(w http.ResponseWriter, r *http.Request) (string, ratelimiter.Limiter, bool) {
+	policy := chi.URLParam(r, "policy")
+	if policy == "" {
+		policy = policyDefault
+	}
+	limiter, ok := app.rateLimiters[policy]
+	if !ok {
+		app.writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown rate limit policy"})
+		return "", nil, false
+	}
+	return policy, limiter, true
+}
+
+func (app *application) rateLimiterSettings(w http.ResponseWriter, r *http.Request) {
+	policy, limiter, ok := app.policyLimiter(w, r)
+	if !ok {
+		return
+	}
+	cfg := limiter.Config()
+	timeframe := cfg.Timeframe.String()
+	err := app.writeJSON(w, http.StatusOK, rateLimiterSettings{
+		Policy:               policy,
+		Enabled:              &cfg.Enabled,
+		RequestsPerTimeFrame: &cfg.RequestsPerTimeFrame,
+		Timeframe:            &timeframe,
+		Burst:                &cfg.Burst,
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) rateLimiterSettingsUpdate(w http.ResponseWriter, r *http.Request) {
+	policy, limiter, ok := app.policyLimiter(w, r)
+	if !ok {
+		return
+	}
+	var input rateLimiterSettings
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if input.Policy != "" && input.Policy != policy {
+		app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "policy does not match the URL"})
+		return
+	}
+
+	old := limiter.Config()
+	cfg := old
+	if input.Enabled != nil {
+		cfg.Enabled = *input.Enabled
+	}
+	if input.RequestsPerTimeFrame != nil {
+		cfg.RequestsPerTimeFrame = *input.RequestsPerTimeFrame
+	}
+	if input.Burst != nil {
+		cfg.Burst = *input.Burst
+	}
+	if input.Timeframe != nil {
+		timeframe, err := time.ParseDuration(*input.Timeframe)
+		if err != nil {
+			app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "timeframe must be a duration such as 2m"})
+			return
+		}
+		cfg.Timeframe = timeframe
+	}
+
+	if err := limiter.SetConfig(cfg); err != nil {
+		app.writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	app.logger.Info("rate limiter reconfigured",
+		slog.String("policy", policy),
+		slog.Bool("enabled", cfg.Enabled),
+		slog.Int("requests_per_time_frame", cfg.RequestsPerTimeFrame),
+		slog.Duration("timeframe", cfg.Timeframe),
+		slog.Int("burst", cfg.Burst),
+		slog.Bool("previous_enabled", old.Enabled),
+		slog.Int("previous_requests_per_time_frame", old.RequestsPerTimeFrame),
+		slog.Duration("previous_timeframe", old.Timeframe),
+		slog.Int("previous_burst", old.Burst),
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+	app.rateLimiterSettings(w, r)
+}
+
+type lockoutStatus struct {
+	Failures          int `json:"failures"`
+	BlockedForSeconds int `json:"blocked_for_seconds"`
+}
+
+// lockoutSubjects reads the email and ip query parameters, at least one of
+// which is required. An IPv6 address is grouped by prefix the way the login
+// guard counts it.
+func (app *application) lockoutSubjects(w http.ResponseWriter, r *http.Request) (email, ip string, ok bool) {
+	email = r.URL.Query().Get("email")
+	if s := r.URL.Query().Get("ip"); s != "" {
+		addr, err := ratelimiter.ClientIP(s)
+		if err != nil {
+			app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "ip must be an IP address"})
+			return "", "", false
+		}
+		ip = ratelimiter.IPKey(addr, app.rateLimitIPv6PrefixLen)
+	}
+	if email == "" && ip == "" {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "email or ip is required"})
+		return "", "", false
+	}
+	return email, ip, true
+}
+
+func (app *application) lockoutStatus(w http.ResponseWriter, r *http.Request) {
+	email, ip, ok := app.lockoutSubjects(w, r)
+	if !ok {
+		return
+	}
+	data := map[string]lockoutStatus{}
+	if email != "" {
+		status, err := app.loginGuard.AccountStatus(r.Context(), email)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data["account"] = lockoutStatus{Failures: status.Failures, BlockedForSeconds: ceilSeconds(status.BlockedFor)}
+	}
+	if ip != "" {
+		status, err := app.loginGuard.IPStatus(r.Context(), ip)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data["ip"] = lockoutStatus{Failures: status.Failures, BlockedForSeconds: ceilSeconds(status.BlockedFor)}
+	}
+	if err := app.writeJSON(w, http.StatusOK, data); err != nil {
+		app.serverError(w, r, err)
+	}
+}
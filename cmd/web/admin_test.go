@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+const testAdminToken = "test-admin-token"
+
+func (ts *testServer) patchJSON(t *testing.T, urlPath, body string) (int, string) {
+	return ts.patchJSONWithToken(t, urlPath, body, testAdminToken)
+}
+
+func (ts *testServer) patchJSONWithToken(t *testing.T, urlPath, body, token string) (int, string) {
+	req, err := http.NewRequest(http.MethodPatch, ts.URL+urlPath, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	b, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.StatusCode, string(b)
+}
+
+func TestRateLimiterSettings(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.rateLimit.policies[policyDefault].RequestsPerTimeFrame = 1
+	app := newTestApplication(t, cfg)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	admin := newTestServer(t, app.adminRoutes())
+	defer admin.Close()
+
+	code, _, _ := ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusOK)
+	code, _, _ = ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	code, body := admin.patchJSON(t, "/ratelimiter", `{"enabled": false}`)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"enabled":false`)
+	assert.StringContains(t, body, `"requests_per_time_frame":1`)
+
+	code, _, _ = ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusOK)
+
+	code, body = admin.patchJSON(t, "/ratelimiter", `{"enabled": true, "requests_per_time_frame": 100, "timeframe": "30s"}`)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"timeframe":"30s"`)
+	assert.Equal(t, app.rateLimiters[policyDefault].Config().Timeframe, 30*time.Second)
+
+	code, _, _ = ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusOK)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{name: "Zero limit", body: `{"requests_per_time_frame": 0}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "Bad timeframe", body: `{"timeframe": "soon"}`, wantCode: http.StatusUnprocessableEntity},
+		{name: "Unknown field", body: `{"limit": 5}`, wantCode: http.StatusBadRequest},
+		{name: "Malformed JSON", body: `{`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := admin.patchJSON(t, "/ratelimiter", tt.body)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, app.rateLimiters[policyDefault].Config().RequestsPerTimeFrame, 100)
+		})
+	}
+
+	code, _, body = admin.get(t, "/ratelimiter")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"requests_per_time_frame":100`)
+}
+
+func TestRateLimiterPolicySettings(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	admin := newTestServer(t, app.adminRoutes())
+	defer admin.Close()
+
+	code, body := admin.patchJSON(t, "/ratelimiter/auth", `{"requests_per_time_frame": 5, "timeframe": "10m"}`)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"policy":"auth"`)
+	assert.Equal(t, app.rateLimiters[policyAuth].Config().RequestsPerTimeFrame, 5)
+	assert.Equal(t, app.rateLimiters[policyDefault].Config().RequestsPerTimeFrame, 20)
+
+	code, _ = admin.patchJSON(t, "/ratelimiter", `{"policy": "auth", "requests_per_time_frame": 1}`)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	code, _, _ = admin.get(t, "/ratelimiter/unknown")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestRateLimiterSettingsRequireToken(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	admin := newTestServer(t, app.adminRoutes())
+	defer admin.Close()
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "No token", token: ""},
+		{name: "Wrong token", token: "not-the-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := admin.patchJSONWithToken(t, "/ratelimiter/auth", `{"enabled": false}`, tt.token)
+			assert.Equal(t, code, http.StatusUnauthorized)
+			assert.Equal(t, app.rateLimiters[policyAuth].Config().Enabled, true)
+		})
+	}
+
+	// Reading needs no token.
+	code, _, _ := admin.get(t, "/ratelimiter/auth")
+	assert.Equal(t, code, http.StatusOK)
+
+	app.adminToken = ""
+	code, _ = admin.patchJSON(t, "/ratelimiter/auth", `{"enabled": false}`)
+	assert.Equal(t, code, http.StatusForbidden)
+	assert.Equal(t, app.rateLimiters[policyAuth].Config().Enabled, true)
+}
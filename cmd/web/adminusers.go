@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/theluminousartemis/snippetbin/internal/logging"
+	"github.com/theluminousartemis/snippetbin/internal/mailer"
+	"github.com/theluminousartemis/snippetbin/internal/ratelimiter"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+// adminPageSize is the number of users on each page of the admin area.
+const adminPageSize = 50
+
+// adminUsersView is the user list of the admin area.
+type adminUsersView struct {
+	IsAdmin  bool
+	Query    string
+	Users    []store.UserSummary
+	Stats    store.SnippetStats
+	Page     int
+	PrevPage int
+	NextPage int
+}
+
+// adminUserView is one user as seen from the admin area. CanManage and
+// IsAdmin say which actions the viewer may take.
+type adminUserView struct {
+	User      store.User
+	Snippets  int
+	AuditLog  []store.AuditEvent
+	CanManage bool
+	IsAdmin   bool
+	Roles     []store.Role
+	// LoginFailures and LockedUntil are the user's failed logins, as
+	// counted by the login guard.
+	LoginFailures int
+	LockedUntil   time.Time
+}
+
+type adminRoleForm struct {
+	Role string `form:"role"`
+}
+
+type adminUnlockIPForm struct {
+	IP string `form:"ip"`
+}
+
+// canManage reports whether actor may change target's account. Nobody
+// manages themselves, and moderators manage only ordinary users.
+func canManage(actor, target *store.User) bool {
+	return actor.ID != target.ID && (actor.Role.AtLeast(store.RoleAdmin) || !target.Role.AtLeast(actor.Role))
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	actor, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	users, err := app.store.Users.Search(ctx, query, adminPageSize+1, (page-1)*adminPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	stats, err := app.store.Snippets.Stats(ctx)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	view := adminUsersView{IsAdmin: actor.Role.AtLeast(store.RoleAdmin), Query: query, Stats: stats, Page: page, PrevPage: page - 1}
+	if len(users) > adminPageSize {
+		users = users[:adminPageSize]
+		view.NextPage = page + 1
+	}
+	view.Users = users
+	data := app.newTemplateData(r)
+	data.AdminUsers = &view
+	app.render(w, r, http.StatusOK, "admin_users.html", data)
+}
+
+// adminTarget loads the signed-in user and the user named in the URL. It
+// writes the response itself and returns false if there is no such user.
+func (app *application) adminTarget(w http.ResponseWriter, r *http.Request) (actor, target *store.User, ok bool) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return nil, nil, false
+	}
+	actor, err = app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return nil, nil, false
+	}
+	target, err = app.store.Users.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidCredentials) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, nil, false
+	}
+	return actor, target, true
+}
+
+// adminManagedTarget is adminTarget for actions that change the user, and
+// also refuses users the signed-in user may not manage.
+func (app *application) adminManagedTarget(w http.ResponseWriter, r *http.Request) (actor, target *store.User, ok bool) {
+	actor, target, ok = app.adminTarget(w, r)
+	if ok && !canManage(actor, target) {
+		app.clientError(w, http.StatusForbidden)
+		return nil, nil, false
+	}
+	return actor, target, ok
+}
+
+func (app *application) adminUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	actor, target, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	snippets, err := app.store.Snippets.ListByUser(ctx, target.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	events, err := app.store.Audit.ListByUser(ctx, target.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	view := &adminUserView{
+		User:      *target,
+		Snippets:  len(snippets),
+		AuditLog:  events,
+		CanManage: canManage(actor, target),
+		IsAdmin:   actor.Role.AtLeast(store.RoleAdmin),
+		Roles:     store.Roles,
+	}
+	// The page still works while the cache is down, without the lockout.
+	if status, err := app.loginGuard.AccountStatus(ctx, target.Email); err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "login guard unavailable", slog.String("error", err.Error()))
+	} else {
+		view.LoginFailures = status.Failures
+		if status.BlockedFor > 0 {
+			view.LockedUntil = time.Now().Add(status.BlockedFor)
+		}
+	}
+	data := app.newTemplateData(r)
+	data.AdminUser = view
+	app.render(w, r, http.StatusOK, "admin_user.html", data)
+}
+
+func (app *application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	app.adminSetDisabled(w, r, true)
+}
+
+func (app *application) adminUserEnablePost(w http.ResponseWriter, r *http.Request) {
+	app.adminSetDisabled(w, r, false)
+}
+
+func (app *application) adminSetDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	ctx := r.Context()
+	actor, target, ok := app.adminManagedTarget(w, r)
+	if !ok {
+		return
+	}
+	if err := app.store.Users.SetDisabled(ctx, target.ID, disabled, actor.ID, app.clientIP(r)); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	flash := target.Username + " has been enabled."
+	if disabled {
+		flash = target.Username + " has been disabled and logged out."
+		app.sendEmail(target.ID, mailer.Message{
+			To:      target.Email,
+			Subject: "Your Snippetbin account has been disabled",
+			Body: fmt.Sprintf("Hi %s,\n\n"+
+				"Your account has been disabled by a moderator, so you can no longer log in. "+
+				"Your snippets stay online until they expire.\n\n"+
+				"If you think this is a mistake, please reply to this email.\n",
+				target.Username),
+		})
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "user disabled changed", slog.Int("target_user_id", target.ID), slog.Bool("disabled", disabled))
+	app.sessionManager.Put(ctx, "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", target.ID), http.StatusSeeOther)
+}
+
+// adminUserPasswordResetPost makes the user choose a new password: the
+// old one stops working for login, every session is logged out and a
+// reset link is emailed to them.
+func (app *application) adminUserPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	actor, target, ok := app.adminManagedTarget(w, r)
+	if !ok {
+		return
+	}
+	if err := app.store.Users.RequirePasswordReset(ctx, target.ID, actor.ID, app.clientIP(r)); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	link, err := app.newPasswordResetLink(ctx, target.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sendEmail(target.ID, mailer.Message{
+		To:      target.Email,
+		Subject: "Please choose a new Snippetbin password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"An administrator has asked you to choose a new password, and has logged you out everywhere. "+
+			"Your current password won't log you in until you do. Open the link below to choose one. "+
+			"It expires in %s and can only be used once.\n\n"+
+			"%s\n\n"+
+			"If the link expires, you can ask for a new one with Forgot password on the login page.\n",
+			target.Username, app.passwordResetTTL, link),
+	})
+	logging.FromContext(ctx).InfoContext(ctx, "password reset forced", slog.Int("target_user_id", target.ID))
+	app.sessionManager.Put(ctx, "flash", target.Username+" has been logged out and must choose a new password.")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", target.ID), http.StatusSeeOther)
+}
+
+func (app *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	actor, target, ok := app.adminManagedTarget(w, r)
+	if !ok {
+		return
+	}
+	var form adminRoleForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	role, err := store.ParseRole(form.Role)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if err := app.store.Users.SetRole(ctx, target.ID, role, actor.ID, app.clientIP(r)); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "role changed", slog.Int("target_user_id", target.ID), slog.String("role", string(role)))
+	app.sessionManager.Put(ctx, "flash", fmt.Sprintf("%s now has the %s role.", target.Username, role))
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", target.ID), http.StatusSeeOther)
+}
+
+// adminUserUnlockPost lifts a lockout or backoff on the user's account
+// and forgets their failed logins.
+func (app *application) adminUserUnlockPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	actor, target, ok := app.adminManagedTarget(w, r)
+	if !ok {
+		return
+	}
+	err := app.store.Audit.Insert(ctx, &store.AuditEvent{UserID: target.ID, ActorID: actor.ID, Action: store.AuditUnlocked, IP: app.clientIP(r)})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.loginGuard.UnlockAccount(ctx, target.Email); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "account unlocked", slog.Int("target_user_id", target.ID))
+	app.sessionManager.Put(ctx, "flash", target.Username+" has been unlocked.")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", target.ID), http.StatusSeeOther)
+}
+
+// adminUnlockIPPost lifts a backoff on a client IP and forgets its failed
+// logins. An IPv6 address is grouped by prefix the way the login guard
+// counts it.
+func (app *application) adminUnlockIPPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var form adminUnlockIPForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	addr, err := ratelimiter.ClientIP(strings.TrimSpace(form.IP))
+	if err != nil {
+		app.sessionManager.Put(ctx, "flash", "That is not an IP address.")
+		http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+		return
+	}
+	ip := ratelimiter.IPKey(addr, app.rateLimitIPv6PrefixLen)
+
+	actorID := app.sessionManager.GetInt(ctx, "authenticatedUserID")
+	err = app.store.Audit.Insert(ctx, &store.AuditEvent{UserID: actorID, ActorID: actorID, Action: store.AuditIPUnlocked, NewValue: ip, IP: app.clientIP(r)})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.loginGuard.UnlockIP(ctx, ip); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "client IP unlocked", slog.String("unlocked_ip", ip))
+	app.sessionManager.Put(ctx, "flash", ip+" has been unlocked.")
+	http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+}
+
+const setRoleUsage = "usage: web set-role EMAIL user|moderator|admin"
+
+// runSetRole implements the "web set-role" subcommand, which is how the
+// first admin is made.
+func runSetRole(ctx context.Context, users interface {
+	GetByEmail(context.Context, string) (*store.User, error)
+	SetRole(ctx context.Context, id int, role store.Role, actorID int, ip string) error
+}, args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return errors.New(setRoleUsage)
+	}
+	role, err := store.ParseRole(args[1])
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, setRoleUsage)
+	}
+	user, err := users.GetByEmail(ctx, args[0])
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidCredentials) {
+			return fmt.Errorf("no user with email %q", args[0])
+		}
+		return err
+	}
+	if err := users.SetRole(ctx, user.ID, role, 0, ""); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s now has the %s role\n", user.Email, role)
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+func TestAdminRequiresRole(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+
+	tests := []struct {
+		name     string
+		email    string
+		wantCode int
+	}{
+		{"Anonymous", "", http.StatusSeeOther},
+		{"User", store.MockUser.Email, http.StatusForbidden},
+		{"Moderator", store.MockModeratorUser.Email, http.StatusOK},
+		{"Admin", store.MockAdminUser.Email, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+			if tt.email != "" {
+				ts.login(t, tt.email, store.MockUserPassword)
+			}
+			code, _, _ := ts.get(t, "/admin/")
+			assert.Equal(t, code, tt.wantCode)
+			code, _, _ = ts.get(t, "/admin/users/1")
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, store.MockModeratorUser.Email, store.MockUserPassword)
+
+	code, _, body := ts.get(t, "/admin/")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, store.MockUser.Email)
+	assert.StringContains(t, body, store.MockAdminUser.Email)
+	assert.StringContains(t, body, "1 active, of which 0 have an owner")
+
+	_, _, body = ts.get(t, "/admin/?q=unverified")
+	assert.StringContains(t, body, store.MockUnverifiedUser.Email)
+	assert.Equal(t, strings.Contains(body, store.MockUser.Email), false)
+
+	code, _, body = ts.get(t, "/admin/users/1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, store.MockUser.Username)
+	assert.StringContains(t, body, "Disable account")
+	// Only admins can force a password reset or change roles.
+	assert.Equal(t, strings.Contains(body, "Force password reset"), false)
+
+	code, _, _ = ts.get(t, "/admin/users/99")
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _, _ = ts.get(t, "/admin/users/abc")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestAdminDisableUser(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	mod := newTestServer(t, app.routes())
+	defer mod.Close()
+	user := newTestServer(t, app.routes())
+	defer user.Close()
+	mod.login(t, store.MockModeratorUser.Email, store.MockUserPassword)
+	user.login(t, store.MockUser.Email, store.MockUserPassword)
+
+	_, _, body := mod.get(t, "/admin/users/1")
+	post := func(path string) int {
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ := mod.postForm(t, path, form)
+		return code
+	}
+
+	// Moderators can't act on themselves, admins or other moderators, and
+	// can't use the admin-only actions at all.
+	assert.Equal(t, post("/admin/users/4/disable"), http.StatusForbidden)
+	assert.Equal(t, post("/admin/users/5/disable"), http.StatusForbidden)
+	assert.Equal(t, post("/admin/users/1/password-reset"), http.StatusForbidden)
+	assert.Equal(t, post("/admin/users/1/role"), http.StatusForbidden)
+
+	assert.Equal(t, post("/admin/users/1/disable"), http.StatusSeeOther)
+	code, header, _ := user.get(t, "/account/")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+	code, location := user.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, location, "/user/login")
+	_, _, loginPage := user.get(t, location)
+	assert.StringContains(t, loginPage, "This account has been disabled.")
+
+	app.wg.Wait()
+	sent := app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, store.MockUser.Email)
+	assert.StringContains(t, sent[0].Subject, "disabled")
+
+	assert.Equal(t, post("/admin/users/1/enable"), http.StatusSeeOther)
+	_, location = user.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, location, "/snippet/create")
+
+	events, err := app.store.Audit.ListByUser(context.Background(), store.MockUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Action, store.AuditEnabled)
+	assert.Equal(t, events[1].Action, store.AuditDisabled)
+	assert.Equal(t, events[1].ActorID, store.MockModeratorUser.ID)
+}
+
+func TestAdminForcePasswordReset(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	admin := newTestServer(t, app.routes())
+	defer admin.Close()
+	user := newTestServer(t, app.routes())
+	defer user.Close()
+	admin.login(t, store.MockAdminUser.Email, store.MockUserPassword)
+	user.login(t, store.MockUser.Email, store.MockUserPassword)
+
+	_, _, body := admin.get(t, "/admin/users/1")
+	assert.StringContains(t, body, "Force password reset")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := admin.postForm(t, "/admin/users/1/password-reset", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// The user is logged out and their password no longer logs them in.
+	code, _, _ = user.get(t, "/account/")
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _ = user.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, code, http.StatusForbidden)
+
+	app.wg.Wait()
+	sent := app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 1)
+	_, after, _ := strings.Cut(sent[0].Body, "https://snippetbin.test")
+	link, _, _ := strings.Cut(after, "\n")
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Path, "/user/password/reset")
+
+	_, _, body = user.get(t, link)
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("token", u.Query().Get("token"))
+	form.Add("newPassword", "quiet-harbour-velvet-17")
+	form.Add("newPasswordConfirmation", "quiet-harbour-velvet-17")
+	code, _, _ = user.postForm(t, "/user/password/reset", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	_, location := user.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, location, "/snippet/create")
+}
+
+func TestAdminSetRole(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	admin := newTestServer(t, app.routes())
+	defer admin.Close()
+	admin.login(t, store.MockAdminUser.Email, store.MockUserPassword)
+
+	_, _, body := admin.get(t, "/admin/users/1")
+	setRole := func(id, role string) int {
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		form.Add("role", role)
+		code, _, _ := admin.postForm(t, "/admin/users/"+id+"/role", form)
+		return code
+	}
+	assert.Equal(t, setRole("1", "superuser"), http.StatusBadRequest)
+	assert.Equal(t, setRole("5", "user"), http.StatusForbidden)
+	assert.Equal(t, setRole("1", "moderator"), http.StatusSeeOther)
+
+	user := newTestServer(t, app.routes())
+	defer user.Close()
+	user.login(t, store.MockUser.Email, store.MockUserPassword)
+	code, _, _ := user.get(t, "/admin/")
+	assert.Equal(t, code, http.StatusOK)
+
+	// Taking the role away works straight away.
+	assert.Equal(t, setRole("1", "user"), http.StatusSeeOther)
+	code, _, _ = user.get(t, "/admin/")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	_, _, body = admin.get(t, "/admin/users/1")
+	assert.StringContains(t, body, "role_changed")
+	assert.StringContains(t, body, "/admin/users/5")
+}
+
+func TestAdminUnlockIP(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.login.IPFreeAttempts = 2
+	app := newTestApplication(t, cfg)
+	admin := newTestServer(t, app.routes())
+	defer admin.Close()
+	moderator := newTestServer(t, app.routes())
+	defer moderator.Close()
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	admin.login(t, store.MockAdminUser.Email, store.MockUserPassword)
+	moderator.login(t, store.MockModeratorUser.Email, store.MockUserPassword)
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+	login := func() int {
+		form := url.Values{}
+		form.Add("email", "nobody@example.com")
+		form.Add("password", "wrongpassword")
+		form.Add("csrf_token", csrfToken)
+		code, _, _ := ts.postForm(t, "/user/login", form)
+		return code
+	}
+	for range 3 {
+		assert.Equal(t, login(), http.StatusUnprocessableEntity)
+	}
+	assert.Equal(t, login(), http.StatusTooManyRequests)
+
+	unlock := func(ts *testServer) int {
+		_, _, body := ts.get(t, "/admin/")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		form.Add("ip", "127.0.0.1")
+		code, _, _ := ts.postForm(t, "/admin/lockouts/ip/unlock", form)
+		return code
+	}
+	assert.Equal(t, unlock(moderator), http.StatusForbidden)
+	assert.Equal(t, login(), http.StatusTooManyRequests)
+	assert.Equal(t, unlock(admin), http.StatusSeeOther)
+	assert.Equal(t, login(), http.StatusUnprocessableEntity)
+
+	events, err := app.store.Audit.ListByUser(context.Background(), store.MockAdminUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, events[0].Action, store.AuditIPUnlocked)
+	assert.Equal(t, events[0].NewValue, "127.0.0.1")
+}
+
+func TestRunSetRole(t *testing.T) {
+	users := store.NewStorage().Users
+	ctx := context.Background()
+	var out bytes.Buffer
+
+	err := runSetRole(ctx, users, []string{store.MockUser.Email, "admin"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out.String(), "valid@example.com now has the admin role\n")
+	user, err := users.GetByID(ctx, store.MockUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Role, store.RoleAdmin)
+
+	err = runSetRole(ctx, users, []string{store.MockUser.Email, "root"}, &out)
+	assert.StringContains(t, err.Error(), `unknown role "root"`)
+	err = runSetRole(ctx, users, []string{"nobody@example.com", "admin"}, &out)
+	assert.StringContains(t, err.Error(), "no user with email")
+	err = runSetRole(ctx, users, nil, &out)
+	assert.Equal(t, err.Error(), setRoleUsage)
+}
@@ -4,68 +4,87 @@ import (
 	"html/template"
 	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-playground/form/v4"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/theluminousartemis/snippetbin/internal/loginguard"
+	"github.com/theluminousartemis/snippetbin/internal/mailer"
+	"github.com/theluminousartemis/snippetbin/internal/metrics"
+	"github.com/theluminousartemis/snippetbin/internal/passwordpolicy"
 	"github.com/theluminousartemis/snippetbin/internal/ratelimiter"
+	"github.com/theluminousartemis/snippetbin/internal/signedtoken"
 	"github.com/theluminousartemis/snippetbin/internal/store"
 	"github.com/theluminousartemis/snippetbin/internal/store/cache"
 	"github.com/theluminousartemis/snippetbin/ui"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-type config struct {
-	addr     string
-	db       dbConfig
-	redisCfg redisConfig
-	rlCfg    ratelimiterConfig
-}
-
-type ratelimiterConfig struct {
-	RequestsPerTimeFrame int
-	Timeframe            time.Duration
-	Enabled              bool
-}
-
-type dbConfig struct {
-	addr         string
-	maxOpenConns int
-	maxIdleConns int
-	maxIdleTime  string
-}
-
-type redisConfig struct {
-	addr     string
-	password string
-	db       int
-}
-
 type application struct {
-	logger         *slog.Logger
-	store          store.Storage
-	cache          cache.Storage
-	templateCache  map[string]*template.Template
-	formDecoder    *form.Decoder
-	sessionManager *scs.SessionManager
-	rateLimiter    ratelimiter.Limiter
+	logger          *slog.Logger
+	store           store.Storage
+	cache           cache.Storage
+	templateCache   map[string]*template.Template
+	formDecoder     *form.Decoder
+	sessionManager  *scs.SessionManager
+	readinessChecks map[string]dependencyCheck
+	optionalChecks  map[string]dependencyCheck
+	metrics         *metrics.Metrics
+	draining        atomic.Bool
+	wg              sync.WaitGroup
+
+	loginGuard     *loginguard.Guard
+	passwordPolicy *passwordpolicy.Policy
+	mailer         mailer.Mailer
+
+	// baseURL is the public URL of the site, for links in emails.
+	baseURL              string
+	tokens               *signedtoken.Signer
+	emailVerificationTTL time.Duration
+	passwordResetTTL     time.Duration
+
+	// totpKey encrypts stored TOTP secrets. Two-factor enrollment is off
+	// when it is nil.
+	totpKey    []byte
+	totpIssuer string
+
+	webAuthn *webauthn.WebAuthn
+	// oidc is nil unless single sign-on is configured.
+	oidc           *oidcLogin
+	passwordSignup bool
+
+	rateLimiters           map[string]ratelimiter.Limiter
+	rateLimitAllowlist     ratelimiter.Allowlist
+	rateLimitIPv6PrefixLen int
+	// trustedProxies may name the client in forwarded headers; see realIP.
+	trustedProxies ratelimiter.Allowlist
+	// adminToken guards changes on the admin listener; see requireAdminToken.
+	adminToken string
 }
 
 func (app *application) routes() http.Handler {
 	r := chi.NewRouter()
 
 	// === Global middleware ===
+	r.Use(otelhttp.NewMiddleware("snippetbin", otelhttp.WithFilter(shouldTrace)))
+	r.Use(traceRoute)
 	r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
-	r.Use(middleware.RealIP)
+	r.Use(app.realIP)
+	r.Use(app.metricsMiddleware)
+	r.Use(app.logRequest)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(60 * time.Second))
 	r.Use(commonHeaders)
 
-	// //ratelimiter
-	r.Use(app.RateLimiterMiddleware)
 	r.Get("/ping", ping)
+	r.Get("/health", app.health)
+	r.Get("/livez", app.livez)
+	r.Get("/readyz", app.readyz)
 
 	// === Static files ===
 	fs := http.FileServer(http.FS(ui.Files))
@@ -73,11 +92,11 @@ func (app *application) routes() http.Handler {
 
 	// === Public routes ===
 	r.Group(func(r chi.Router) {
+		r.Use(app.rateLimitIP)
 		r.Use(app.sessionManager.LoadAndSave)
 		r.Use(noSurf)
 		r.Use(app.authenticate)
-
-		r.Get("/health", app.health)
+		r.Use(app.rateLimitUser)
 
 		r.Get("/", app.home)
 		r.Get("/snippet/view/{id}", app.snippetView)
@@ -87,6 +106,20 @@ func (app *application) routes() http.Handler {
 		r.Post("/user/signup", app.userSignupPost)
 		r.Get("/user/login", app.userLogin)
 		r.Post("/user/login", app.userLoginPost)
+		r.Get("/user/login/2fa", app.userLoginTwoFactor)
+		r.Post("/user/login/2fa", app.userLoginTwoFactorPost)
+		r.Post("/user/login/2fa/passkey/begin", app.userPasskeySecondFactorBegin)
+		r.Post("/user/login/2fa/passkey/finish", app.userPasskeySecondFactorFinish)
+		r.Post("/user/login/passkey/begin", app.userPasskeyLoginBegin)
+		r.Post("/user/login/passkey/finish", app.userPasskeyLoginFinish)
+		r.Get("/user/login/oidc", app.userLoginOIDC)
+		r.Get("/user/login/oidc/callback", app.userLoginOIDCCallback)
+		r.Get("/user/verify", app.userVerifyEmail)
+		r.Get("/user/email/confirm", app.userConfirmEmailChange)
+		r.Get("/user/password/forgot", app.userPasswordForgot)
+		r.Post("/user/password/forgot", app.userPasswordForgotPost)
+		r.Get("/user/password/reset", app.userPasswordReset)
+		r.Post("/user/password/reset", app.userPasswordResetPost)
 
 		// About page
 		r.Get("/about", app.about)
@@ -94,18 +127,78 @@ func (app *application) routes() http.Handler {
 
 	// === Protected routes ===
 	r.Group(func(r chi.Router) {
+		r.Use(app.rateLimitIP)
 		r.Use(app.sessionManager.LoadAndSave)
 		r.Use(noSurf)
 		r.Use(app.authenticate)
+		r.Use(app.rateLimitUser)
 		r.Use(app.requireAuthentication)
 
-		r.Get("/snippet/create", app.snippetCreate)
-		r.Post("/snippet/create", app.snippetCreatePost)
+		r.With(app.requireVerifiedEmail).Get("/snippet/create", app.snippetCreate)
+		r.With(app.requireVerifiedEmail).Post("/snippet/create", app.snippetCreatePost)
+		r.Get("/account/verify", app.accountVerify)
+		r.Get("/account/2fa/setup", app.accountTwoFactorSetup)
+		r.Post("/account/2fa/setup/start", app.accountTwoFactorSetupStartPost)
+		r.Post("/account/2fa/setup", app.accountTwoFactorSetupPost)
+		r.With(app.requireReauthentication).Get("/account/2fa/disable", app.accountTwoFactorDisable)
+		r.With(app.requireReauthentication).Post("/account/2fa/disable", app.accountTwoFactorDisablePost)
+		r.Get("/account/confirm", app.accountConfirm)
+		r.Post("/account/confirm", app.accountConfirmPost)
+		r.Post("/account/passkeys/register/begin", app.accountPasskeyRegisterBegin)
+		r.Post("/account/passkeys/register/finish", app.accountPasskeyRegisterFinish)
+		r.Post("/account/passkeys/delete", app.accountPasskeyDelete)
+		r.Post("/account/verify", app.accountVerifyPost)
 		r.Post("/user/logout", app.userLogoutPost)
 		r.Get("/account/", app.userProfile)
 		r.Get("/account/password_change", app.userPasswordUpdate)
 		r.Post("/account/password_change", app.userPasswordUpdatePost)
+		r.Get("/account/sessions", app.accountSessions)
+		r.Post("/account/sessions/revoke", app.accountSessionRevokePost)
+		r.Post("/account/sessions/revoke-all", app.accountSessionsRevokeAllPost)
+		r.Get("/account/username", app.accountUsername)
+		r.Post("/account/username", app.accountUsernamePost)
+		r.With(app.requireReauthentication).Get("/account/email", app.accountEmail)
+		r.With(app.requireReauthentication).Post("/account/email", app.accountEmailPost)
+		r.Get("/account/export", app.accountExportDownload)
+		r.Get("/account/delete", app.accountDelete)
+		r.Post("/account/delete", app.accountDeletePost)
+		r.Get("/orgs", app.orgs)
+		r.With(app.requireVerifiedEmail).Post("/orgs", app.orgsPost)
+		r.Get("/orgs/{id}", app.org)
+		r.Post("/orgs/{id}/members", app.orgMembersPost)
+		r.Post("/orgs/{id}/members/remove", app.orgMemberRemovePost)
+		r.Post("/orgs/{id}/retention", app.orgRetentionPost)
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.requireRole(store.RoleModerator))
+			r.Get("/", app.adminUsers)
+			r.Get("/users/{id}", app.adminUser)
+			r.Post("/users/{id}/disable", app.adminUserDisablePost)
+			r.Post("/users/{id}/enable", app.adminUserEnablePost)
+			r.With(app.requireRole(store.RoleAdmin)).Post("/users/{id}/password-reset", app.adminUserPasswordResetPost)
+			r.With(app.requireRole(store.RoleAdmin)).Post("/users/{id}/role", app.adminUserRolePost)
+			r.With(app.requireRole(store.RoleAdmin)).Post("/users/{id}/unlock", app.adminUserUnlockPost)
+			r.With(app.requireRole(store.RoleAdmin)).Post("/lockouts/ip/unlock", app.adminUnlockIPPost)
+		})
 	})
 
 	return r
 }
+
+// adminRoutes is served on a separate listener that should only be reachable
+// from inside the cluster.
+func (app *application) adminRoutes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Handle("/metrics", app.metrics.Handler())
+	r.Get("/readyz", app.adminReadyz)
+	r.Get("/ratelimiter", app.rateLimiterSettings)
+	r.Get("/ratelimiter/{policy}", app.rateLimiterSettings)
+	r.Group(func(r chi.Router) {
+		r.Use(app.requireAdminToken)
+		r.Patch("/ratelimiter", app.rateLimiterSettingsUpdate)
+		r.Patch("/ratelimiter/{policy}", app.rateLimiterSettingsUpdate)
+	})
+	r.Get("/lockouts", app.lockoutStatus)
+	return r
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{
+			name: "Defaults",
+		},
+		{
+			name:    "Typo in value",
+			env:     map[string]string{"RATELIMITER_REQUESTS_PER_TIME_FRAME": "2O"},
+			wantErr: "not an integer",
+		},
+		{
+			name:    "Invalid listen address",
+			args:    []string{"-addr", "4000"},
+			wantErr: `addr: invalid listen address "4000"`,
+		},
+		{
+			name:    "Invalid log format",
+			env:     map[string]string{"LOG_FORMAT": "xml"},
+			wantErr: "invalid format",
+		},
+		{
+			name:    "Negative timeout",
+			args:    []string{"-shutdown.timeout=-1s"},
+			wantErr: "shutdown.timeout must be positive",
+		},
+		{
+			name:    "Invalid policy limit",
+			env:     map[string]string{"RATELIMITER_AUTH_REQUESTS_PER_TIME_FRAME": "0"},
+			wantErr: "ratelimiter policy auth: ratelimiter: requests per time frame must be positive",
+		},
+		{
+			name:    "Invalid allowlist",
+			args:    []string{"-ratelimiter.allowlist", "10.0.0.0/8,office"},
+			wantErr: `invalid allowlist entry "office"`,
+		},
+		{
+			name:    "Unknown exporter",
+			env:     map[string]string{"TRACING_EXPORTER": "zipkin"},
+			wantErr: `unknown exporter "zipkin"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookupEnv := func(key string) (string, bool) {
+				v, ok := tt.env[key]
+				return v, ok
+			}
+			cfg, _, _, err := loadConfig(tt.args, lookupEnv)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error containing %q, config %+v", tt.wantErr, cfg)
+			}
+			assert.StringContains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestLoadConfigHardCodedValues(t *testing.T) {
+	env := map[string]string{"RATELIMITER_TIMEFRAME": "30s", "SESSION_LIFETIME": "1h"}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	cfg, _, _, err := loadConfig([]string{"-tls.cert_file", "/etc/snippetbin/cert.pem"}, lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cfg.rateLimit.policies[policyDefault].Timeframe, 30*time.Second)
+	assert.Equal(t, cfg.rateLimit.policies[policyAuth].Timeframe, 5*time.Minute)
+	assert.Equal(t, cfg.session.lifetime, time.Hour)
+	assert.Equal(t, cfg.tls.certFile, "/etc/snippetbin/cert.pem")
+	assert.Equal(t, cfg.server.readTimeout, 5*time.Second)
+}
@@ -1,15 +1,169 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/logging"
 )
 
+const readinessCheckTimeout = 2 * time.Second
+
+// dependencyCheck reports whether a dependency such as the database can
+// currently serve requests.
+type dependencyCheck func(ctx context.Context) error
+
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	// Error is only filled in on the admin listener, as it can name
+	// hosts, users and versions of the dependencies.
+	Error string `json:"error,omitempty"`
+	err   error
+}
+
 func (app *application) health(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
 	data := map[string]string{
 		"status": "ok",
 	}
-	err := app.writeJSON(w, http.StatusOK, data)
+	if app.draining.Load() {
+		status = http.StatusServiceUnavailable
+		data["status"] = "draining"
+	}
+	err := app.writeJSON(w, status, data)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// livez reports whether the process is running. It does not look at any
+// dependency, so a failing database never gets the pod restarted.
+func (app *application) livez(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 	if err != nil {
 		app.serverError(w, r, err)
 	}
 }
+
+// readyz probes every dependency concurrently and reports 503 if any of them
+// fails or the server is draining. A failing optional check only marks the
+// server as degraded, since it can still serve without that dependency.
+// Failures are logged; their details are only shown by adminReadyz.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	app.writeReadiness(w, r, false)
+}
+
+// adminReadyz is readyz with the error of each failing check.
+func (app *application) adminReadyz(w http.ResponseWriter, r *http.Request) {
+	app.writeReadiness(w, r, true)
+}
+
+func (app *application) writeReadiness(w http.ResponseWriter, r *http.Request, showErrors bool) {
+	checks := map[string]dependencyCheck{
+		"templates": app.checkTemplateCache,
+	}
+	for name, check := range app.readinessChecks {
+		checks[name] = check
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		results  = make(map[string]dependencyStatus, len(checks)+len(app.optionalChecks))
+		ready    = !app.draining.Load()
+		degraded bool
+	)
+	for name, check := range app.optionalChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := runDependencyCheck(r.Context(), check)
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			if result.Status != "ok" {
+				degraded = true
+			}
+		}()
+	}
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := runDependencyCheck(r.Context(), check)
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			if result.Status != "ok" {
+				ready = false
+			}
+		}()
+	}
+	wg.Wait()
+
+	for name, result := range results {
+		if result.err == nil {
+			continue
+		}
+		if showErrors {
+			result.Error = result.err.Error()
+			results[name] = result
+		} else {
+			logging.FromContext(r.Context()).Warn("readiness check failed", slog.String("check", name), slog.String("error", result.err.Error()))
+		}
+	}
+
+	status := http.StatusOK
+	data := map[string]any{
+		"status": "ok",
+		"checks": results,
+	}
+	if degraded {
+		data["status"] = "degraded"
+	}
+	if !ready {
+		status = http.StatusServiceUnavailable
+		data["status"] = "fail"
+	}
+	if app.draining.Load() {
+		data["status"] = "draining"
+	}
+	err := app.writeJSON(w, status, data)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func runDependencyCheck(ctx context.Context, check dependencyCheck) dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := dependencyStatus{
+		Status:    "ok",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = "fail"
+		result.err = err
+	}
+	return result
+}
+
+// checkTemplateCache makes sure the pages were parsed at startup, since
+// every HTML handler renders through the cache.
+func (app *application) checkTemplateCache(ctx context.Context) error {
+	if len(app.templateCache) == 0 {
+		return errors.New("template cache is empty")
+	}
+	if _, ok := app.templateCache["home.html"]; !ok {
+		return errors.New("template home.html is missing from the cache")
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+func TestHealth(t *testing.T) {
+	cfg := newConfig(t)
+	app := newTestApplication(t, cfg)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/health")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"status":"ok"`)
+
+	app.draining.Store(true)
+
+	code, _, body = ts.get(t, "/health")
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.StringContains(t, body, `"status":"draining"`)
+}
+
+func TestLivez(t *testing.T) {
+	cfg := newConfig(t)
+	app := newTestApplication(t, cfg)
+	app.readinessChecks = map[string]dependencyCheck{
+		"postgres": func(ctx context.Context) error { return errors.New("connection refused") },
+	}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/livez")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"status":"ok"`)
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name     string
+		checks   map[string]dependencyCheck
+		optional map[string]dependencyCheck
+		draining bool
+		wantCode int
+		wantBody []string
+		// wantErrors are only shown on the admin listener.
+		wantErrors []string
+	}{
+		{
+			name: "All dependencies up",
+			checks: map[string]dependencyCheck{
+				"postgres": func(ctx context.Context) error { return nil },
+				"redis":    func(ctx context.Context) error { return nil },
+			},
+			wantCode: http.StatusOK,
+			wantBody: []string{`"status":"ok"`, `"postgres":{"status":"ok"`, `"redis":{"status":"ok"`, `"templates":{"status":"ok"`},
+		},
+		{
+			name: "Redis down",
+			checks: map[string]dependencyCheck{
+				"postgres": func(ctx context.Context) error { return nil },
+				"redis":    func(ctx context.Context) error { return errors.New("connection refused") },
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantBody:   []string{`"status":"fail"`, `"redis":{"status":"fail"`},
+			wantErrors: []string{`"error":"connection refused"`},
+		},
+		{
+			name: "Optional Redis down",
+			checks: map[string]dependencyCheck{
+				"postgres": func(ctx context.Context) error { return nil },
+			},
+			optional: map[string]dependencyCheck{
+				"redis": func(ctx context.Context) error { return errors.New("connection refused") },
+			},
+			wantCode:   http.StatusOK,
+			wantBody:   []string{`"status":"degraded"`, `"redis":{"status":"fail"`},
+			wantErrors: []string{`"error":"connection refused"`},
+		},
+		{
+			name: "Check times out",
+			checks: map[string]dependencyCheck{
+				"postgres": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantBody:   []string{`"postgres":{"status":"fail"`},
+			wantErrors: []string{"deadline exceeded"},
+		},
+		{
+			name:     "Draining",
+			draining: true,
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: []string{`"status":"draining"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig(t)
+			app := newTestApplication(t, cfg)
+			app.readinessChecks = tt.checks
+			app.optionalChecks = tt.optional
+			app.draining.Store(tt.draining)
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+			admin := newTestServer(t, app.adminRoutes())
+			defer admin.Close()
+
+			code, _, body := ts.get(t, "/readyz")
+			assert.Equal(t, code, tt.wantCode)
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+			assert.Equal(t, strings.Contains(body, `"error"`), false)
+
+			code, _, body = admin.get(t, "/readyz")
+			assert.Equal(t, code, tt.wantCode)
+			for _, want := range append(tt.wantBody, tt.wantErrors...) {
+				assert.StringContains(t, body, want)
+			}
+		})
+	}
+}
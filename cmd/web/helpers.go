@@ -2,14 +2,20 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"github.com/theluminousartemis/snippetbin/internal/logging"
+	"github.com/theluminousartemis/snippetbin/internal/ratelimiter"
 )
 
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
@@ -19,14 +25,55 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 		// trace  = string(debug.Stack())
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	logging.FromContext(r.Context()).ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri)
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
-func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter string) {
-	app.logger.Info("rate limit exceeded", "remote_addr", r.RemoteAddr, "method", r.Method, "uri", r.URL.RequestURI(), "retryAfter", retryAfter)
-	w.Header().Set("Retry-After", retryAfter)
-	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+// setRateLimitHeaders writes the RateLimit-* fields from the IETF
+// "RateLimit header fields for HTTP" draft. Times are whole seconds,
+// rounded up so clients never retry too early.
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimiter.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// rateLimitExceededResponse sends a 429 with Retry-After in seconds. Clients
+// that accept JSON, such as the CLI, get the same information in the body.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, res ratelimiter.Result) {
+	retryAfter := max(ceilSeconds(res.RetryAfter), 1)
+	logging.FromContext(r.Context()).InfoContext(r.Context(), "rate limit exceeded", "remote_addr", r.RemoteAddr, "method", r.Method, "uri", r.URL.RequestURI(), "retry_after", retryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	if !acceptsJSON(r) {
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	err := app.writeJSON(w, http.StatusTooManyRequests, map[string]any{
+		"error":       "rate limit exceeded",
+		"retry_after": retryAfter,
+		"limit":       res.Limit,
+		"remaining":   res.Remaining,
+		"reset":       ceilSeconds(res.ResetAfter),
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// acceptsJSON reports whether the client asked for a JSON response.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		if strings.TrimSpace(mediaType) == "application/json" {
+			return true
+		}
+	}
+	return false
 }
 
 func (app *application) clientError(w http.ResponseWriter, status int) {
@@ -51,12 +98,17 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 }
 
 func (app *application) newTemplateData(r *http.Request) templateData {
-	return templateData{
+	data := templateData{
 		CurrentYear:     time.Now().Year(),
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
 		CSRFToken:       nosurf.Token(r),
+		PasswordSignup:  app.passwordSignup,
+	}
+	if app.oidc != nil {
+		data.SSOName = app.oidc.name
 	}
+	return data
 }
 
 func (app *application) decodePostForm(r *http.Request, dst any) error {
@@ -90,3 +142,26 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data any) e
 	w.WriteHeader(status)
 	return json.NewEncoder(w).Encode(data)
 }
+
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("body must only contain a single JSON value")
+	}
+	return nil
+}
+
+// randomString returns 32 random bytes, base64url encoded, for use as an
+// unguessable identifier.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	cfg := newConfig(t)
+	app := newTestApplication(t, cfg)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	admin := newTestServer(t, app.adminRoutes())
+	defer admin.Close()
+
+	ts.get(t, "/ping")
+	ts.get(t, "/snippet/view/2")
+	ts.get(t, "/snippet/view/3")
+	ts.get(t, "/does-not-exist")
+
+	code, _, body := admin.get(t, "/metrics")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `snippetbin_http_requests_total{method="GET",route="/ping",status="200"} 1`)
+	assert.StringContains(t, body, `snippetbin_http_requests_total{method="GET",route="/snippet/view/{id}",status="404"} 2`)
+	assert.StringContains(t, body, `snippetbin_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.StringContains(t, body, `snippetbin_http_request_duration_seconds_count{method="GET",route="/ping"} 1`)
+	assert.StringContains(t, body, "snippetbin_snippets_created_total 0")
+}
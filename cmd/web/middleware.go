@@ -1,17 +1,27 @@
 package main
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
+	"github.com/theluminousartemis/snippetbin/internal/logging"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
 )
 
 func commonHeaders(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com; img-src 'self' data:")
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "deny")
@@ -32,6 +42,28 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// requireRole returns middleware that lets through only users with at
+// least role. It must run after requireAuthentication. The role is read
+// on every request, so taking it away works straight away.
+func (app *application) requireRole(role store.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			user, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if !user.Role.AtLeast(role) {
+				logging.FromContext(ctx).InfoContext(ctx, "role required", slog.String("role", string(role)), slog.String("path", r.URL.Path))
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
@@ -43,36 +75,56 @@ func noSurf(next http.Handler) http.Handler {
 	return csrfHandler
 }
 
-func (app *application) RateLimiterMiddleware(next http.Handler) http.Handler {
+type contextKey string
+
+const (
+	isAuthenticatedKey contextKey = "isAuthenticated"
+	requestStateKey    contextKey = "requestState"
+)
+
+// requestState is created by logRequest and filled in by middleware further
+// down the chain, so the access log can report who made the request.
+type requestState struct {
+	userID int
+}
+
+// logRequest writes one access log line per request and stores a logger
+// tagged with the chi request ID in the request context.
+func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasPrefix(r.URL.Path, "/static/") {
-			// app.logger.Info("not rate limited")
-			next.ServeHTTP(w, r)
-			return
+		start := time.Now()
+		state := &requestState{}
+		logger := app.logger.With(slog.String("request_id", middleware.GetReqID(r.Context())))
+		ctx := logging.WithLogger(r.Context(), logger)
+		ctx = context.WithValue(ctx, requestStateKey, state)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
 		}
-		if strings.HasPrefix(r.URL.Path, "/ping") || strings.HasPrefix(r.URL.Path, "/health") {
-			app.logger.Info("not rate limited")
-			next.ServeHTTP(w, r)
-			return
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
 		}
-		ctx := r.Context()
-		allow, retryAfter, err := app.rateLimiter.Allow(ctx, r.RemoteAddr)
-		if err != nil {
-			app.serverError(w, r, err)
-			return
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("uri", r.URL.RequestURI()),
+			slog.String("route", chi.RouteContext(r.Context()).RoutePattern()),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Duration("duration", time.Since(start)),
 		}
-		if !allow {
-			app.rateLimitExceededResponse(w, r, retryAfter.String())
-			return
+		if state.userID != 0 {
+			attrs = append(attrs, slog.Int("user_id", state.userID))
 		}
-		next.ServeHTTP(w, r)
+		logger.LogAttrs(ctx, level, "request", attrs...)
 	})
 }
 
-type contextKey string
-
-const isAuthenticatedKey contextKey = "isAuthenticated"
-
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
@@ -81,14 +133,23 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 		ctx := r.Context()
-		exists, err := app.store.Users.Exists(ctx, id)
-		if err != nil {
+		err := app.store.Sessions.Touch(ctx, app.sessionManager.GetString(ctx, "sessionID"), id, remoteIP(r))
+		if err != nil && !errors.Is(err, store.ErrNoRecord) {
 			app.serverError(w, r, err)
 			return
 		}
 
-		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedKey, true)
+		// The session was revoked, from another session or by a password
+		// change or reset, or the user was deleted.
+		if err != nil {
+			app.sessionManager.Remove(ctx, "authenticatedUserID")
+			app.sessionManager.Remove(ctx, "sessionID")
+		} else {
+			if state, ok := ctx.Value(requestStateKey).(*requestState); ok {
+				state.userID = id
+			}
+			ctx = context.WithValue(ctx, isAuthenticatedKey, true)
+			ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With(slog.Int("user_id", id)))
 			r = r.WithContext(ctx)
 		}
 
@@ -96,3 +157,52 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 
 }
+
+func (app *application) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		app.metrics.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		app.metrics.HTTPDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// traceRoute names the span started by otelhttp after chi has matched the
+// route, and returns the trace ID to the client so it can be quoted in bug
+// reports.
+func traceRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		if sc := span.SpanContext(); sc.IsValid() {
+			w.Header().Set("X-Trace-Id", sc.TraceID().String())
+		}
+		next.ServeHTTP(w, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			return
+		}
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route))
+	})
+}
+
+func shouldTrace(r *http.Request) bool {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/static/"):
+		return false
+	case r.URL.Path == "/ping", r.URL.Path == "/livez", r.URL.Path == "/readyz":
+		return false
+	}
+	return true
+}
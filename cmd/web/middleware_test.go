@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -24,7 +26,7 @@ func TestCommonHeaders(t *testing.T) {
 	commonHeaders(next).ServeHTTP(rr, r)
 	rs := rr.Result()
 
-	expectedValue := "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com"
+	expectedValue := "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com; img-src 'self' data:"
 	assert.Equal(t, rs.Header.Get("Content-Security-Policy"), expectedValue)
 
 	expectedValue = "origin-when-cross-origin"
@@ -53,3 +55,32 @@ func TestCommonHeaders(t *testing.T) {
 	body = bytes.TrimSpace(body)
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestLogRequest(t *testing.T) {
+	cfg := newConfig(t)
+	app := newTestApplication(t, cfg)
+
+	var buf bytes.Buffer
+	app.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/snippet/view/2")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, entry["msg"], any("request"))
+	assert.Equal(t, entry["route"], any("/snippet/view/{id}"))
+	assert.Equal(t, entry["status"], any(float64(http.StatusNotFound)))
+	assert.Equal(t, entry["uri"], any("/snippet/view/2"))
+	if id, _ := entry["request_id"].(string); id == "" {
+		t.Errorf("request_id missing from %v", entry)
+	}
+	if _, ok := entry["user_id"]; ok {
+		t.Errorf("unexpected user_id in %v", entry)
+	}
+}
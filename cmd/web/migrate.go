@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+
+	"github.com/theluminousartemis/snippetbin/internal/db"
+	"github.com/theluminousartemis/snippetbin/migrate"
+)
+
+const migrateUsage = "usage: web migrate up|down [N]|status"
+
+func newMigrator(ctx context.Context, conn *sql.DB) (*db.Migrator, error) {
+	return db.NewMigrator(ctx, conn, migrate.Files, "migrations")
+}
+
+// runMigrate implements the "web migrate" subcommand.
+func runMigrate(ctx context.Context, conn *sql.DB, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	migrator, err := newMigrator(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch args[0] {
+	case "up":
+		if err := migrator.Up(); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		if err := migrator.Down(steps); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	status, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "current version: %d\nlatest version:  %d\ndirty:           %t\n", status.Current, status.Latest, status.Dirty)
+	return nil
+}
+
+// checkSchema applies pending migrations when autoMigrate is set and then
+// refuses to continue unless the schema matches the embedded migrations.
+func checkSchema(ctx context.Context, conn *sql.DB, autoMigrate bool, logger *slog.Logger) error {
+	migrator, err := newMigrator(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	if autoMigrate {
+		logger.Info("applying database migrations")
+		if err := migrator.Up(); err != nil {
+			return err
+		}
+	}
+
+	status, err := migrator.CheckCurrent()
+	if err != nil {
+		if errors.Is(err, db.ErrSchemaBehind) {
+			return fmt.Errorf("%w: database is at version %d, binary expects %d; run \"web migrate up\"", err, status.Current, status.Latest)
+		}
+		return err
+	}
+	logger.Info("database schema is up to date", slog.Uint64("version", uint64(status.Current)))
+	return nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/theluminousartemis/snippetbin/internal/logging"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+	"golang.org/x/oauth2"
+)
+
+// oidcLoginTimeout is how long a user has to log in at the provider.
+const oidcLoginTimeout = 10 * time.Minute
+
+var (
+	errOIDCEmailUnverified = errors.New("oidc: email address not verified by the provider")
+	errOIDCNoAccount       = errors.New("oidc: no account for the email address")
+)
+
+// oidcLogin signs users in through an OpenID Connect provider with the
+// authorization code flow and PKCE. The provider's configuration is
+// discovered on first use, so the site starts while the provider is down.
+type oidcLogin struct {
+	name          string
+	issuer        string
+	autoProvision bool
+	trustEmail    bool
+	oauth2        oauth2.Config
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// newOIDCLogin returns nil if no provider is configured.
+func newOIDCLogin(cfg oidcConfig, baseURL string) *oidcLogin {
+	if cfg.issuer == "" {
+		return nil
+	}
+	return &oidcLogin{
+		name:          cfg.name,
+		issuer:        cfg.issuer,
+		autoProvision: cfg.autoProvision,
+		trustEmail:    cfg.trustEmail,
+		oauth2: oauth2.Config{
+			ClientID:     cfg.clientID,
+			ClientSecret: cfg.clientSecret,
+			RedirectURL:  strings.TrimSuffix(baseURL, "/") + "/user/login/oidc/callback",
+			Scopes:       cfg.scopes,
+		},
+	}
+}
+
+// discover returns the provider, fetching its configuration if this is
+// the first call or the earlier attempts failed.
+func (o *oidcLogin) discover(ctx context.Context) (*oidc.Provider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider == nil {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		p, err := oidc.NewProvider(ctx, o.issuer)
+		if err != nil {
+			return nil, fmt.Errorf("oidc discovery: %w", err)
+		}
+		o.provider = p
+	}
+	return o.provider, nil
+}
+
+func (o *oidcLogin) config(p *oidc.Provider) *oauth2.Config {
+	cfg := o.oauth2
+	cfg.Endpoint = p.Endpoint()
+	return &cfg
+}
+
+// oidcState is kept in the session while the user is at the provider.
+type oidcState struct {
+	State     string
+	Nonce     string
+	Verifier  string
+	StartedAt int64
+	// ReauthUserID is set when a logged in user is confirming it's them
+	// rather than logging in, and Next is where they go afterwards.
+	ReauthUserID int
+	Next         string
+}
+
+// oidcClaims are the ID token claims used to find or create the user.
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     *bool  `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	AuthTime          int64  `json:"auth_time"`
+}
+
+func (app *application) oidcFailed(w http.ResponseWriter, r *http.Request, msg string) {
+	app.sessionManager.Put(r.Context(), "flash", msg)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// userLoginOIDC sends the user to the provider to log in. With ?reauth a
+// logged in user is sent to confirm it's them instead; see
+// oidcReauthenticate.
+func (app *application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if app.oidc == nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	provider, err := app.oidc.discover(ctx)
+	if err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "single sign-on unavailable", slog.String("error", err.Error()))
+		app.oidcFailed(w, r, "Single sign-on is unavailable right now. Please try again later.")
+		return
+	}
+
+	var st oidcState
+	for _, s := range []*string{&st.State, &st.Nonce} {
+		if *s, err = randomString(); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	st.Verifier = oauth2.GenerateVerifier()
+	st.StartedAt = time.Now().Unix()
+	opts := []oauth2.AuthCodeOption{oidc.Nonce(st.Nonce), oauth2.S256ChallengeOption(st.Verifier)}
+	if r.URL.Query().Has("reauth") && app.isAuthenticated(r) {
+		st.ReauthUserID = app.sessionManager.GetInt(ctx, "authenticatedUserID")
+		st.Next = localPath(r.URL.Query().Get("next"))
+		// Have the provider ask for their credentials now, rather than
+		// rely on a session there.
+		opts = append(opts, oauth2.SetAuthURLParam("prompt", "login"), oauth2.SetAuthURLParam("max_age", "0"))
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(ctx, "oidcLogin", string(b))
+
+	u := app.oidc.config(provider).AuthCodeURL(st.State, opts...)
+	http.Redirect(w, r, u, http.StatusSeeOther)
+}
+
+// userLoginOIDCCallback is where the provider sends the user back to. The
+// code is exchanged for an ID token, whose subject or email address
+// identifies the user.
+func (app *application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := logging.FromContext(ctx)
+	if app.oidc == nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	var st oidcState
+	s := app.sessionManager.PopString(ctx, "oidcLogin")
+	if s == "" || json.Unmarshal([]byte(s), &st) != nil ||
+		time.Since(time.Unix(st.StartedAt, 0)) > oidcLoginTimeout || r.URL.Query().Get("state") != st.State {
+		app.oidcFailed(w, r, "Your single sign-on attempt expired. Please try again.")
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		logger.InfoContext(ctx, "single sign-on refused by provider", slog.String("error", e),
+			slog.String("description", r.URL.Query().Get("error_description")))
+		app.oidcFailed(w, r, "Single sign-on was cancelled or failed.")
+		return
+	}
+
+	claims, err := app.exchangeOIDCCode(ctx, r.URL.Query().Get("code"), st)
+	if err != nil {
+		logger.WarnContext(ctx, "single sign-on failed", slog.String("error", err.Error()))
+		app.metrics.Logins.WithLabelValues("failure").Inc()
+		app.oidcFailed(w, r, "Single sign-on was cancelled or failed.")
+		return
+	}
+	if st.ReauthUserID != 0 {
+		app.oidcReauthenticate(w, r, st, claims)
+		return
+	}
+
+	user, err := app.oidcUser(ctx, claims)
+	switch {
+	case errors.Is(err, errOIDCEmailUnverified):
+		app.oidcFailed(w, r, fmt.Sprintf("%s has not verified your email address, so it can't be used to log in here.", app.oidc.name))
+		return
+	case errors.Is(err, errOIDCNoAccount):
+		app.oidcFailed(w, r, "There is no account for "+claims.Email+". Please ask an administrator to create one.")
+		return
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	}
+	app.continueLogin(w, r, user)
+}
+
+// oidcReauthenticate finishes a single sign-on started from the confirm
+// page. It counts only for the identity already linked to the logged in
+// user, and only if the provider has just checked their credentials.
+func (app *application) oidcReauthenticate(w http.ResponseWriter, r *http.Request, st oidcState, claims *oidcClaims) {
+	ctx := r.Context()
+	if app.sessionManager.GetInt(ctx, "authenticatedUserID") != st.ReauthUserID {
+		app.oidcFailed(w, r, "Your single sign-on attempt expired. Please try again.")
+		return
+	}
+	fail := func(msg string) {
+		app.sessionManager.Put(ctx, "flash", msg)
+		http.Redirect(w, r, "/account/confirm?next="+url.QueryEscape(st.Next), http.StatusSeeOther)
+	}
+	id, err := app.store.Identities.GetUserID(ctx, app.oidc.issuer, claims.Subject)
+	if err != nil && !errors.Is(err, store.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	if err != nil || id != st.ReauthUserID {
+		fail(fmt.Sprintf("That %s account is not linked to yours.", app.oidc.name))
+		return
+	}
+	if claims.AuthTime == 0 || time.Since(time.Unix(claims.AuthTime, 0)) > oidcLoginTimeout {
+		fail(fmt.Sprintf("%s did not ask you to log in again. Please confirm it's you another way.", app.oidc.name))
+		return
+	}
+
+	app.sessionManager.Put(ctx, "reauthenticatedAt", time.Now().Unix())
+	logging.FromContext(ctx).InfoContext(ctx, "user reauthenticated", slog.Int("user_id", id), slog.String("method", "oidc"))
+	http.Redirect(w, r, st.Next, http.StatusSeeOther)
+}
+
+func (app *application) exchangeOIDCCode(ctx context.Context, code string, st oidcState) (*oidcClaims, error) {
+	provider, err := app.oidc.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	token, err := app.oidc.config(provider).Exchange(ctx, code, oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("no id_token in token response")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: app.oidc.oauth2.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != st.Nonce {
+		return nil, errors.New("id_token nonce does not match")
+	}
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// oidcUser finds the user the claims belong to. An identity seen before
+// maps to its user by subject. Otherwise an account with the same,
+// provider verified, email address is linked, or a new one created if
+// provisioning is on.
+func (app *application) oidcUser(ctx context.Context, claims *oidcClaims) (*store.User, error) {
+	id, err := app.store.Identities.GetUserID(ctx, app.oidc.issuer, claims.Subject)
+	if err == nil {
+		return app.store.Users.GetByID(ctx, id)
+	}
+	if !errors.Is(err, store.ErrNoRecord) {
+		return nil, err
+	}
+
+	verified := app.oidc.trustEmail
+	if claims.EmailVerified != nil {
+		verified = *claims.EmailVerified
+	}
+	if claims.Email == "" || !verified {
+		return nil, errOIDCEmailUnverified
+	}
+
+	user, err := app.store.Users.GetByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		if err := app.store.Identities.Link(ctx, app.oidc.issuer, claims.Subject, user.ID); err != nil {
+			return nil, err
+		}
+		logging.FromContext(ctx).InfoContext(ctx, "single sign-on identity linked", slog.Int("user_id", user.ID))
+		return app.store.Users.GetByID(ctx, user.ID)
+	case !errors.Is(err, store.ErrInvalidCredentials):
+		return nil, err
+	case !app.oidc.autoProvision:
+		return nil, errOIDCNoAccount
+	}
+	return app.provisionOIDCUser(ctx, claims)
+}
+
+// provisionOIDCUser creates an account for claims. The password is random;
+// the user can set one through the password reset link if they want it.
+func (app *application) provisionOIDCUser(ctx context.Context, claims *oidcClaims) (*store.User, error) {
+	username := claims.PreferredUsername
+	if username == "" {
+		username, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if utf8.RuneCountInString(username) < 3 {
+		username = "user"
+	}
+	password, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	user := &store.User{Username: username, Email: claims.Email}
+	if err := user.Password.Set(password); err != nil {
+		return nil, err
+	}
+
+	for range 5 {
+		err = app.store.Identities.InsertUser(ctx, user, app.oidc.issuer, claims.Subject)
+		if !errors.Is(err, store.ErrDuplicateUsername) {
+			break
+		}
+		suffix := make([]byte, 2)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+		user.Username = username + "-" + hex.EncodeToString(suffix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "user created by single sign-on", slog.Int("user_id", user.ID))
+	return user, nil
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+// testOIDCProvider is a stand-in OpenID Connect provider. Whoever is sent
+// to its authorization endpoint is logged in as the claims in next.
+type testOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	next  map[string]any
+	codes map[string]testOIDCCode
+}
+
+type testOIDCCode struct {
+	claims      map[string]any
+	challenge   string
+	nonce       string
+	redirectURI string
+	// reauth is set if the user was asked to log in again, in which case
+	// the ID token says when.
+	reauth bool
+}
+
+const testOIDCClientID = "snippetbin"
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testOIDCProvider{key: key, codes: make(map[string]testOIDCCode)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *testOIDCProvider) setNext(claims map[string]any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next = claims
+}
+
+func (p *testOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *testOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   b64(p.key.N.Bytes()),
+			"e":   b64(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *testOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testOIDCClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	code, _ := randomString()
+	p.mu.Lock()
+	p.codes[code] = testOIDCCode{
+		claims:      p.next,
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		redirectURI: q.Get("redirect_uri"),
+		reauth:      q.Get("prompt") == "login" && q.Has("max_age"),
+	}
+	p.mu.Unlock()
+	callback, _ := url.Parse(q.Get("redirect_uri"))
+	callback.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, callback.String(), http.StatusFound)
+}
+
+func (p *testOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	c, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || b64(verifier[:]) != c.challenge || r.PostForm.Get("redirect_uri") != c.redirectURI {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   p.URL,
+		"aud":   testOIDCClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": c.nonce,
+	}
+	if c.reauth {
+		claims["auth_time"] = time.Now().Unix()
+	}
+	for k, v := range c.claims {
+		claims[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed + "." + base64.RawURLEncoding.EncodeToString(sig),
+	})
+}
+
+// ssoLogin follows a single sign-on from the login link to the callback
+// and returns the callback's response.
+func (ts *testServer) ssoLogin(t *testing.T, idp *testOIDCProvider) (int, string) {
+	t.Helper()
+	return ts.sso(t, idp, "/user/login/oidc")
+}
+
+// sso is ssoLogin starting from link.
+func (ts *testServer) sso(t *testing.T, idp *testOIDCProvider, link string) (int, string) {
+	t.Helper()
+	code, header, _ := ts.get(t, link)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	rs, err := client.Get(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusFound)
+	callback, err := url.Parse(rs.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, callback.Scheme+"://"+callback.Host, testBaseURL)
+
+	code, header, _ = ts.get(t, callback.RequestURI())
+	return code, header.Get("Location")
+}
+
+func newOIDCTestApplication(t *testing.T, idp *testOIDCProvider, autoProvision bool) *application {
+	app := newTestApplication(t, newConfig(t))
+	app.oidc = newOIDCLogin(oidcConfig{
+		issuer:        idp.URL,
+		clientID:      testOIDCClientID,
+		clientSecret:  "secret",
+		name:          "Corp",
+		scopes:        []string{"openid", "email", "profile"},
+		autoProvision: autoProvision,
+	}, testBaseURL)
+	return app
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+
+	tests := []struct {
+		name          string
+		claims        map[string]any
+		autoProvision bool
+		wantLocation  string
+		wantBody      string
+	}{
+		{
+			name:          "New user is provisioned",
+			claims:        map[string]any{"sub": "alice-1", "email": "alice@corp.example", "email_verified": true, "preferred_username": "alice"},
+			autoProvision: true,
+			wantLocation:  "/snippet/create",
+		},
+		{
+			name:          "Existing user by email",
+			claims:        map[string]any{"sub": "valid-1", "email": store.MockUser.Email, "email_verified": true},
+			autoProvision: true,
+			wantLocation:  "/snippet/create",
+		},
+		{
+			name:          "Taken username",
+			claims:        map[string]any{"sub": "bob-1", "email": "bob@corp.example", "email_verified": true, "preferred_username": store.MockUser.Username},
+			autoProvision: true,
+			wantLocation:  "/snippet/create",
+		},
+		{
+			name:          "Unverified email",
+			claims:        map[string]any{"sub": "valid-2", "email": store.MockUser.Email, "email_verified": false},
+			autoProvision: true,
+			wantLocation:  "/user/login",
+			wantBody:      "Corp has not verified your email address",
+		},
+		{
+			name:          "Provisioning off",
+			claims:        map[string]any{"sub": "carol-1", "email": "carol@corp.example", "email_verified": true},
+			autoProvision: false,
+			wantLocation:  "/user/login",
+			wantBody:      "There is no account for carol@corp.example",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newOIDCTestApplication(t, idp, tt.autoProvision)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			idp.setNext(tt.claims)
+			code, location := ts.ssoLogin(t, idp)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, location, tt.wantLocation)
+			_, _, body := ts.get(t, location)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginMapsBySubject(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	app := newOIDCTestApplication(t, idp, true)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	idp.setNext(map[string]any{"sub": "alice-1", "email": "alice@corp.example", "email_verified": true, "preferred_username": "al"})
+	ts.ssoLogin(t, idp)
+	code, _, body := ts.get(t, "/account/")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<td>user</td>")
+
+	// A changed email address at the provider still finds the same user.
+	_, _, body = ts.get(t, "/")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	ts.postForm(t, "/user/logout", form)
+	idp.setNext(map[string]any{"sub": "alice-1", "email": "alice.smith@corp.example", "email_verified": true})
+	code, location := ts.ssoLogin(t, idp)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, location, "/snippet/create")
+	_, _, body = ts.get(t, "/account/")
+	assert.StringContains(t, body, "<td>alice@corp.example</td>")
+}
+
+func TestOIDCLoginTwoFactor(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	app := newOIDCTestApplication(t, idp, true)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ctx := context.Background()
+	if err := app.store.TwoFactor.SetPending(ctx, store.MockUser.ID, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.TwoFactor.Enable(ctx, store.MockUser.ID, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	idp.setNext(map[string]any{"sub": "valid-1", "email": store.MockUser.Email, "email_verified": true})
+	code, location := ts.ssoLogin(t, idp)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, location, "/user/login/2fa")
+}
+
+func TestOIDCReauthenticate(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	app := newOIDCTestApplication(t, idp, true)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ctx := context.Background()
+
+	// An account created by single sign-on has a password nobody knows.
+	alice := map[string]any{"sub": "alice-1", "email": "alice@corp.example", "email_verified": true, "preferred_username": "alice"}
+	idp.setNext(alice)
+	code, _ := ts.ssoLogin(t, idp)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body := ts.get(t, "/account/delete")
+	assert.StringContains(t, body, "Confirm it's you another way")
+	_, _, body = ts.get(t, "/account/confirm?next=/account/delete")
+	assert.StringContains(t, body, "log in again with Corp")
+
+	// The provider is asked to check their credentials again.
+	link := "/user/login/oidc?reauth=1&next=/account/delete"
+	_, header, _ := ts.get(t, link)
+	start, err := url.Parse(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, start.Query().Get("prompt"), "login")
+	assert.Equal(t, start.Query().Get("max_age"), "0")
+
+	// Another identity doesn't count, and no account is made for it.
+	idp.setNext(map[string]any{"sub": "bob-1", "email": "bob@corp.example", "email_verified": true})
+	code, location := ts.sso(t, idp, link)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, location, "/account/confirm?next=%2Faccount%2Fdelete")
+	_, _, body = ts.get(t, location)
+	assert.StringContains(t, body, "That Corp account is not linked to yours.")
+	_, err = app.store.Users.GetByEmail(ctx, "bob@corp.example")
+	assert.Equal(t, err, store.ErrInvalidCredentials)
+
+	idp.setNext(alice)
+	code, location = ts.sso(t, idp, link)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, location, "/account/delete")
+	_, _, body = ts.get(t, location)
+	assert.StringContains(t, body, "your password isn't needed")
+
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, header, _ = ts.postForm(t, "/account/delete", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/")
+	_, err = app.store.Users.GetByEmail(ctx, "alice@corp.example")
+	assert.Equal(t, err, store.ErrInvalidCredentials)
+}
+
+func TestOIDCReauthenticateEmailChange(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	app := newOIDCTestApplication(t, idp, true)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	alice := map[string]any{"sub": "alice-1", "email": "alice@corp.example", "email_verified": true, "preferred_username": "alice"}
+	idp.setNext(alice)
+	ts.ssoLogin(t, idp)
+	code, header, _ := ts.get(t, "/account/email")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/confirm?next=%2Faccount%2Femail")
+
+	idp.setNext(alice)
+	code, location := ts.sso(t, idp, "/user/login/oidc?reauth=1&next=/account/email")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, location, "/account/email")
+	_, _, body := ts.get(t, location)
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("email", "alice@home.example")
+	code, _, _ = ts.postForm(t, "/account/email", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	app.wg.Wait()
+	assert.Equal(t, len(app.mailer.(*testMailer).sent()), 2)
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	app := newOIDCTestApplication(t, idp, true)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// A callback the user did not start here is refused.
+	code, header, _ := ts.get(t, "/user/login/oidc/callback?code=abc&state=forged")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+	_, _, body := ts.get(t, "/user/login")
+	assert.StringContains(t, body, "Your single sign-on attempt expired")
+
+	code, header, _ = ts.get(t, "/user/login/oidc")
+	assert.Equal(t, code, http.StatusSeeOther)
+	start, err := url.Parse(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := start.Query()
+	assert.Equal(t, q.Get("code_challenge_method"), "S256")
+	assert.Equal(t, q.Get("redirect_uri"), testBaseURL+"/user/login/oidc/callback")
+	// Nor is one for a login started here with the state changed.
+	_, header, _ = ts.get(t, "/user/login/oidc/callback?code=abc&state=forged")
+	assert.Equal(t, header.Get("Location"), "/user/login")
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Without a provider the routes do not exist.
+	app.oidc = nil
+	ts2 := newTestServer(t, app.routes())
+	defer ts2.Close()
+	code, _, _ = ts2.get(t, "/user/login/oidc")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestPasswordSignupDisabled(t *testing.T) {
+	idp := newTestOIDCProvider(t)
+	app := newOIDCTestApplication(t, idp, true)
+	app.passwordSignup = false
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/user/signup")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Signing up with a password is turned off.")
+	assert.StringContains(t, body, `<a href="/user/login/oidc">Sign up with Corp</a>`)
+
+	_, _, body = ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("username", "newuser")
+	form.Add("email", "new@example.com")
+	form.Add("password", "validpassword")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = ts.postForm(t, "/user/signup", form)
+	assert.Equal(t, code, http.StatusForbidden)
+}
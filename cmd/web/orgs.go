@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/theluminousartemis/snippetbin/internal/logging"
+	"github.com/theluminousartemis/snippetbin/internal/mailer"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+// orgView is an organization as seen by one of its members.
+type orgView struct {
+	Org     store.Organization
+	Members []store.OrgMember
+	UserID  int
+	Roles   []store.OrgRole
+}
+
+func (v *orgView) IsOwner() bool {
+	return v.Org.Role == store.OrgRoleOwner
+}
+
+type orgCreateForm struct {
+	Name          string            `form:"name" validate:"required,max=100"`
+	RetentionDays int               `form:"retention_days" validate:"required,min=1,max=365"`
+	FieldErrors   map[string]string `form:"-"`
+}
+
+type orgMemberForm struct {
+	Email       string            `form:"email" validate:"required,email"`
+	Role        string            `form:"role"`
+	FieldErrors map[string]string `form:"-"`
+}
+
+type orgRemoveMemberForm struct {
+	UserID int `form:"user_id"`
+}
+
+type orgRetentionForm struct {
+	RetentionDays int               `form:"retention_days" validate:"required,min=1,max=365"`
+	FieldErrors   map[string]string `form:"-"`
+}
+
+// retentionError is the message for an invalid retention_days field.
+const retentionError = "This field must be between 1 and 365 days"
+
+func (app *application) orgs(w http.ResponseWriter, r *http.Request) {
+	app.renderOrgs(w, r, http.StatusOK, orgCreateForm{RetentionDays: 365})
+}
+
+func (app *application) renderOrgs(w http.ResponseWriter, r *http.Request, status int, form orgCreateForm) {
+	ctx := r.Context()
+	orgs, err := app.store.Organizations.ListByUser(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Orgs = orgs
+	app.render(w, r, status, "orgs.html", data)
+}
+
+// orgsPost creates an organization with the signed-in user as its owner.
+func (app *application) orgsPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var form orgCreateForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Name = strings.TrimSpace(form.Name)
+	if err := validate.Struct(form); err != nil {
+		form.FieldErrors = make(map[string]string)
+		if ve, ok := err.(validator.ValidationErrors); ok {
+			for _, fe := range ve {
+				switch {
+				case fe.Field() == "RetentionDays":
+					form.FieldErrors["retention_days"] = retentionError
+				case fe.Tag() == "max":
+					form.FieldErrors["name"] = "This field cannot be more than 100 characters long"
+				default:
+					form.FieldErrors["name"] = "This field cannot be blank"
+				}
+			}
+		}
+		app.renderOrgs(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(ctx, "authenticatedUserID")
+	org := &store.Organization{Name: form.Name, RetentionDays: form.RetentionDays}
+	if err := app.store.Organizations.Insert(ctx, org, userID); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "organization created", slog.Int("org_id", org.ID))
+	app.sessionManager.Put(ctx, "flash", fmt.Sprintf("%s has been created.", org.Name))
+	http.Redirect(w, r, fmt.Sprintf("/orgs/%d", org.ID), http.StatusSeeOther)
+}
+
+// orgForMember loads the organization named in the URL for the signed-in
+// user. It writes the response itself and returns false if they are not a
+// member, answering 404 so as not to reveal which organizations exist.
+func (app *application) orgForMember(w http.ResponseWriter, r *http.Request) (*store.Organization, bool) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		app.clientError(w, http.StatusNotFound)
+		return nil, false
+	}
+	org, err := app.store.Organizations.GetForMember(ctx, id, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	return org, true
+}
+
+// orgForOwner is orgForMember for actions only owners may take.
+func (app *application) orgForOwner(w http.ResponseWriter, r *http.Request) (*store.Organization, bool) {
+	org, ok := app.orgForMember(w, r)
+	if ok && org.Role != store.OrgRoleOwner {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return org, ok
+}
+
+func (app *application) org(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgForMember(w, r)
+	if !ok {
+		return
+	}
+	app.renderOrg(w, r, http.StatusOK, org, nil)
+}
+
+// renderOrg renders the organization's page. form is the form that failed
+// validation, if any.
+func (app *application) renderOrg(w http.ResponseWriter, r *http.Request, status int, org *store.Organization, form any) {
+	ctx := r.Context()
+	members, err := app.store.Organizations.ListMembers(ctx, org.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Org = &orgView{
+		Org:     *org,
+		Members: members,
+		UserID:  app.sessionManager.GetInt(ctx, "authenticatedUserID"),
+		Roles:   store.OrgRoles,
+	}
+	app.render(w, r, status, "org.html", data)
+}
+
+// orgMembersPost adds a member by email address, or changes the role of
+// an existing one.
+func (app *application) orgMembersPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	org, ok := app.orgForOwner(w, r)
+	if !ok {
+		return
+	}
+	var form orgMemberForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	role, err := store.ParseOrgRole(form.Role)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Email = strings.TrimSpace(form.Email)
+	rerender := func(msg string) {
+		form.FieldErrors = map[string]string{"email": msg}
+		app.renderOrg(w, r, http.StatusUnprocessableEntity, org, form)
+	}
+	if err := validate.Struct(form); err != nil {
+		rerender("This field must be a valid email address")
+		return
+	}
+	user, err := app.store.Users.GetByEmail(ctx, form.Email)
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidCredentials) {
+			rerender("No account uses this email address")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	_, err = app.store.Organizations.GetForMember(ctx, org.ID, user.ID)
+	if err != nil && !errors.Is(err, store.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	added := err != nil
+
+	if err := app.store.Organizations.SetMember(ctx, org.ID, user.ID, role); err != nil {
+		if errors.Is(err, store.ErrLastOwner) {
+			rerender("Make someone else an owner first")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	flash := fmt.Sprintf("%s is now a %s.", user.Username, role)
+	if added {
+		flash = fmt.Sprintf("%s has been added as a %s.", user.Username, role)
+		app.sendEmail(user.ID, mailer.Message{
+			To:      user.Email,
+			Subject: fmt.Sprintf("You have been added to %s on Snippetbin", org.Name),
+			Body: fmt.Sprintf("Hi %s,\n\n"+
+				"You are now a %s of %s, so you can view the snippets its members share and create your own.\n\n"+
+				"%s/orgs/%d\n",
+				user.Username, role, org.Name, app.baseURL, org.ID),
+		})
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "organization member set", slog.Int("org_id", org.ID), slog.Int("target_user_id", user.ID), slog.String("role", string(role)))
+	app.sessionManager.Put(ctx, "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/orgs/%d", org.ID), http.StatusSeeOther)
+}
+
+// orgMemberRemovePost lets owners remove any member and members remove
+// themselves. The snippets a removed member created stay in the
+// organization, and the member can no longer view them.
+func (app *application) orgMemberRemovePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	org, ok := app.orgForMember(w, r)
+	if !ok {
+		return
+	}
+	var form orgRemoveMemberForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	userID := app.sessionManager.GetInt(ctx, "authenticatedUserID")
+	leaving := form.UserID == userID
+	if !leaving && org.Role != store.OrgRoleOwner {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	if err := app.store.Organizations.RemoveMember(ctx, org.ID, form.UserID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.clientError(w, http.StatusNotFound)
+		case errors.Is(err, store.ErrLastOwner):
+			app.sessionManager.Put(ctx, "flash", "Make someone else an owner first.")
+			http.Redirect(w, r, fmt.Sprintf("/orgs/%d", org.ID), http.StatusSeeOther)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "organization member removed", slog.Int("org_id", org.ID), slog.Int("target_user_id", form.UserID))
+	if leaving {
+		app.sessionManager.Put(ctx, "flash", fmt.Sprintf("You have left %s.", org.Name))
+		http.Redirect(w, r, "/orgs", http.StatusSeeOther)
+		return
+	}
+	app.sessionManager.Put(ctx, "flash", "The member has been removed.")
+	http.Redirect(w, r, fmt.Sprintf("/orgs/%d", org.ID), http.StatusSeeOther)
+}
+
+// orgRetentionPost changes how long the organization keeps snippets.
+// Lowering it also shortens the snippets already created.
+func (app *application) orgRetentionPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	org, ok := app.orgForOwner(w, r)
+	if !ok {
+		return
+	}
+	var form orgRetentionForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(form); err != nil {
+		form.FieldErrors = map[string]string{"retention_days": retentionError}
+		app.renderOrg(w, r, http.StatusUnprocessableEntity, org, form)
+		return
+	}
+
+	shortened, err := app.store.Organizations.SetRetention(ctx, org.ID, form.RetentionDays)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "organization retention changed", slog.Int("org_id", org.ID), slog.Int("retention_days", form.RetentionDays), slog.Int64("shortened", shortened))
+	app.sessionManager.Put(ctx, "flash", fmt.Sprintf("Snippets are now kept for at most %d days. %d existing snippets were shortened.", form.RetentionDays, shortened))
+	http.Redirect(w, r, fmt.Sprintf("/orgs/%d", org.ID), http.StatusSeeOther)
+}
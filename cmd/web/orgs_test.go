@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+// newOrg creates an organization as the user logged in to ts, who becomes
+// its owner, and adds memberEmail to it if it is set.
+func newOrg(t *testing.T, ts *testServer, retentionDays, memberEmail string) {
+	t.Helper()
+	_, _, body := ts.get(t, "/orgs")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("name", "Acme")
+	form.Add("retention_days", retentionDays)
+	code, header, _ := ts.postForm(t, "/orgs", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/orgs/1")
+
+	if memberEmail != "" {
+		form.Set("email", memberEmail)
+		form.Set("role", "member")
+		code, _, _ = ts.postForm(t, "/orgs/1/members", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+	}
+}
+
+// newOrgSnippet creates a snippet in organization 1 and returns the path
+// it can be viewed at, key included.
+func newOrgSnippet(t *testing.T, ts *testServer, expires string) string {
+	t.Helper()
+	_, _, body := ts.get(t, "/snippet/create")
+	assert.StringContains(t, body, "Members of Acme")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("title", "Launch plan")
+	form.Add("content", "Ship it on Friday")
+	form.Add("expires", expires)
+	form.Add("org", "1")
+	code, header, _ := ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	return header.Get("Location")
+}
+
+func TestOrgSnippetView(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	owner := newTestServer(t, app.routes())
+	defer owner.Close()
+	owner.login(t, store.MockUser.Email, store.MockUserPassword)
+	newOrg(t, owner, "365", store.MockModeratorUser.Email)
+	link := newOrgSnippet(t, owner, "7")
+
+	tests := []struct {
+		name         string
+		email        string
+		wantCode     int
+		wantLocation string
+	}{
+		{"Anonymous", "", http.StatusSeeOther, "/user/login"},
+		{"Outsider", store.MockAdminUser.Email, http.StatusNotFound, ""},
+		{"Member", store.MockModeratorUser.Email, http.StatusOK, ""},
+		{"Owner", store.MockUser.Email, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+			if tt.email != "" {
+				ts.login(t, tt.email, store.MockUserPassword)
+			}
+			code, header, body := ts.get(t, link)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			if code == http.StatusOK {
+				assert.StringContains(t, body, "Ship it on Friday")
+				assert.Equal(t, header.Get("Cache-Control"), "no-store")
+			} else {
+				assert.Equal(t, strings.Contains(body, "Ship it on Friday"), false)
+			}
+		})
+	}
+}
+
+func TestOrgSnippetCreate(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	owner := newTestServer(t, app.routes())
+	defer owner.Close()
+	owner.login(t, store.MockUser.Email, store.MockUserPassword)
+	newOrg(t, owner, "7", "")
+
+	_, _, body := owner.get(t, "/snippet/create")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("title", "Launch plan")
+	form.Add("content", "Ship it on Friday")
+	form.Add("expires", "365")
+	form.Add("org", "1")
+	code, _, body := owner.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Acme keeps snippets for at most 7 days")
+
+	outsider := newTestServer(t, app.routes())
+	defer outsider.Close()
+	outsider.login(t, store.MockAdminUser.Email, store.MockUserPassword)
+	_, _, body = outsider.get(t, "/snippet/create")
+	assert.Equal(t, strings.Contains(body, "Members of Acme"), false)
+	form.Set("csrf_token", extractCSRFToken(t, body))
+	form.Set("expires", "1")
+	code, _, body = outsider.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "You are not a member of this organization")
+}
+
+func TestOrgMembers(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	owner := newTestServer(t, app.routes())
+	defer owner.Close()
+	member := newTestServer(t, app.routes())
+	defer member.Close()
+	owner.login(t, store.MockUser.Email, store.MockUserPassword)
+	member.login(t, store.MockModeratorUser.Email, store.MockUserPassword)
+	newOrg(t, owner, "365", store.MockModeratorUser.Email)
+
+	app.wg.Wait()
+	sent := app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, store.MockModeratorUser.Email)
+	assert.StringContains(t, sent[0].Body, "https://snippetbin.test/orgs/1")
+
+	code, _, body := member.get(t, "/orgs/1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, store.MockUser.Username)
+	assert.Equal(t, strings.Contains(body, "Change retention"), false)
+
+	outsider := newTestServer(t, app.routes())
+	defer outsider.Close()
+	outsider.login(t, store.MockAdminUser.Email, store.MockUserPassword)
+	code, _, _ = outsider.get(t, "/orgs/1")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	// Members can't manage the organization or remove anyone but themselves.
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("email", store.MockAdminUser.Email)
+	form.Add("role", "member")
+	code, _, _ = member.postForm(t, "/orgs/1/members", form)
+	assert.Equal(t, code, http.StatusForbidden)
+	form.Set("user_id", "1")
+	code, _, _ = member.postForm(t, "/orgs/1/members/remove", form)
+	assert.Equal(t, code, http.StatusForbidden)
+	form.Set("retention_days", "1")
+	code, _, _ = member.postForm(t, "/orgs/1/retention", form)
+	assert.Equal(t, code, http.StatusForbidden)
+
+	// The only owner can neither leave nor stop being an owner.
+	_, _, body = owner.get(t, "/orgs/1")
+	form.Set("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = owner.postForm(t, "/orgs/1/members/remove", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body = owner.get(t, "/orgs/1")
+	assert.StringContains(t, body, "Make someone else an owner first.")
+	form.Set("csrf_token", extractCSRFToken(t, body))
+	form.Set("email", store.MockUser.Email)
+	code, _, body = owner.postForm(t, "/orgs/1/members", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Make someone else an owner first")
+
+	form.Set("email", "nobody@example.com")
+	code, _, body = owner.postForm(t, "/orgs/1/members", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "No account uses this email address")
+
+	// Once there is another owner, the first one can leave.
+	form.Set("email", store.MockModeratorUser.Email)
+	form.Set("role", "owner")
+	code, _, _ = owner.postForm(t, "/orgs/1/members", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, header, _ := owner.postForm(t, "/orgs/1/members/remove", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/orgs")
+	code, _, _ = owner.get(t, "/orgs/1")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestOrgRetention(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	owner := newTestServer(t, app.routes())
+	defer owner.Close()
+	owner.login(t, store.MockUser.Email, store.MockUserPassword)
+	newOrg(t, owner, "365", "")
+	newOrgSnippet(t, owner, "365")
+
+	_, _, body := owner.get(t, "/orgs/1")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("retention_days", "0")
+	code, _, body := owner.postForm(t, "/orgs/1/retention", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, retentionError)
+
+	form.Set("retention_days", "7")
+	code, _, _ = owner.postForm(t, "/orgs/1/retention", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body = owner.get(t, "/orgs/1")
+	assert.StringContains(t, body, "1 existing snippets were shortened")
+
+	s, err := app.store.Snippets.Get(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Expires.Before(time.Now().AddDate(0, 0, 8)), true)
+}
+
+func TestOrgOwnerAccountDelete(t *testing.T) {
+	deleteAccount := func(t *testing.T, ts *testServer) (int, string) {
+		t.Helper()
+		_, _, body := ts.get(t, "/account/delete")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		form.Add("password", store.MockUserPassword)
+		code, _, body := ts.postForm(t, "/account/delete", form)
+		return code, body
+	}
+
+	t.Run("Only owner", func(t *testing.T) {
+		app := newTestApplication(t, newConfig(t))
+		owner := newTestServer(t, app.routes())
+		defer owner.Close()
+		owner.login(t, store.MockUser.Email, store.MockUserPassword)
+		newOrg(t, owner, "365", store.MockModeratorUser.Email)
+
+		code, body := deleteAccount(t, owner)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "You are the only owner of an organization that has other members.")
+
+		// Once the member is an owner too, the account can go and the
+		// organization stays with them.
+		_, _, body = owner.get(t, "/orgs/1")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		form.Add("email", store.MockModeratorUser.Email)
+		form.Add("role", "owner")
+		code, _, _ = owner.postForm(t, "/orgs/1/members", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+		code, _ = deleteAccount(t, owner)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		members, err := app.store.Organizations.ListMembers(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(members), 1)
+		assert.Equal(t, members[0].UserID, store.MockModeratorUser.ID)
+		assert.Equal(t, members[0].Role, store.OrgRoleOwner)
+	})
+
+	t.Run("Only member", func(t *testing.T) {
+		app := newTestApplication(t, newConfig(t))
+		owner := newTestServer(t, app.routes())
+		defer owner.Close()
+		owner.login(t, store.MockUser.Email, store.MockUserPassword)
+		newOrg(t, owner, "365", "")
+		newOrgSnippet(t, owner, "7")
+
+		code, _ := deleteAccount(t, owner)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		// The organization went with its only member, snippets included.
+		ctx := context.Background()
+		members, err := app.store.Organizations.ListMembers(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(members), 0)
+		_, err = app.store.Snippets.Get(ctx, 2)
+		assert.Equal(t, err, store.ErrNoRecord)
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/theluminousartemis/snippetbin/internal/mailer"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+type userPasswordForgotForm struct {
+	Email       string            `form:"email" validate:"required,email"`
+	FieldErrors map[string]string `form:"-"`
+}
+
+type userPasswordResetForm struct {
+	Token              string            `form:"token" validate:"required"`
+	NewPassword        string            `form:"newPassword" validate:"required,min=8"`
+	NewPasswordConfirm string            `form:"newPasswordConfirmation" validate:"required,min=8,eqfield=NewPassword"`
+	FieldErrors        map[string]string `form:"-"`
+}
+
+func (app *application) userPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userPasswordForgotForm{}
+	app.render(w, r, http.StatusOK, "forgot.html", data)
+}
+
+func (app *application) userPasswordForgotPost(w http.ResponseWriter, r *http.Request) {
+	var form userPasswordForgotForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if err := validate.Struct(form); err != nil {
+		form.FieldErrors = map[string]string{"email": "This field must be a valid email address"}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "forgot.html", data)
+		return
+	}
+
+	// The lookup happens in the background so neither the response nor its
+	// timing reveals whether an account exists for the address.
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := app.sendPasswordReset(ctx, form.Email); err != nil {
+			app.logger.Error("sending password reset", slog.String("error", err.Error()))
+		}
+	})
+
+	app.sessionManager.Put(r.Context(), "flash", "If an account exists for that email address, we've sent it a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// sendPasswordReset stores a reset token for the account registered to
+// email, if there is one, and mails the owner a link containing it.
+func (app *application) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidCredentials) {
+			return nil
+		}
+		return err
+	}
+
+	link, err := app.newPasswordResetLink(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Snippetbin password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password for your account. To choose a new one, open the link below. "+
+			"It expires in %s and can only be used once.\n\n"+
+			"%s\n\n"+
+			"If you did not ask for this, you can ignore this email; your password has not been changed.\n",
+			user.Username, app.passwordResetTTL, link),
+	}
+	return app.mailer.Send(ctx, msg)
+}
+
+// newPasswordResetLink stores a reset token for the user and returns the
+// link that uses it.
+func (app *application) newPasswordResetLink(ctx context.Context, userID int) (string, error) {
+	token, hash, err := store.NewToken()
+	if err != nil {
+		return "", err
+	}
+	if err := app.store.PasswordResets.Insert(ctx, userID, hash, time.Now().Add(app.passwordResetTTL)); err != nil {
+		return "", err
+	}
+	return app.baseURL + "/user/password/reset?token=" + url.QueryEscape(token), nil
+}
+
+func (app *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if _, err := app.store.PasswordResets.GetUserID(r.Context(), store.HashToken(token)); err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.invalidPasswordReset(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = userPasswordResetForm{Token: token}
+	app.render(w, r, http.StatusOK, "reset.html", data)
+}
+
+func (app *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	var form userPasswordResetForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(form); err != nil {
+		form.FieldErrors = make(map[string]string)
+		if ve, ok := err.(validator.ValidationErrors); ok {
+			for _, fe := range ve {
+				field := strings.ToLower(fe.Field())
+				switch fe.Tag() {
+				case "required":
+					form.FieldErrors[field] = "This field cannot be blank"
+				case "min":
+					form.FieldErrors[field] = "This field must be atleast 8 characters long"
+				case "eqfield":
+					form.FieldErrors[field] = "Passwords do not match"
+				}
+			}
+		}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.html", data)
+		return
+	}
+
+	ctx := r.Context()
+	id, err := app.store.PasswordResets.GetUserID(ctx, store.HashToken(form.Token))
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.invalidPasswordReset(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	user, err := app.store.Users.GetByID(ctx, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	msg, err := app.passwordPolicy.Check(form.NewPassword, user.Username, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if msg != "" {
+		form.FieldErrors = map[string]string{"newpassword": msg}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.html", data)
+		return
+	}
+
+	id, err = app.store.PasswordResets.Reset(ctx, store.HashToken(form.Token), form.NewPassword)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.invalidPasswordReset(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Proving control of the mailbox is enough to lift a lockout.
+	if err := app.loginGuard.UnlockAccount(ctx, user.Email); err != nil {
+		app.logger.Error("unlocking account after password reset", slog.Int("user_id", id), slog.String("error", err.Error()))
+	}
+	app.notifyPasswordReset(user)
+
+	// The reset logged the user out everywhere; make that visible here too.
+	if err := app.sessionManager.RenewToken(ctx); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Remove(ctx, "authenticatedUserID")
+	app.sessionManager.Remove(ctx, "sessionID")
+	app.sessionManager.Put(ctx, "flash", "Your password has been reset. Please log in with your new password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) invalidPasswordReset(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Flash = "This password reset link is invalid, has expired or has already been used."
+	app.render(w, r, http.StatusBadRequest, "reset.html", data)
+}
+
+// notifyPasswordReset tells the owner of user that their password was
+// changed, so an unexpected reset does not go unnoticed.
+func (app *application) notifyPasswordReset(user *store.User) {
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Your Snippetbin password was changed",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"The password for your account was just reset and every device that was logged in has been logged out.\n\n"+
+			"If this was not you, reset your password again at %s and check that your email account is secure.\n",
+			user.Username, app.baseURL+"/user/password/forgot"),
+	}
+	app.sendEmail(user.ID, msg)
+}
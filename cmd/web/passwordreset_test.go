@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+func TestUserPasswordForgot(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/forgot")
+	csrfToken := extractCSRFToken(t, body)
+	forgot := func(email string) (int, http.Header) {
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("csrf_token", csrfToken)
+		code, header, _ := ts.postForm(t, "/user/password/forgot", form)
+		return code, header
+	}
+
+	// Known and unknown addresses get the same response.
+	code, header := forgot("nobody@example.com")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+	app.wg.Wait()
+	assert.Equal(t, len(app.mailer.(*testMailer).sent()), 0)
+
+	code, header = forgot(store.MockUser.Email)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+	app.wg.Wait()
+	sent := app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, store.MockUser.Email)
+	assert.StringContains(t, sent[0].Body, "https://snippetbin.test/user/password/reset?token=")
+
+	code, _ = forgot("not-an-email")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+}
+
+func TestUserPasswordReset(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	victim := newTestServer(t, app.routes())
+	defer victim.Close()
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Another device is logged in to the account being reset.
+	_, _, body := victim.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", store.MockUser.Email)
+	form.Add("password", store.MockUserPassword)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := victim.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _, _ = victim.get(t, "/account/")
+	assert.Equal(t, code, http.StatusOK)
+
+	_, _, body = ts.get(t, "/user/password/forgot")
+	csrfToken := extractCSRFToken(t, body)
+	form = url.Values{}
+	form.Add("email", store.MockUser.Email)
+	form.Add("csrf_token", csrfToken)
+	ts.postForm(t, "/user/password/forgot", form)
+	app.wg.Wait()
+	sent := app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 1)
+	link := sent[0].Body[strings.Index(sent[0].Body, "https://"):]
+	link = strings.TrimPrefix(strings.Fields(link)[0], "https://snippetbin.test")
+	token, err := url.ParseQuery(strings.TrimPrefix(link, "/user/password/reset?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _, body = ts.get(t, link)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `<form action='/user/password/reset'`)
+
+	reset := func(token, password, confirm string) (int, http.Header, string) {
+		form := url.Values{}
+		form.Add("token", token)
+		form.Add("newPassword", password)
+		form.Add("newPasswordConfirmation", confirm)
+		form.Add("csrf_token", csrfToken)
+		return ts.postForm(t, "/user/password/reset", form)
+	}
+
+	code, _, body = reset(token.Get("token"), "quiet-harbour-velvet-17", "different")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Passwords do not match")
+
+	code, _, body = reset(token.Get("token"), "password123", "password123")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "This password is too easy to guess.")
+
+	code, header, _ := reset(token.Get("token"), "quiet-harbour-velvet-17", "quiet-harbour-velvet-17")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	app.wg.Wait()
+	sent = app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 2)
+	assert.StringContains(t, sent[1].Subject, "password was changed")
+
+	// Tokens are single use.
+	code, _, body = reset(token.Get("token"), "quiet-harbour-velvet-17", "quiet-harbour-velvet-17")
+	assert.Equal(t, code, http.StatusBadRequest)
+	assert.StringContains(t, body, "invalid, has expired or has already been used")
+
+	// The other device was logged out.
+	code, header, _ = victim.get(t, "/account/")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	code, _, _ = ts.get(t, "/user/password/reset?token=bogus")
+	assert.Equal(t, code, http.StatusBadRequest)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/theluminousartemis/snippetbin/internal/ratelimiter"
+	"github.com/theluminousartemis/snippetbin/internal/store/cache"
+)
+
+const (
+	policyDefault = "default"
+	policyAuth    = "auth"
+	policyCreate  = "create"
+	policyView    = "view"
+)
+
+var rateLimitPolicies = []string{policyDefault, policyAuth, policyCreate, policyView}
+
+type rateLimitIdentity int
+
+const (
+	byIP rateLimitIdentity = iota
+	// byUser limits authenticated users by their ID, wherever they connect
+	// from, and falls back to the client IP for anonymous requests.
+	byUser
+)
+
+// rateLimitRoute assigns a policy to requests with the given method and
+// path. A path ending in "/" matches every path below it.
+type rateLimitRoute struct {
+	method   string
+	path     string
+	policy   string
+	identity rateLimitIdentity
+}
+
+// rateLimitRoutes is checked in order; requests matching no entry fall under
+// the default policy, keyed by IP.
+var rateLimitRoutes = []rateLimitRoute{
+	{method: http.MethodPost, path: "/user/login", policy: policyAuth, identity: byIP},
+	{method: http.MethodPost, path: "/user/login/2fa", policy: policyAuth, identity: byIP},
+	{method: http.MethodPost, path: "/user/login/2fa/passkey/finish", policy: policyAuth, identity: byIP},
+	{method: http.MethodPost, path: "/user/login/passkey/finish", policy: policyAuth, identity: byIP},
+	{method: http.MethodGet, path: "/user/login/oidc/callback", policy: policyAuth, identity: byIP},
+	{method: http.MethodPost, path: "/user/signup", policy: policyAuth, identity: byIP},
+	{method: http.MethodPost, path: "/user/password/forgot", policy: policyAuth, identity: byIP},
+	{method: http.MethodPost, path: "/user/password/reset", policy: policyAuth, identity: byIP},
+	{method: http.MethodPost, path: "/account/verify", policy: policyAuth, identity: byUser},
+	{method: http.MethodPost, path: "/account/2fa/setup", policy: policyAuth, identity: byUser},
+	{method: http.MethodPost, path: "/account/2fa/disable", policy: policyAuth, identity: byUser},
+	{method: http.MethodPost, path: "/account/passkeys/register/finish", policy: policyAuth, identity: byUser},
+	{method: http.MethodPost, path: "/snippet/create", policy: policyCreate, identity: byUser},
+	{method: http.MethodGet, path: "/snippet/view/", policy: policyView, identity: byIP},
+}
+
+func matchRateLimitRoute(r *http.Request) rateLimitRoute {
+	for _, route := range rateLimitRoutes {
+		if route.method != r.Method {
+			continue
+		}
+		if route.path == r.URL.Path || (strings.HasSuffix(route.path, "/") && strings.HasPrefix(r.URL.Path, route.path)) {
+			return route
+		}
+	}
+	return rateLimitRoute{policy: policyDefault, identity: byIP}
+}
+
+// newRateLimiters creates a limiter for every policy in cfg. Redis backed
+// limiters are wrapped so a Redis outage falls back as configured rather
+// than failing requests.
+func newRateLimiters(store cache.Storage, cfg rateLimitConfig, logger *slog.Logger) (map[string]ratelimiter.Limiter, error) {
+	limiters := make(map[string]ratelimiter.Limiter, len(rateLimitPolicies))
+	for _, policy := range rateLimitPolicies {
+		p, ok := cfg.policies[policy]
+		if !ok {
+			return nil, fmt.Errorf("ratelimiter: no configuration for policy %q", policy)
+		}
+		limiter, err := ratelimiter.New(cfg.strategy, store, *p)
+		if err != nil {
+			return nil, fmt.Errorf("ratelimiter policy %s: %w", policy, err)
+		}
+		if cfg.strategy != ratelimiter.StrategyMemory {
+			limiter, err = ratelimiter.NewFallbackLimiter(policy, limiter, cfg.fallback, logger)
+			if err != nil {
+				return nil, fmt.Errorf("ratelimiter policy %s: %w", policy, err)
+			}
+		}
+		limiters[policy] = limiter
+	}
+	return limiters, nil
+}
+
+// rateLimitIP applies the policies keyed by client IP. It runs before the
+// session is loaded, so a request it rejects costs no database work;
+// routes that are not wrapped, such as static files and health checks,
+// are never limited.
+func (app *application) rateLimitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := matchRateLimitRoute(r)
+		if route.identity != byIP || app.allowRequest(w, r, route) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// rateLimitUser applies the policies keyed by user. It runs after
+// authenticate so it can see who is logged in.
+func (app *application) rateLimitUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := matchRateLimitRoute(r)
+		if route.identity != byUser || app.allowRequest(w, r, route) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allowRequest counts r against the policy of route. If the limit has
+// been reached it writes the rejection and returns false.
+func (app *application) allowRequest(w http.ResponseWriter, r *http.Request, route rateLimitRoute) bool {
+	limiter := app.rateLimiters[route.policy]
+	if !limiter.Config().Enabled {
+		return true
+	}
+
+	addr, err := ratelimiter.ClientIP(r.RemoteAddr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return false
+	}
+	if app.rateLimitAllowlist.Contains(addr) {
+		return true
+	}
+
+	key := fmt.Sprintf("%s:ip:%s", route.policy, ratelimiter.IPKey(addr, app.rateLimitIPv6PrefixLen))
+	if route.identity == byUser && app.isAuthenticated(r) {
+		key = fmt.Sprintf("%s:user:%d", route.policy, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	}
+
+	res, err := limiter.Allow(r.Context(), key)
+	if err != nil {
+		app.serverError(w, r, err)
+		return false
+	}
+	setRateLimitHeaders(w, res)
+	if !res.Allowed {
+		app.metrics.RateLimitRejections.WithLabelValues(route.policy).Inc()
+		app.rateLimitExceededResponse(w, r, res)
+		return false
+	}
+	return true
+}
+
+// realIP sets r.RemoteAddr to the client address forwarded by a trusted
+// proxy. Forwarded headers on requests that did not come straight from a
+// trusted proxy are ignored: anyone can send them, and a client that could
+// pick its own address would pick a fresh rate limit each time, or one
+// inside the allowlist.
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr, ok := app.forwardedClient(r); ok {
+			r.RemoteAddr = addr.String()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedClient returns the client named by the forwarded headers of a
+// request from a trusted proxy. X-Forwarded-For is read from the nearest
+// hop back, and the first address that is not itself a trusted proxy is
+// the client; anything to the left of it was sent by the client.
+// X-Real-IP is used when there is no X-Forwarded-For.
+func (app *application) forwardedClient(r *http.Request) (netip.Addr, bool) {
+	peer, err := ratelimiter.ClientIP(r.RemoteAddr)
+	if err != nil || !app.trustedProxies.Contains(peer) {
+		return netip.Addr{}, false
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	var client netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		client = addr.Unmap()
+		if !app.trustedProxies.Contains(client) {
+			break
+		}
+	}
+	if client.IsValid() {
+		return client, true
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// clientIP identifies the client the same way the rate limiter does, with
+// IPv6 addresses grouped by prefix.
+func (app *application) clientIP(r *http.Request) string {
+	addr, err := ratelimiter.ClientIP(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ratelimiter.IPKey(addr, app.rateLimitIPv6PrefixLen)
+}
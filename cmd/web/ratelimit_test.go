@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+	"github.com/theluminousartemis/snippetbin/internal/store/cache"
+)
+
+func TestRateLimitPolicies(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.rateLimit.policies[policyDefault].RequestsPerTimeFrame = 3
+	cfg.rateLimit.policies[policyAuth].RequestsPerTimeFrame = 1
+	app := newTestApplication(t, cfg)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "unknown@example.com")
+	form.Add("password", "wrongpassword")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	code, _, _ = ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	// The strict login limit does not count against the rest of the site.
+	code, _, _ = ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusOK)
+	code, _, _ = ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusOK)
+	code, _, _ = ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	// Health checks and static files are never limited.
+	code, _, _ = ts.get(t, "/health")
+	assert.Equal(t, code, http.StatusOK)
+	code, _, _ = ts.get(t, "/static/css/main.css")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestRateLimitAllowlist(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.rateLimit.policies[policyDefault].RequestsPerTimeFrame = 1
+	cfg.rateLimit.allowlist = []string{"127.0.0.0/8", "::1"}
+	app := newTestApplication(t, cfg)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for range 3 {
+		code, _, _ := ts.get(t, "/about")
+		assert.Equal(t, code, http.StatusOK)
+	}
+}
+
+func TestRateLimitTrustedProxies(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.rateLimit.policies[policyDefault].RequestsPerTimeFrame = 1
+	cfg.rateLimit.allowlist = []string{"198.51.100.0/24"}
+	cfg.trustedProxies = []string{"10.0.0.0/8"}
+	app := newTestApplication(t, cfg)
+	handler := app.realIP(app.rateLimitIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	})))
+	get := func(remoteAddr string, header http.Header) (int, string) {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/about", nil)
+		r.RemoteAddr = remoteAddr
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		handler.ServeHTTP(rr, r)
+		return rr.Code, rr.Body.String()
+	}
+
+	// Forwarded headers from an untrusted peer are ignored, so changing
+	// them neither lands in the allowlist nor gets a fresh limit.
+	code, addr := get("203.0.113.5:40000", http.Header{"X-Real-Ip": {"198.51.100.7"}})
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, addr, "203.0.113.5:40000")
+	code, _ = get("203.0.113.5:40000", http.Header{"X-Forwarded-For": {"198.51.100.7"}})
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	code, _ = get("203.0.113.5:40000", http.Header{"X-Forwarded-For": {"192.0.2.99"}})
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	// Through a trusted proxy the client is the nearest untrusted hop.
+	// Addresses the client put further left are ignored.
+	for range 2 {
+		code, addr = get("10.0.0.2:40000", http.Header{"X-Forwarded-For": {"192.0.2.1, 198.51.100.7, 10.0.0.3"}})
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, addr, "198.51.100.7")
+	}
+	code, addr = get("10.0.0.2:40000", http.Header{"X-Forwarded-For": {"198.51.100.7, 192.0.2.1"}})
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, addr, "192.0.2.1")
+	code, _ = get("10.0.0.2:40000", http.Header{"X-Forwarded-For": {"198.51.100.8, 192.0.2.1"}})
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	code, addr = get("10.0.0.2:40000", http.Header{"X-Real-Ip": {"192.0.2.2"}})
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, addr, "192.0.2.2")
+}
+
+func TestRateLimitByUser(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.rateLimit.policies[policyCreate].RequestsPerTimeFrame = 1
+	app := newTestApplication(t, cfg)
+
+	next := app.authenticate(app.rateLimitUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})))
+	err := app.store.Sessions.Insert(context.Background(), &store.Session{ID: "session", UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := func(userID int) http.Handler {
+		return app.sessionManager.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if userID != 0 {
+				app.sessionManager.Put(r.Context(), "authenticatedUserID", userID)
+				app.sessionManager.Put(r.Context(), "sessionID", "session")
+			}
+			next.ServeHTTP(w, r)
+		}))
+	}
+	createSnippet := func(userID int, remoteAddr string) int {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/snippet/create", nil)
+		r.RemoteAddr = remoteAddr
+		handler(userID).ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	assert.Equal(t, createSnippet(1, "198.51.100.1:40000"), http.StatusOK)
+	// The same user is limited from a different address...
+	assert.Equal(t, createSnippet(1, "203.0.113.9:40000"), http.StatusTooManyRequests)
+	// ...while an anonymous request from the first address is counted
+	// separately, by IP.
+	assert.Equal(t, createSnippet(0, "198.51.100.1:40000"), http.StatusOK)
+	// IPv6 clients share a limit across their /64.
+	assert.Equal(t, createSnippet(0, "[2001:db8:1:2::10]:40000"), http.StatusOK)
+	assert.Equal(t, createSnippet(0, "[2001:db8:1:2::11]:40000"), http.StatusTooManyRequests)
+	assert.Equal(t, createSnippet(0, "[2001:db8:1:3::10]:40000"), http.StatusOK)
+}
+
+// countingSessionStore counts loads and saves of session data.
+type countingSessionStore struct {
+	scs.Store
+	calls atomic.Int64
+}
+
+func (s *countingSessionStore) Find(token string) ([]byte, bool, error) {
+	s.calls.Add(1)
+	return s.Store.Find(token)
+}
+
+func (s *countingSessionStore) Commit(token string, b []byte, expiry time.Time) error {
+	s.calls.Add(1)
+	return s.Store.Commit(token, b, expiry)
+}
+
+// sessionsStore is the store.Storage Sessions interface.
+type sessionsStore interface {
+	Insert(context.Context, *store.Session) error
+	Touch(ctx context.Context, id string, userID int, ip string) error
+	ListByUser(ctx context.Context, userID int) ([]store.Session, error)
+	Delete(ctx context.Context, userID int, id string) error
+	DeleteAllForUser(ctx context.Context, userID int, keep string) (int64, error)
+	DeleteExpired(ctx context.Context, lifetime time.Duration) (int64, error)
+}
+
+// countingSessions counts the Touch calls authenticate makes.
+type countingSessions struct {
+	sessionsStore
+	touches atomic.Int64
+}
+
+func (s *countingSessions) Touch(ctx context.Context, id string, userID int, ip string) error {
+	s.touches.Add(1)
+	return s.sessionsStore.Touch(ctx, id, userID, ip)
+}
+
+func TestRateLimitBeforeSession(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.rateLimit.policies[policyDefault].RequestsPerTimeFrame = 3
+	app := newTestApplication(t, cfg)
+	sessionData := &countingSessionStore{Store: app.sessionManager.Store}
+	app.sessionManager.Store = sessionData
+	sessions := &countingSessions{sessionsStore: app.store.Sessions}
+	app.store.Sessions = sessions
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _ := ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	for range 2 {
+		code, _, _ := ts.get(t, "/about")
+		assert.Equal(t, code, http.StatusOK)
+	}
+	loads, touches := sessionData.calls.Load(), sessions.touches.Load()
+	assert.Equal(t, touches > 0, true)
+
+	// A rejected request is turned away before the session is loaded.
+	code, _, _ = ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, sessionData.calls.Load(), loads)
+	assert.Equal(t, sessions.touches.Load(), touches)
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.rateLimit.policies[policyDefault].RequestsPerTimeFrame = 2
+	app := newTestApplication(t, cfg)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("RateLimit-Limit"), "2")
+	assert.Equal(t, header.Get("RateLimit-Remaining"), "1")
+	assert.Equal(t, header.Get("RateLimit-Reset"), "1")
+	assert.Equal(t, header.Get("Retry-After"), "")
+
+	ts.get(t, "/about")
+	code, header, body := ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("RateLimit-Remaining"), "0")
+	assert.Equal(t, header.Get("Retry-After"), "1")
+	assert.StringContains(t, body, "Too Many Requests")
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/about", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json;q=0.9, text/plain;q=0.5")
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusTooManyRequests)
+	assert.Equal(t, rs.Header.Get("Content-Type"), "application/json")
+	assert.Equal(t, rs.Header.Get("Retry-After"), "1")
+
+	var got struct {
+		Error      string `json:"error"`
+		RetryAfter int    `json:"retry_after"`
+		Limit      int    `json:"limit"`
+	}
+	if err := json.NewDecoder(rs.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, got.Error, "rate limit exceeded")
+	assert.Equal(t, got.RetryAfter, 1)
+	assert.Equal(t, got.Limit, 2)
+}
+
+func TestCeilSeconds(t *testing.T) {
+	assert.Equal(t, ceilSeconds(0), 0)
+	assert.Equal(t, ceilSeconds(time.Millisecond), 1)
+	assert.Equal(t, ceilSeconds(90*time.Second), 90)
+	assert.Equal(t, ceilSeconds(90*time.Second+time.Millisecond), 91)
+}
+
+func TestRateLimitRedisDown(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.rateLimit.policies[policyDefault].RequestsPerTimeFrame = 2
+	app := newTestApplication(t, cfg)
+
+	// Nothing listens on port 1, so every rate limit call fails at once.
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer rdb.Close()
+	limiters, err := newRateLimiters(cache.NewRedisStore(rdb), cfg.rateLimit, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.rateLimiters = limiters
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Snippets can still be listed, and the local fallback keeps limiting.
+	code, _, _ := ts.get(t, "/")
+	assert.Equal(t, code, http.StatusOK)
+	code, _, _ = ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusOK)
+	code, _, _ = ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve runs srv until SIGINT or SIGTERM is received. On a signal /health
+// starts reporting that the server is draining, and after drainDelay the
+// server stops accepting connections and waits up to shutdownTimeout for
+// in-flight requests to complete.
+func (app *application) serve(srv *http.Server, certFile, keyFile string, drainDelay, shutdownTimeout time.Duration) error {
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+		signal.Stop(quit)
+
+		app.logger.Info("draining server", slog.String("signal", s.String()), slog.Duration("delay", drainDelay))
+		app.draining.Store(true)
+		time.Sleep(drainDelay)
+
+		app.logger.Info("shutting down server", slog.Duration("timeout", shutdownTimeout))
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdownError <- srv.Shutdown(ctx)
+	}()
+
+	app.logger.Info("starting server", slog.Any("addr", srv.Addr))
+	err := srv.ListenAndServeTLS(certFile, keyFile)
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+	app.logger.Info("stopped server", slog.Any("addr", srv.Addr))
+	return nil
+}
+
+// serveAdmin starts the admin listener in the background. It is shut down
+// after the main server so metrics can still be scraped while draining.
+func (app *application) serveAdmin(srv *http.Server) {
+	go func() {
+		app.logger.Info("starting admin server", slog.Any("addr", srv.Addr))
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error("admin server", slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// background runs fn in a goroutine that shutdown waits for. fn must return
+// once the workers' context is cancelled.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprint(err))
+			}
+		}()
+		fn()
+	}()
+}
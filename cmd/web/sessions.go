@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/theluminousartemis/snippetbin/internal/ratelimiter"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+// maxUserAgentLength bounds how much of the User-Agent header is stored
+// with a session.
+const maxUserAgentLength = 512
+
+// SessionView is a session as shown on the sessions page.
+type SessionView struct {
+	store.Session
+	Device  string
+	Current bool
+}
+
+// remoteIP is the client's address, as recorded for its session.
+func remoteIP(r *http.Request) string {
+	addr, err := ratelimiter.ClientIP(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return addr.String()
+}
+
+// startSession records a new session for userID and returns its ID, which
+// the caller keeps in the session data.
+func (app *application) startSession(r *http.Request, userID int) (string, error) {
+	id, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLength {
+		ua = strings.ToValidUTF8(ua[:maxUserAgentLength], "")
+	}
+	err = app.store.Sessions.Insert(r.Context(), &store.Session{ID: id, UserID: userID, IP: remoteIP(r), UserAgent: ua})
+	return id, err
+}
+
+// endSession logs the current session out and removes it from the user's
+// list.
+func (app *application) endSession(r *http.Request) error {
+	ctx := r.Context()
+	if id := app.sessionManager.GetString(ctx, "sessionID"); id != "" {
+		err := app.store.Sessions.Delete(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"), id)
+		if err != nil && !errors.Is(err, store.ErrNoRecord) {
+			return err
+		}
+	}
+	if err := app.sessionManager.RenewToken(ctx); err != nil {
+		return err
+	}
+	app.sessionManager.Remove(ctx, "authenticatedUserID")
+	app.sessionManager.Remove(ctx, "sessionID")
+	return nil
+}
+
+// describeUserAgent names the browser and operating system in ua well
+// enough for a user to recognise their own devices.
+func describeUserAgent(ua string) string {
+	find := func(known [][2]string) string {
+		for _, k := range known {
+			if strings.Contains(ua, k[0]) {
+				return k[1]
+			}
+		}
+		return ""
+	}
+	// Order matters: Edge and Opera claim to be Chrome, Chrome claims to
+	// be Safari, Android claims to be Linux and iOS claims to be macOS.
+	browser := find([][2]string{{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"}})
+	os := find([][2]string{{"iPhone", "iOS"}, {"iPad", "iOS"}, {"Android", "Android"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"}})
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	case ua == "":
+		return "Unknown device"
+	case len(ua) > 60:
+		return strings.ToValidUTF8(ua[:60], "") + "…"
+	default:
+		return ua
+	}
+}
+
+func (app *application) accountSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessions, err := app.store.Sessions.ListByUser(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	current := app.sessionManager.GetString(ctx, "sessionID")
+	data := app.newTemplateData(r)
+	for _, s := range sessions {
+		data.Sessions = append(data.Sessions, SessionView{Session: s, Device: describeUserAgent(s.UserAgent), Current: s.ID == current})
+	}
+	app.render(w, r, http.StatusOK, "sessions.html", data)
+}
+
+// accountSessionRevokePost logs out one session. It is logged out on its
+// next request.
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	id := r.PostForm.Get("id")
+	if id == app.sessionManager.GetString(ctx, "sessionID") {
+		app.userLogoutPost(w, r)
+		return
+	}
+	err := app.store.Sessions.Delete(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sessionManager.Put(ctx, "flash", "The session has been logged out.")
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+// accountSessionsRevokeAllPost logs out every session of the user,
+// including this one.
+func (app *application) accountSessionsRevokeAllPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, err := app.store.Sessions.DeleteAllForUser(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"), ""); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.endSession(r); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(ctx, "flash", "You have been logged out everywhere.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
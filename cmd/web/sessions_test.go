@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+var sessionIDRX = regexp.MustCompile(`name='id' value='([^']+)'`)
+
+func TestSessions(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	// Two browsers logged in to the same account.
+	laptop := newTestServer(t, app.routes())
+	defer laptop.Close()
+	phone := newTestServer(t, app.routes())
+	defer phone.Close()
+	laptop.login(t, store.MockUser.Email, store.MockUserPassword)
+	phone.login(t, store.MockUser.Email, store.MockUserPassword)
+
+	loggedIn := func(ts *testServer) bool {
+		code, _, _ := ts.get(t, "/account/")
+		return code == http.StatusOK
+	}
+	sessions := func() int {
+		s, err := app.store.Sessions.ListByUser(context.Background(), store.MockUser.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(s)
+	}
+
+	code, _, body := laptop.get(t, "/account/sessions")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "This session")
+	assert.StringContains(t, body, "Go-http-client/1.1")
+	ids := sessionIDRX.FindAllStringSubmatch(body, -1)
+	assert.Equal(t, len(ids), 1)
+
+	// Log the phone out from the laptop.
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("id", ids[0][1])
+	code, _, _ = laptop.postForm(t, "/account/sessions/revoke", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, loggedIn(phone), false)
+	assert.Equal(t, loggedIn(laptop), true)
+	code, _, _ = laptop.postForm(t, "/account/sessions/revoke", form)
+	assert.Equal(t, code, http.StatusNotFound)
+
+	// A password change logs out every other session.
+	phone.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, sessions(), 2)
+	_, _, body = laptop.get(t, "/account/password_change")
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("currentPassword", store.MockUserPassword)
+	form.Add("newPassword", "quiet-harbour-velvet-17")
+	form.Add("newPasswordConfirmation", "quiet-harbour-velvet-17")
+	code, _, _ = laptop.postForm(t, "/account/password_change", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, loggedIn(phone), false)
+	assert.Equal(t, loggedIn(laptop), true)
+	assert.Equal(t, sessions(), 1)
+
+	// Log out everywhere, including here.
+	phone.login(t, store.MockUser.Email, store.MockUserPassword)
+	_, _, body = laptop.get(t, "/account/sessions")
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, header, _ := laptop.postForm(t, "/account/sessions/revoke-all", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+	assert.Equal(t, loggedIn(phone), false)
+	assert.Equal(t, loggedIn(laptop), false)
+	assert.Equal(t, sessions(), 0)
+}
+
+func TestDescribeUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/8.8.0", "curl/8.8.0"},
+		{"", "Unknown device"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, describeUserAgent(tt.ua), tt.want)
+	}
+}
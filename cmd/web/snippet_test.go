@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
 )
 
 func TestSnippetView(t *testing.T) {
@@ -58,3 +64,87 @@ func TestSnippetView(t *testing.T) {
 		})
 	}
 }
+
+func TestSnippetCreateExpires(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	newOrg(t, ts, "365", "")
+
+	tests := []struct {
+		name     string
+		expires  string
+		org      string
+		wantCode int
+	}{
+		{"One week", "7", "0", http.StatusSeeOther},
+		{"Negative", "-1", "0", http.StatusUnprocessableEntity},
+		{"Not a choice", "30", "0", http.StatusUnprocessableEntity},
+		{"Too long", "100000", "0", http.StatusUnprocessableEntity},
+		{"Negative in organization", "-1", "1", http.StatusUnprocessableEntity},
+		{"Organization", "365", "1", http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, body := ts.get(t, "/snippet/create")
+			form := url.Values{}
+			form.Add("csrf_token", extractCSRFToken(t, body))
+			form.Add("title", "Launch plan")
+			form.Add("content", "Ship it on Friday")
+			form.Add("expires", tt.expires)
+			form.Add("org", tt.org)
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			assert.Equal(t, code, tt.wantCode)
+			if code == http.StatusUnprocessableEntity {
+				assert.StringContains(t, body, "This field must equal to 1, 7 or 365")
+			}
+		})
+	}
+}
+
+// snippetsStore is the store.Storage Snippets interface.
+type snippetsStore interface {
+	Insert(context.Context, *store.Snippet) (int, error)
+	Get(context.Context, int64) (*store.Snippet, error)
+	ListByUser(ctx context.Context, userID int) ([]store.Snippet, error)
+	Stats(context.Context) (store.SnippetStats, error)
+	DeleteExpired(context.Context) (int64, error)
+}
+
+type failingSweepSnippets struct{ snippetsStore }
+
+func (failingSweepSnippets) DeleteExpired(context.Context) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+type countingSweepSessions struct {
+	sessionsStore
+	sweeps atomic.Int64
+}
+
+func (s *countingSweepSessions) DeleteExpired(ctx context.Context, lifetime time.Duration) (int64, error) {
+	s.sweeps.Add(1)
+	return s.sessionsStore.DeleteExpired(ctx, lifetime)
+}
+
+func TestSweepExpiredContinuesAfterFailure(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	app.store.Snippets = failingSweepSnippets{app.store.Snippets}
+	sessions := &countingSweepSessions{sessionsStore: app.store.Sessions}
+	app.store.Sessions = sessions
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.sweepExpired(ctx, time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for sessions.sweeps.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	assert.Equal(t, sessions.sweeps.Load() > 0, true)
+}
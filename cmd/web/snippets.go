@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -14,6 +16,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-playground/validator/v10"
+	"github.com/theluminousartemis/snippetbin/internal/logging"
 	"github.com/theluminousartemis/snippetbin/internal/store"
 )
 
@@ -44,6 +47,12 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if dsnippet.OrgID != 0 {
+		if !app.canViewOrgSnippet(w, r, dsnippet.OrgID) {
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+	}
 
 	key, err := base64.RawURLEncoding.DecodeString(keyParam)
 	if err != nil {
@@ -53,6 +62,7 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 
 	plaintext, err := decryptAESGCM(dsnippet.Ciphertext, key, dsnippet.IV)
 	if err != nil {
+		app.metrics.DecryptionFailures.Inc()
 		app.sessionManager.Put(r.Context(), "flash", "Invalid key! Try again")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -66,6 +76,7 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		Expires: dsnippet.Expires,
 	}
 
+	app.metrics.SnippetsViewed.Inc()
 	data := app.newTemplateData(r)
 
 	data.Snippet = snippet
@@ -73,19 +84,59 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, http.StatusOK, "view.html", data)
 }
 
+// canViewOrgSnippet reports whether the signed-in user is a member of the
+// organization a snippet was created in, so that a leaked link is no use
+// outside it. Otherwise it writes the response itself: anonymous visitors
+// are sent to log in and everyone else gets the same 404 as for a snippet
+// that does not exist.
+func (app *application) canViewOrgSnippet(w http.ResponseWriter, r *http.Request, orgID int) bool {
+	ctx := r.Context()
+	if !app.isAuthenticated(r) {
+		app.sessionManager.Put(ctx, "flash", "Log in to view this snippet.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return false
+	}
+	userID := app.sessionManager.GetInt(ctx, "authenticatedUserID")
+	if _, err := app.store.Organizations.GetForMember(ctx, orgID, userID); err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			logging.FromContext(ctx).InfoContext(ctx, "organization snippet refused", slog.Int("org_id", orgID))
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return false
+	}
+	return true
+}
+
 type snippetCreateForm struct {
-	Title       string            `form:"title" validate:"required,max=100"`
-	Content     string            `form:"content" validate:"required"`
-	Expires     int               `form:"expires" validate:"required"`
+	Title   string `form:"title" validate:"required,max=100"`
+	Content string `form:"content" validate:"required"`
+	Expires int    `form:"expires" validate:"required,oneof=1 7 365"`
+	// Org is the organization to create the snippet in, or 0 for none.
+	Org         int               `form:"org"`
 	FieldErrors map[string]string `form:"-"`
 }
 
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = snippetCreateForm{
+	app.renderSnippetCreate(w, r, http.StatusOK, snippetCreateForm{
 		Expires: 365,
+	})
+}
+
+// renderSnippetCreate renders the create page with the organizations the
+// user can create the snippet in.
+func (app *application) renderSnippetCreate(w http.ResponseWriter, r *http.Request, status int, form snippetCreateForm) {
+	ctx := r.Context()
+	orgs, err := app.store.Organizations.ListByUser(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
-	app.render(w, r, http.StatusOK, "create.html", data)
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Orgs = orgs
+	app.render(w, r, status, "create.html", data)
 }
 
 func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
@@ -106,19 +157,37 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 					form.FieldErrors[field] = "This field cannot be blank"
 				case "max":
 					form.FieldErrors[field] = "This field cannot be more than 100 characters long"
-				case "expires":
+				case "oneof":
 					form.FieldErrors[field] = "This field must equal to 1, 7 or 365"
 				default:
 					form.FieldErrors[field] = "This field is invalid"
 				}
 			}
 		}
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "create.html", data)
+		app.renderSnippetCreate(w, r, http.StatusUnprocessableEntity, form)
 		return
 	}
 
+	ctx := r.Context()
+	userID := app.sessionManager.GetInt(ctx, "authenticatedUserID")
+	if form.Org != 0 {
+		org, err := app.store.Organizations.GetForMember(ctx, form.Org, userID)
+		if err != nil {
+			if errors.Is(err, store.ErrNoRecord) {
+				form.FieldErrors = map[string]string{"org": "You are not a member of this organization"}
+				app.renderSnippetCreate(w, r, http.StatusUnprocessableEntity, form)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+		if form.Expires > org.RetentionDays {
+			form.FieldErrors = map[string]string{"expires": fmt.Sprintf("%s keeps snippets for at most %d days", org.Name, org.RetentionDays)}
+			app.renderSnippetCreate(w, r, http.StatusUnprocessableEntity, form)
+			return
+		}
+	}
+
 	key, err := generateKey()
 	if err != nil {
 		app.serverError(w, r, err)
@@ -137,14 +206,16 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		Ciphertext: ciphertext,
 		IV:         nonce,
 		Expires:    time.Now().AddDate(0, 0, form.Expires),
+		UserID:     userID,
+		OrgID:      form.Org,
 	}
 
-	ctx := r.Context()
 	id, err := app.store.Snippets.Insert(ctx, snippet)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
+	app.metrics.SnippetsCreated.Inc()
 	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
 	// encodedKey := base64.StdEncoding.EncodeToString(key)
 	encodedKey := base64.RawURLEncoding.EncodeToString(key)
@@ -195,3 +266,32 @@ func decryptAESGCM(ciphertext, key, nonce []byte) ([]byte, error) {
 
 	return gcm.Open(nil, nonce, ciphertext, nil)
 }
+
+// sweepExpired deletes expired snippets, password reset tokens and
+// sessions every interval until ctx is cancelled.
+func (app *application) sweepExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Each sweep runs even if another fails.
+			if n, err := app.store.Snippets.DeleteExpired(ctx); err != nil {
+				if ctx.Err() == nil {
+					app.logger.Error("sweeping expired snippets", slog.String("error", err.Error()))
+				}
+			} else {
+				app.metrics.SnippetsExpired.Add(float64(n))
+			}
+			if _, err := app.store.PasswordResets.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+				app.logger.Error("sweeping expired password reset tokens", slog.String("error", err.Error()))
+			}
+			if _, err := app.store.Sessions.DeleteExpired(ctx, app.sessionManager.Lifetime); err != nil && ctx.Err() == nil {
+				app.logger.Error("sweeping expired sessions", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
@@ -19,6 +19,21 @@ type templateData struct {
 	IsAuthenticated bool
 	CSRFToken       string
 	User            store.User
+	// RecoveryCodes are shown once, right after they are generated.
+	RecoveryCodes []string
+	Passkeys      []store.WebAuthnCredential
+	// Reauthenticated is true while the user may add a passkey or delete
+	// their account without confirming it's them again.
+	Reauthenticated bool
+	// PasswordSignup is false when new users can only sign up through
+	// single sign-on. SSOName names the provider, if there is one.
+	PasswordSignup bool
+	SSOName        string
+	Sessions       []SessionView
+	AdminUsers     *adminUsersView
+	AdminUser      *adminUserView
+	Orgs           []store.Organization
+	Org            *orgView
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
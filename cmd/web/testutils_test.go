@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"html"
 	"io"
 	"log/slog"
@@ -11,24 +12,59 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"github.com/theluminousartemis/snippetbin/internal/loginguard"
+	"github.com/theluminousartemis/snippetbin/internal/mailer"
+	"github.com/theluminousartemis/snippetbin/internal/metrics"
+	"github.com/theluminousartemis/snippetbin/internal/passhash"
+	"github.com/theluminousartemis/snippetbin/internal/passwordpolicy"
 	"github.com/theluminousartemis/snippetbin/internal/ratelimiter"
+	"github.com/theluminousartemis/snippetbin/internal/signedtoken"
 	"github.com/theluminousartemis/snippetbin/internal/store"
 	"github.com/theluminousartemis/snippetbin/internal/store/cache"
 )
 
+// testBaseURL is the public URL of the test application, used in emailed
+// links and as the passkey origin.
+const testBaseURL = "https://snippetbin.test"
+
 func newConfig(t *testing.T) config {
 	t.Helper()
 	cfg := config{
-		rlCfg: ratelimiterConfig{
+		rateLimit: rateLimitConfig{
+			strategy:      ratelimiter.StrategyFixedWindow,
+			ipv6PrefixLen: 64,
+			fallback: ratelimiter.FallbackConfig{
+				OnError:          ratelimiter.OnErrorLocal,
+				FailureThreshold: 5,
+				Cooldown:         30 * time.Second,
+			},
+			policies: make(map[string]*ratelimiter.Config),
+		},
+	}
+	cfg.login = loginguard.Config{
+		Window:           time.Hour,
+		FreeAttempts:     3,
+		IPFreeAttempts:   20,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 5,
+		LockoutDuration:  15 * time.Minute,
+	}
+	// Hashing is cheap in tests.
+	cfg.password = passhash.Params{Memory: 64, Iterations: 1, Parallelism: 1}
+	cfg.policy = passwordpolicy.Config{MinScore: 3}
+	for _, policy := range rateLimitPolicies {
+		cfg.rateLimit.policies[policy] = &ratelimiter.Config{
 			RequestsPerTimeFrame: 20,
 			Timeframe:            time.Second,
 			Enabled:              true,
-		},
+		}
 	}
 	return cfg
 }
@@ -43,7 +79,35 @@ func newTestApplication(t *testing.T, cfg config) *application {
 	sessionManager := scs.New()
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
-	ratelimiter := ratelimiter.NewRedisFixedWindowRateLimiter(mockCache, cfg.rlCfg.RequestsPerTimeFrame, cfg.rlCfg.Timeframe)
+	rateLimiters, err := newRateLimiters(mockCache, cfg.rateLimit, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rateLimitAllowlist, err := ratelimiter.ParseAllowlist(cfg.rateLimit.allowlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedProxies, err := ratelimiter.ParseAllowlist(cfg.trustedProxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginGuard, err := loginguard.New(mockCache, cfg.login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwordPolicy, err := passwordpolicy.New(cfg.policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := signedtoken.NewSigner([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	webAuthn, err := newWebAuthn(testBaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.PasswordHashParams = cfg.password
 	storage := store.NewStorage()
 	return &application{
 		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
@@ -51,11 +115,48 @@ func newTestApplication(t *testing.T, cfg config) *application {
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
 		cache:          mockCache,
-		rateLimiter:    ratelimiter,
 		store:          storage,
+		metrics:        metrics.New(),
+		loginGuard:     loginGuard,
+		passwordPolicy: passwordPolicy,
+		mailer:         &testMailer{},
+
+		baseURL:              testBaseURL,
+		tokens:               tokens,
+		emailVerificationTTL: 48 * time.Hour,
+		passwordResetTTL:     time.Hour,
+		totpKey:              []byte("0123456789abcdef0123456789abcdef"),
+		totpIssuer:           "Snippetbin",
+		webAuthn:             webAuthn,
+		passwordSignup:       true,
+
+		rateLimiters:           rateLimiters,
+		rateLimitAllowlist:     rateLimitAllowlist,
+		trustedProxies:         trustedProxies,
+		rateLimitIPv6PrefixLen: cfg.rateLimit.ipv6PrefixLen,
+		adminToken:             testAdminToken,
 	}
 }
 
+// testMailer records the messages it is asked to send.
+type testMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *testMailer) sent() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mailer.Message(nil), m.messages...)
+}
+
 type testServer struct {
 	*httptest.Server
 }
@@ -140,3 +241,27 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// login posts the login form and returns where it redirected to.
+func (ts *testServer) login(t *testing.T, email, password string) (int, string) {
+	t.Helper()
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, header, _ := ts.postForm(t, "/user/login", form)
+	return code, header.Get("Location")
+}
+
+// reauthenticate confirms it's the logged in user with their password, as
+// adding a passkey requires, and returns the response code.
+func (ts *testServer) reauthenticate(t *testing.T, password string) int {
+	t.Helper()
+	_, _, body := ts.get(t, "/account/confirm")
+	form := url.Values{}
+	form.Add("password", password)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.postForm(t, "/account/confirm", form)
+	return code
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceRoute(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	cfg := newConfig(t)
+	app := newTestApplication(t, cfg)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/snippet/view/2")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans; expected 1", len(spans))
+	}
+	assert.Equal(t, spans[0].Name(), "GET /snippet/view/{id}")
+	assert.Equal(t, header.Get("X-Trace-Id"), spans[0].SpanContext().TraceID().String())
+
+	ts.get(t, "/ping")
+	assert.Equal(t, len(recorder.Ended()), 1)
+}
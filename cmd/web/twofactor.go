@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/mailer"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+	"github.com/theluminousartemis/snippetbin/internal/totp"
+	"rsc.io/qr"
+)
+
+const (
+	// twoFactorLoginTimeout is how long after the password step the code
+	// must be entered.
+	twoFactorLoginTimeout = 5 * time.Minute
+
+	recoveryCodeCount = 10
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// sealTOTPSecret encrypts secret for storage, prefixing the nonce.
+func (app *application) sealTOTPSecret(secret []byte) ([]byte, error) {
+	ciphertext, nonce, err := encryptAESGCM(secret, app.totpKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+func (app *application) openTOTPSecret(sealed []byte) ([]byte, error) {
+	const nonceSize = 12
+	if len(sealed) < nonceSize {
+		return nil, errors.New("totp secret too short")
+	}
+	return decryptAESGCM(sealed[nonceSize:], app.totpKey, sealed[:nonceSize])
+}
+
+// newRecoveryCodes returns codes to show the user and the hashes to store.
+func newRecoveryCodes() (codes []string, hashes [][]byte, err error) {
+	for range recoveryCodeCount {
+		// 80 bits, so the stored hashes cannot be brute forced.
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes so codes can be typed
+// however they were written down.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return store.HashToken(code)
+}
+
+// qrCodeURL returns text as a PNG QR code in a data URL.
+func qrCodeURL(text string) (template.URL, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG())), nil
+}
+
+type accountTwoFactorSetupForm struct {
+	Code        string            `form:"code" validate:"required,len=6,numeric"`
+	Secret      string            `form:"-"`
+	QRCode      template.URL      `form:"-"`
+	FieldErrors map[string]string `form:"-"`
+}
+
+type userLoginTwoFactorForm struct {
+	Code           string            `form:"code" validate:"required"`
+	Passkey        bool              `form:"-"`
+	NonFieldErrors []string          `form:"-"`
+	FieldErrors    map[string]string `form:"-"`
+}
+
+// accountTwoFactorSetup shows the secret of an enrollment in progress, or
+// offers to start one; see accountTwoFactorSetupStartPost.
+func (app *application) accountTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if app.totpKey == nil {
+		app.sessionManager.Put(ctx, "flash", "Two-factor authentication is not available.")
+		http.Redirect(w, r, "/account/", http.StatusSeeOther)
+		return
+	}
+	user, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if user.TwoFactorEnabled {
+		http.Redirect(w, r, "/account/", http.StatusSeeOther)
+		return
+	}
+
+	tf, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if errors.Is(err, store.ErrNoRecord) {
+		data := app.newTemplateData(r)
+		data.Form = accountTwoFactorSetupForm{}
+		app.render(w, r, http.StatusOK, "totp_setup.html", data)
+		return
+	} else if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	secret, err := app.openTOTPSecret(tf.Secret)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.renderTwoFactorSetup(w, r, http.StatusOK, user, secret, accountTwoFactorSetupForm{})
+}
+
+// accountTwoFactorSetupStartPost starts an enrollment with a new secret,
+// replacing any the user did not confirm.
+func (app *application) accountTwoFactorSetupStartPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if app.totpKey == nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	user, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if user.TwoFactorEnabled {
+		http.Redirect(w, r, "/account/", http.StatusSeeOther)
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	sealed, err := app.sealTOTPSecret(secret)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.store.TwoFactor.SetPending(ctx, user.ID, sealed); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/account/2fa/setup", http.StatusSeeOther)
+}
+
+func (app *application) renderTwoFactorSetup(w http.ResponseWriter, r *http.Request, status int, user *store.User, secret []byte, form accountTwoFactorSetupForm) {
+	qrCode, err := qrCodeURL(totp.URI(app.totpIssuer, user.Email, secret))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	form.Secret = totp.EncodeSecret(secret)
+	form.QRCode = qrCode
+	data := app.newTemplateData(r)
+	data.Form = form
+	app.render(w, r, status, "totp_setup.html", data)
+}
+
+func (app *application) accountTwoFactorSetupPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if app.totpKey == nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+	var form accountTwoFactorSetupForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	tf, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if errors.Is(err, store.ErrNoRecord) || (err == nil && tf.Enabled()) {
+		http.Redirect(w, r, "/account/2fa/setup", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	secret, err := app.openTOTPSecret(tf.Secret)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	step, ok := int64(0), false
+	if err := validate.Struct(form); err == nil {
+		step, ok = totp.Verify(secret, form.Code, time.Now(), 0)
+	}
+	if !ok {
+		form.FieldErrors = map[string]string{"code": "That code is not right. Check the time on your device and try again."}
+		app.renderTwoFactorSetup(w, r, http.StatusUnprocessableEntity, user, secret, form)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.store.TwoFactor.Enable(ctx, user.ID, step, hashes); err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			http.Redirect(w, r, "/account/", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// The codes are only ever shown here, so this is rendered rather than
+	// redirected.
+	data := app.newTemplateData(r)
+	data.Flash = "Two-factor authentication is now on."
+	data.RecoveryCodes = codes
+	app.render(w, r, http.StatusOK, "recovery_codes.html", data)
+}
+
+func (app *application) accountTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, http.StatusOK, "totp_disable.html", app.newTemplateData(r))
+}
+
+// accountTwoFactorDisablePost turns two-factor authentication off. Users
+// confirm it's them beforehand; see requireReauthentication.
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, err := app.store.Users.GetByID(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.store.TwoFactor.Disable(ctx, user.ID); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sendEmail(user.ID, mailer.Message{
+		To:      user.Email,
+		Subject: "Two-factor authentication was turned off",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Two-factor authentication was just turned off for your account, so only your password is needed to log in.\n\n"+
+			"If this was not you, reset your password at %s and turn it back on.\n",
+			user.Username, app.baseURL+"/user/password/forgot"),
+	})
+	app.sessionManager.Put(ctx, "flash", "Two-factor authentication is now off.")
+	http.Redirect(w, r, "/account/", http.StatusSeeOther)
+}
+
+// pendingTwoFactorUser returns the ID of the user who passed the password
+// step in this session, or 0 if there is none or it has expired.
+func (app *application) pendingTwoFactorUser(ctx context.Context) int {
+	id := app.sessionManager.GetInt(ctx, "twoFactorUserID")
+	started := time.Unix(app.sessionManager.GetInt64(ctx, "twoFactorStartedAt"), 0)
+	if id == 0 || time.Since(started) > twoFactorLoginTimeout {
+		return 0
+	}
+	return id
+}
+
+func (app *application) twoFactorLoginExpired(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if app.sessionManager.Exists(ctx, "twoFactorUserID") {
+		app.sessionManager.Remove(ctx, "twoFactorUserID")
+		app.sessionManager.Remove(ctx, "twoFactorStartedAt")
+		app.sessionManager.Put(ctx, "flash", "Your login has expired. Please log in again.")
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingTwoFactorUser(r.Context())
+	if id == 0 {
+		app.twoFactorLoginExpired(w, r)
+		return
+	}
+	creds, err := app.store.WebAuthn.ListByUser(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Form = userLoginTwoFactorForm{Passkey: len(creds) > 0}
+	app.render(w, r, http.StatusOK, "totp.html", data)
+}
+
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := app.pendingTwoFactorUser(ctx)
+	if id == 0 {
+		app.twoFactorLoginExpired(w, r)
+		return
+	}
+	var form userLoginTwoFactorForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	creds, err := app.store.WebAuthn.ListByUser(ctx, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	form.Passkey = len(creds) > 0
+	rerender := func(status int, msg string) {
+		form.NonFieldErrors = []string{msg}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, status, "totp.html", data)
+	}
+	if err := validate.Struct(form); err != nil {
+		rerender(http.StatusUnprocessableEntity, "Enter the code from your authenticator app or a recovery code")
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	ip := app.clientIP(r)
+	if wait := app.loginWait(ctx, user.Email, ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(wait), 1)))
+		rerender(http.StatusTooManyRequests, "Too many failed login attempts. Please try again later.")
+		return
+	}
+
+	usedRecoveryCode, ok, err := app.checkSecondFactor(ctx, user.ID, form.Code)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !ok {
+		app.recordLoginFailure(ctx, user.Email, ip, user)
+		rerender(http.StatusUnprocessableEntity, "That code is not right or has already been used")
+		return
+	}
+	if usedRecoveryCode {
+		app.sessionManager.Put(ctx, "flash", "You logged in with a recovery code, which can't be used again.")
+	}
+	app.completeLogin(w, r, user)
+}
+
+// checkSecondFactor accepts either a TOTP code or one of the user's
+// recovery codes. Each is accepted only once.
+func (app *application) checkSecondFactor(ctx context.Context, userID int, code string) (usedRecoveryCode, ok bool, err error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if _, err := strconv.Atoi(code); err == nil && len(code) == totp.Digits {
+		tf, err := app.store.TwoFactor.Get(ctx, userID)
+		if err != nil {
+			if errors.Is(err, store.ErrNoRecord) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+		secret, err := app.openTOTPSecret(tf.Secret)
+		if err != nil {
+			return false, false, err
+		}
+		step, ok := totp.Verify(secret, code, time.Now(), tf.LastStep)
+		if !ok {
+			return false, false, nil
+		}
+		if err := app.store.TwoFactor.UseStep(ctx, userID, step); err != nil {
+			if errors.Is(err, store.ErrNoRecord) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+		return false, true, nil
+	}
+
+	if err := app.store.TwoFactor.UseRecoveryCode(ctx, userID, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	return true, true, nil
+}
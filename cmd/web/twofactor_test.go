@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/base32"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+	"github.com/theluminousartemis/snippetbin/internal/totp"
+)
+
+var (
+	totpSecretRX   = regexp.MustCompile(`Key: <code>([A-Z2-7]+)</code>`)
+	recoveryCodeRX = regexp.MustCompile(`<li><code>([a-z2-7-]+)</code></li>`)
+)
+
+func TestTwoFactor(t *testing.T) {
+	cfg := newConfig(t)
+	// The whole lifecycle takes more requests than the default limit allows.
+	cfg.rateLimit.policies[policyDefault].RequestsPerTimeFrame = 100
+	app := newTestApplication(t, cfg)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _ := ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Enroll. Looking at the page stores nothing.
+	code, _, body := ts.get(t, "/account/2fa/setup")
+	assert.Equal(t, code, http.StatusOK)
+	_, err := app.store.TwoFactor.Get(context.Background(), store.MockUser.ID)
+	assert.Equal(t, err, store.ErrNoRecord)
+	code, header, _ := ts.postForm(t, "/account/2fa/setup/start", url.Values{"csrf_token": {extractCSRFToken(t, body)}})
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/2fa/setup")
+	code, _, body = ts.get(t, "/account/2fa/setup")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `<img src='data:image/png;base64,`)
+	matches := totpSecretRX.FindStringSubmatch(body)
+	if matches == nil {
+		t.Fatal("no TOTP secret in setup page")
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(matches[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	setup := func(code string) (int, string) {
+		form := url.Values{}
+		form.Add("code", code)
+		form.Add("csrf_token", csrfToken)
+		status, _, body := ts.postForm(t, "/account/2fa/setup", form)
+		return status, body
+	}
+	status, _ := setup("000000")
+	if totp.Code(secret, time.Now()) != "000000" {
+		assert.Equal(t, status, http.StatusUnprocessableEntity)
+	}
+	status, body = setup(totp.Code(secret, time.Now()))
+	assert.Equal(t, status, http.StatusOK)
+	recoveryCodes := recoveryCodeRX.FindAllStringSubmatch(body, -1)
+	assert.Equal(t, len(recoveryCodes), recoveryCodeCount)
+
+	// The stored secret is encrypted.
+	tf, err := app.store.TwoFactor.Get(context.Background(), store.MockUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, strings.Contains(string(tf.Secret), string(secret)), false)
+
+	_, _, body = ts.get(t, "/account/")
+	assert.StringContains(t, body, `On (<a href="/account/2fa/disable">`)
+
+	// The password alone no longer logs in.
+	_, _, body = ts.get(t, "/")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	ts.postForm(t, "/user/logout", form)
+	code, location := ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, location, "/user/login/2fa")
+	code, header, _ = ts.get(t, "/account/")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	_, _, body = ts.get(t, "/user/login/2fa")
+	csrfToken = extractCSRFToken(t, body)
+	secondStep := func(code string) (int, string) {
+		form := url.Values{}
+		form.Add("code", code)
+		form.Add("csrf_token", csrfToken)
+		status, header, _ := ts.postForm(t, "/user/login/2fa", form)
+		return status, header.Get("Location")
+	}
+
+	status, _ = secondStep("not-a-code")
+	assert.Equal(t, status, http.StatusUnprocessableEntity)
+
+	// The code used to enroll was for this period, so use the next one.
+	next := totp.Code(secret, time.Now().Add(totp.Period))
+	status, location = secondStep(next)
+	assert.Equal(t, status, http.StatusSeeOther)
+	assert.Equal(t, location, "/snippet/create")
+	code, _, _ = ts.get(t, "/account/")
+	assert.Equal(t, code, http.StatusOK)
+
+	// Codes and recovery codes are single use.
+	ts.postForm(t, "/user/logout", url.Values{"csrf_token": {csrfToken}})
+	ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	_, _, body = ts.get(t, "/user/login/2fa")
+	csrfToken = extractCSRFToken(t, body)
+	status, _ = secondStep(next)
+	assert.Equal(t, status, http.StatusUnprocessableEntity)
+	status, _ = secondStep(strings.ToUpper(recoveryCodes[0][1]))
+	assert.Equal(t, status, http.StatusSeeOther)
+
+	ts.postForm(t, "/user/logout", url.Values{"csrf_token": {csrfToken}})
+	ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	_, _, body = ts.get(t, "/user/login/2fa")
+	csrfToken = extractCSRFToken(t, body)
+	status, _ = secondStep(recoveryCodes[0][1])
+	assert.Equal(t, status, http.StatusUnprocessableEntity)
+	status, _ = secondStep(recoveryCodes[1][1])
+	assert.Equal(t, status, http.StatusSeeOther)
+
+	// Turning it off needs the user to confirm it's them first.
+	status, header, _ = ts.postForm(t, "/account/2fa/disable", url.Values{"csrf_token": {csrfToken}})
+	assert.Equal(t, status, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/confirm?next=%2Faccount%2F2fa%2Fdisable")
+	_, _, body = ts.get(t, "/account/")
+	assert.StringContains(t, body, `On (<a href="/account/2fa/disable">`)
+
+	// A code confirms it's them too, as does it for adding a passkey.
+	form = url.Values{}
+	form.Add("code", recoveryCodes[2][1])
+	form.Add("next", "/account/")
+	form.Add("csrf_token", csrfToken)
+	status, header, _ = ts.postForm(t, "/account/confirm", form)
+	assert.Equal(t, status, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/")
+	_, _, body = ts.get(t, "/account/")
+	assert.StringContains(t, body, "data-passkey='register'")
+
+	status, header, _ = ts.postForm(t, "/account/2fa/disable", url.Values{"csrf_token": {csrfToken}})
+	assert.Equal(t, status, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/")
+
+	ts.postForm(t, "/user/logout", url.Values{"csrf_token": {csrfToken}})
+	code, location = ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, location, "/snippet/create")
+}
+
+func TestTwoFactorLoginNeedsPassword(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/user/login/2fa")
+	assert.Equal(t, code, http.StatusSeeOther)
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/theluminousartemis/snippetbin/internal/logging"
+	"github.com/theluminousartemis/snippetbin/internal/mailer"
 	"github.com/theluminousartemis/snippetbin/internal/store"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // users
@@ -27,6 +33,10 @@ func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	if !app.passwordSignup {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
 	var form userSignupForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
@@ -55,6 +65,19 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	msg, err := app.passwordPolicy.Check(form.Password, form.Username, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if msg != "" {
+		form.FieldErrors = map[string]string{"password": msg}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+		return
+	}
+
 	user := &store.User{
 		Username: form.Username,
 		Email:    form.Email,
@@ -86,7 +109,8 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "Sign up successful. Please login")
+	app.sendVerificationEmail(user)
+	app.sessionManager.Put(r.Context(), "flash", "Sign up successful. We've emailed you a link to verify your address. Please login")
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 
 }
@@ -134,48 +158,199 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	ctx := r.Context()
+	ip := app.clientIP(r)
+
+	if wait := app.loginWait(ctx, form.Email, ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(wait), 1)))
+		form.NonFieldErrors = []string{"Too many failed login attempts. Please try again later."}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login.html", data)
+		return
+	}
+
+	loginFailed := func(user *store.User) {
+		app.recordLoginFailure(ctx, form.Email, ip, user)
+		form.NonFieldErrors = []string{"Email or password is incorrect"}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+	}
+
 	user, err := app.store.Users.GetByEmail(ctx, form.Email)
 	if err != nil {
-		if errors.Is(err, store.ErrInvalidCredentials) {
-			form.NonFieldErrors = []string{"Email or password is incorrect"}
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		if !errors.Is(err, store.ErrInvalidCredentials) {
+			app.serverError(w, r, err)
 			return
-		} else {
+		}
+		if err := store.CompareDummyPassword(form.Password); err != nil {
 			app.serverError(w, r, err)
+			return
 		}
+		loginFailed(nil)
 		return
 	}
 
 	if err := user.Password.Compare(form.Password); err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			form.NonFieldErrors = []string{"Email or password is incorrect"}
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		if errors.Is(err, store.ErrInvalidCredentials) {
+			loginFailed(user)
 		} else {
 			app.serverError(w, r, err)
 		}
 		return
 	}
+	if user.PasswordResetRequired {
+		form.NonFieldErrors = []string{"You need to choose a new password before you can log in with this one. " +
+			"Use the link we emailed you, or ask for a new one with Forgot password."}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusForbidden, "login.html", data)
+		return
+	}
+	app.upgradePassword(ctx, user, form.Password)
+	app.continueLogin(w, r, user)
+}
 
-	err = app.sessionManager.RenewToken(r.Context())
+// upgradePassword rehashes a correct password whose stored hash is
+// outdated. The login goes ahead even if this fails; it is tried again
+// next time.
+func (app *application) upgradePassword(ctx context.Context, user *store.User, plaintext string) {
+	logger := logging.FromContext(ctx)
+	upgraded, err := app.store.Users.UpgradePassword(ctx, user, plaintext)
 	if err != nil {
-		app.serverError(w, r, err)
+		logger.ErrorContext(ctx, "could not upgrade password hash", slog.Int("user_id", user.ID), slog.String("error", err.Error()))
+		return
+	}
+	if upgraded {
+		logger.InfoContext(ctx, "password hash upgraded", slog.Int("user_id", user.ID))
+	}
+}
+
+// continueLogin moves on from a successful first step, a password or
+// single sign-on, to the TOTP step if the user has turned it on, or logs
+// them in.
+func (app *application) continueLogin(w http.ResponseWriter, r *http.Request, user *store.User) {
+	ctx := r.Context()
+	if user.Disabled() {
+		app.accountDisabled(w, r)
+		return
+	}
+	if user.TwoFactorEnabled {
+		// The login is not complete until the second step, so failures
+		// are not cleared yet.
+		if err := app.sessionManager.RenewToken(ctx); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(ctx, "twoFactorUserID", user.ID)
+		app.sessionManager.Put(ctx, "twoFactorStartedAt", time.Now().Unix())
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+	app.completeLogin(w, r, user)
+}
+
+// loginWait returns how long logins for email from ip are blocked. A cache
+// outage must not lock everyone out, so the guard fails open.
+func (app *application) loginWait(ctx context.Context, email, ip string) time.Duration {
+	logger := logging.FromContext(ctx)
+	wait, err := app.loginGuard.Check(ctx, email, ip)
+	if err != nil {
+		logger.ErrorContext(ctx, "login guard unavailable", slog.String("error", err.Error()))
+	}
+	if wait > 0 {
+		app.metrics.Logins.WithLabelValues("blocked").Inc()
+		logger.InfoContext(ctx, "login attempt blocked", slog.String("ip", ip), slog.Duration("wait", wait))
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed login for email and ip, and tells the
+// owner of user if it locked their account.
+func (app *application) recordLoginFailure(ctx context.Context, email, ip string, user *store.User) {
+	logger := logging.FromContext(ctx)
+	app.metrics.Logins.WithLabelValues("failure").Inc()
+	locked, err := app.loginGuard.Failure(ctx, email, ip)
+	if err != nil {
+		logger.ErrorContext(ctx, "login guard unavailable", slog.String("error", err.Error()))
+	}
+	if locked {
+		app.metrics.AccountLockouts.Inc()
+		logger.WarnContext(ctx, "account locked after failed logins", slog.String("ip", ip))
+		if user != nil {
+			app.notifyAccountLocked(user)
+		}
+	}
+}
+
+// completeLogin logs user in once every factor has been checked.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *store.User) {
+	if err := app.logIn(r, user); err != nil {
+		if errors.Is(err, errAccountDisabled) {
+			app.accountDisabled(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", user.ID)
 	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
 
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	err := app.sessionManager.RenewToken(r.Context())
+// errAccountDisabled is returned by logIn for a user a moderator has
+// disabled.
+var errAccountDisabled = errors.New("account disabled")
+
+func (app *application) accountDisabled(w http.ResponseWriter, r *http.Request) {
+	app.sessionManager.Put(r.Context(), "flash", "This account has been disabled.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// logIn starts an authenticated session for user and records it in the
+// user's list of sessions. Every way of logging in ends here, so this is
+// where disabled accounts are turned away.
+func (app *application) logIn(r *http.Request, user *store.User) error {
+	ctx := r.Context()
+	if user.Disabled() {
+		return errAccountDisabled
+	}
+	if err := app.loginGuard.Success(ctx, user.Email); err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "login guard unavailable", slog.String("error", err.Error()))
+	}
+	if err := app.sessionManager.RenewToken(ctx); err != nil {
+		return err
+	}
+	id, err := app.startSession(r, user.ID)
 	if err != nil {
+		return err
+	}
+	app.metrics.Logins.WithLabelValues("success").Inc()
+	app.sessionManager.Remove(ctx, "twoFactorUserID")
+	app.sessionManager.Remove(ctx, "twoFactorStartedAt")
+	app.sessionManager.Remove(ctx, "reauthenticatedAt")
+	app.sessionManager.Put(ctx, "authenticatedUserID", user.ID)
+	app.sessionManager.Put(ctx, "sessionID", id)
+	return nil
+}
+
+// notifyAccountLocked tells the owner of user that their account was locked.
+func (app *application) notifyAccountLocked(user *store.User) {
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Your Snippetbin account has been locked",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"There were too many failed attempts to log in to your account, so it has been locked for %s.\n\n"+
+			"If this was not you, someone may be trying to guess your password. You can choose a new one, "+
+			"which also unlocks your account, at:\n\n%s\n",
+			user.Username, app.loginGuard.LockoutDuration(), app.baseURL+"/user/password/forgot"),
+	}
+	app.sendEmail(user.ID, msg)
+}
+
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	if err := app.endSession(r); err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
 	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -193,8 +368,15 @@ func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	passkeys, err := app.store.WebAuthn.ListByUser(ctx, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 	data := app.newTemplateData(r)
 	data.User = *user
+	data.Passkeys = passkeys
+	data.Reauthenticated = app.reauthenticated(ctx)
 	app.render(w, r, http.StatusOK, "profile.html", data)
 }
 
@@ -242,9 +424,26 @@ func (app *application) userPasswordUpdatePost(w http.ResponseWriter, r *http.Re
 		app.render(w, r, http.StatusUnprocessableEntity, "password.html", data)
 		return
 	}
-	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
 	ctx := r.Context()
-	err = app.store.Users.PasswordUpdate(ctx, id, form.CurrentPassword, form.NewPassword)
+	id := app.sessionManager.GetInt(ctx, "authenticatedUserID")
+	user, err := app.store.Users.GetByID(ctx, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	msg, err := app.passwordPolicy.Check(form.NewPassword, user.Username, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if msg != "" {
+		form.FieldErrors = map[string]string{"newpassword": msg}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "password.html", data)
+		return
+	}
+	err = app.store.Users.PasswordUpdate(ctx, id, form.CurrentPassword, form.NewPassword, app.sessionManager.GetString(ctx, "sessionID"))
 	if err != nil {
 		switch err {
 		case store.ErrInvalidCredentials:
@@ -259,6 +458,6 @@ func (app *application) userPasswordUpdatePost(w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "Password updated successfully")
+	app.sessionManager.Put(ctx, "flash", "Password updated successfully. You have been logged out of your other sessions.")
 	http.Redirect(w, r, "/account/", http.StatusSeeOther)
 }
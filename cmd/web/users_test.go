@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
 )
 
 func TestUserSignup(t *testing.T) {
@@ -19,7 +27,7 @@ func TestUserSignup(t *testing.T) {
 
 	const (
 		validName     = "Bob"
-		validPassword = "validPa$$word"
+		validPassword = "fizzy-otter-lantern-42"
 		validEmail    = "bob@example.com"
 		formTag       = `<form action="/user/signup" method="POST" novalidate>`
 	)
@@ -123,3 +131,208 @@ func TestUserSignup(t *testing.T) {
 		})
 	}
 }
+
+func TestUserLoginLockout(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.login.FreeAttempts = 2
+	cfg.login.LockoutThreshold = 3
+	app := newTestApplication(t, cfg)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	admin := newTestServer(t, app.adminRoutes())
+	defer admin.Close()
+	web := newTestServer(t, app.routes())
+	defer web.Close()
+	web.login(t, store.MockAdminUser.Email, store.MockUserPassword)
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+	login := func(password string) (int, http.Header, string) {
+		form := url.Values{}
+		form.Add("email", store.MockUser.Email)
+		form.Add("password", password)
+		form.Add("csrf_token", csrfToken)
+		return ts.postForm(t, "/user/login", form)
+	}
+
+	for range 3 {
+		code, _, body := login("wrongpassword")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.StringContains(t, body, "Email or password is incorrect")
+	}
+
+	// The account is locked: even the right password is not checked.
+	code, header, body := login(store.MockUserPassword)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "900")
+	assert.StringContains(t, body, "Too many failed login attempts")
+
+	app.wg.Wait()
+	sent := app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, store.MockUser.Email)
+	assert.StringContains(t, sent[0].Subject, "locked")
+
+	code, _, body = admin.get(t, "/lockouts?email="+url.QueryEscape(store.MockUser.Email))
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"failures":3`)
+	assert.StringContains(t, body, `"blocked_for_seconds":900`)
+
+	// The admin listener only reports lockouts; unlocking is for admins.
+	req, err := http.NewRequest(http.MethodDelete, admin.URL+"/lockouts?email="+url.QueryEscape(store.MockUser.Email), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := admin.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusMethodNotAllowed)
+
+	_, _, body = web.get(t, "/admin/users/1")
+	assert.StringContains(t, body, "locked until")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = web.postForm(t, "/admin/users/1/unlock", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, header, _ = login(store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/create")
+
+	events, err := app.store.Audit.ListByUser(context.Background(), store.MockUser.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, events[0].Action, store.AuditUnlocked)
+	assert.Equal(t, events[0].ActorID, store.MockAdminUser.ID)
+
+	code, _, _ = admin.get(t, "/lockouts")
+	assert.Equal(t, code, http.StatusBadRequest)
+}
+
+func TestUserLoginIPThrottleIgnoresForwardedHeaders(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.login.IPFreeAttempts = 2
+	app := newTestApplication(t, cfg)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+	// Each attempt tries a different account from a different claimed
+	// address, as in password spraying. The test client is not a trusted
+	// proxy, so every attempt counts against its real address.
+	login := func(i int) int {
+		form := url.Values{}
+		form.Add("email", fmt.Sprintf("user%d@example.com", i))
+		form.Add("password", "wrongpassword")
+		form.Add("csrf_token", csrfToken)
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/user/login", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("192.0.2.%d", i))
+		req.Header.Set("X-Real-IP", fmt.Sprintf("192.0.2.%d", i))
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+
+	for i := range 3 {
+		assert.Equal(t, login(i), http.StatusUnprocessableEntity)
+	}
+	assert.Equal(t, login(3), http.StatusTooManyRequests)
+}
+
+func TestUserLoginUpgradesPasswordHash(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ctx := context.Background()
+
+	// MockUser's password is hashed with bcrypt.
+	user, err := app.store.Users.GetByEmail(ctx, store.MockUser.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Password.NeedsRehash(), true)
+
+	code, location := ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, location, "/snippet/create")
+	user, err = app.store.Users.GetByEmail(ctx, store.MockUser.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Password.NeedsRehash(), false)
+	assert.Equal(t, user.Password.Compare(store.MockUserPassword), nil)
+	assert.Equal(t, user.Password.Compare("wrongpassword"), store.ErrInvalidCredentials)
+
+	// The new hash works for the next login.
+	code, _ = ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	cfg := newConfig(t)
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	sum := sha1.Sum([]byte("amber-canyon-whistle-93"))
+	if err := os.WriteFile(breached, []byte(hex.EncodeToString(sum[:])+":42\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.policy.BreachedList = breached
+	app := newTestApplication(t, cfg)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	csrfToken := extractCSRFToken(t, body)
+	signup := func(username, password string) (int, string) {
+		form := url.Values{}
+		form.Add("username", username)
+		form.Add("email", "bob@example.com")
+		form.Add("password", password)
+		form.Add("csrf_token", csrfToken)
+		code, _, body := ts.postForm(t, "/user/signup", form)
+		return code, body
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantCode int
+		wantBody string
+	}{
+		{"Common password", "Bob", "password123", http.StatusUnprocessableEntity, "This is a very common password."},
+		{"Keyboard walk", "Bob", "qwertyuiop[]", http.StatusUnprocessableEntity, "keys are easy to guess"},
+		{"Username", "roadrunnerfan", "roadrunnerfan1", http.StatusUnprocessableEntity, "Avoid using your username or email address"},
+		{"Breached", "Bob", "amber-canyon-whistle-93", http.StatusUnprocessableEntity, "appeared in a data breach"},
+		{"Strong", "Bob", "fizzy-otter-lantern-42", http.StatusSeeOther, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := signup(tt.username, tt.password)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+
+	// Changing the password is held to the same policy.
+	ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	_, _, body = ts.get(t, "/account/password_change")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("currentPassword", store.MockUserPassword)
+	form.Add("newPassword", "validusername2024")
+	form.Add("newPasswordConfirmation", "validusername2024")
+	code, _, body := ts.postForm(t, "/account/password_change", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "This password is too easy to guess.")
+}
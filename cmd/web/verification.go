@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/mailer"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+const tokenPurposeVerifyEmail = "verify-email"
+
+// sendVerificationEmail mails user a link that proves they own their email
+// address. The token is tied to the address, so it stops working if the
+// address changes.
+func (app *application) sendVerificationEmail(user *store.User) {
+	token := app.tokens.Sign(tokenPurposeVerifyEmail, app.emailVerificationTTL, strconv.Itoa(user.ID), user.Email)
+	link := app.baseURL + "/user/verify?token=" + url.QueryEscape(token)
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Snippetbin email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please confirm this is your email address by opening the link below. It expires in %s.\n\n"+
+			"%s\n\n"+
+			"If you did not sign up for Snippetbin, you can ignore this email.\n",
+			user.Username, app.emailVerificationTTL, link),
+	}
+	app.sendEmail(user.ID, msg)
+}
+
+// sendEmail sends msg in the background so the response does not wait for
+// the mail server.
+func (app *application) sendEmail(userID int, msg mailer.Message) {
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := app.mailer.Send(ctx, msg); err != nil {
+			app.logger.Error("sending email", slog.Int("user_id", userID), slog.String("subject", msg.Subject), slog.String("error", err.Error()))
+		}
+	})
+}
+
+func (app *application) userVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	invalid := func() {
+		data := app.newTemplateData(r)
+		data.Flash = "This verification link is invalid or has expired."
+		app.render(w, r, http.StatusBadRequest, "verify.html", data)
+	}
+
+	fields, err := app.tokens.Verify(tokenPurposeVerifyEmail, r.URL.Query().Get("token"))
+	if err != nil || len(fields) != 2 {
+		invalid()
+		return
+	}
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		invalid()
+		return
+	}
+	if err := app.store.Users.MarkEmailVerified(r.Context(), id, fields[1]); err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			invalid()
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified.")
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/account/", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// accountVerify explains why verification is needed and offers to resend
+// the link.
+func (app *application) accountVerify(w http.ResponseWriter, r *http.Request) {
+	user, err := app.store.Users.GetByID(r.Context(), app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if user.EmailVerified() {
+		http.Redirect(w, r, "/account/", http.StatusSeeOther)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.User = *user
+	app.render(w, r, http.StatusOK, "verify.html", data)
+}
+
+func (app *application) accountVerifyPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.store.Users.GetByID(r.Context(), app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if user.EmailVerified() {
+		http.Redirect(w, r, "/account/", http.StatusSeeOther)
+		return
+	}
+	app.sendVerificationEmail(user)
+	app.sessionManager.Put(r.Context(), "flash", "We've sent a new verification link to "+user.Email+".")
+	http.Redirect(w, r, "/account/verify", http.StatusSeeOther)
+}
+
+// requireVerifiedEmail keeps accounts with an unconfirmed email address
+// from creating snippets, so throwaway signups cannot get around the
+// account requirement. It must run after requireAuthentication.
+func (app *application) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.store.Users.GetByID(r.Context(), app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if !user.EmailVerified() {
+			app.sessionManager.Put(r.Context(), "flash", "Please verify your email address before creating snippets.")
+			http.Redirect(w, r, "/account/verify", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+func TestSignupSendsVerificationEmail(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	form := url.Values{}
+	form.Add("username", "Bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", "fizzy-otter-lantern-42")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	app.wg.Wait()
+	sent := app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, "bob@example.com")
+	assert.StringContains(t, sent[0].Body, "https://snippetbin.test/user/verify?token=")
+}
+
+func TestUserVerifyEmail(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	user := store.MockUnverifiedUser
+	id := strconv.Itoa(user.ID)
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{
+			name:     "Valid",
+			token:    app.tokens.Sign(tokenPurposeVerifyEmail, time.Hour, id, user.Email),
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Expired",
+			token:    app.tokens.Sign(tokenPurposeVerifyEmail, -time.Second, id, user.Email),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Wrong purpose",
+			token:    app.tokens.Sign("password-reset", time.Hour, id, user.Email),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Email changed",
+			token:    app.tokens.Sign(tokenPurposeVerifyEmail, time.Hour, id, "old@example.com"),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Tampered",
+			token:    app.tokens.Sign(tokenPurposeVerifyEmail, time.Hour, id, user.Email) + "x",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Missing",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, "/user/verify?token="+url.QueryEscape(tt.token))
+			assert.Equal(t, code, tt.wantCode)
+			if code == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/user/login")
+			} else {
+				assert.StringContains(t, body, "invalid or has expired")
+			}
+		})
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	app := newTestApplication(t, newConfig(t))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+	form := url.Values{}
+	form.Add("email", store.MockUnverifiedUser.Email)
+	form.Add("password", store.MockUserPassword)
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, header, _ := ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/verify")
+
+	code, _, body = ts.get(t, "/account/verify")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Please verify your email address before creating snippets.")
+	assert.StringContains(t, body, store.MockUnverifiedUser.Email)
+
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, header, _ = ts.postForm(t, "/account/verify", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/verify")
+
+	app.wg.Wait()
+	sent := app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, store.MockUnverifiedUser.Email)
+
+	// The emailed link verifies the address.
+	link := sent[0].Body[strings.Index(sent[0].Body, "https://"):]
+	link = strings.TrimPrefix(strings.Fields(link)[0], "https://snippetbin.test")
+	code, header, _ = ts.get(t, link)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/")
+}
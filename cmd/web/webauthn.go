@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/theluminousartemis/snippetbin/internal/logging"
+	"github.com/theluminousartemis/snippetbin/internal/mailer"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+// webAuthnTimeout is how long the browser, and then the server, waits for
+// the user to respond to a passkey prompt.
+const webAuthnTimeout = 5 * time.Minute
+
+// newWebAuthn configures the relying party from the public URL of the
+// site, which passkeys are bound to.
+func newWebAuthn(baseURL string) (*webauthn.WebAuthn, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnTimeout, TimeoutUVD: webAuthnTimeout}
+	return webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: "Snippetbin",
+		RPOrigins:     []string{u.Scheme + "://" + u.Host},
+		Timeouts:      webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// webAuthnUser adapts a user and their registered credentials to
+// webauthn.User.
+type webAuthnUser struct {
+	user  *store.User
+	creds []store.WebAuthnCredential
+}
+
+func (u webAuthnUser) WebAuthnID() []byte          { return userHandle(u.user.ID) }
+func (u webAuthnUser) WebAuthnName() string        { return u.user.Email }
+func (u webAuthnUser) WebAuthnDisplayName() string { return u.user.Username }
+
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, 0, len(u.creds))
+	for _, c := range u.creds {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		creds = append(creds, webauthn.Credential{
+			ID:              c.ID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags:           webauthn.CredentialFlags{BackupEligible: c.BackupEligible, BackupState: c.BackupState},
+			Authenticator:   webauthn.Authenticator{AAGUID: c.AAGUID, SignCount: c.SignCount},
+		})
+	}
+	return creds
+}
+
+func (u webAuthnUser) descriptors() []protocol.CredentialDescriptor {
+	var descriptors []protocol.CredentialDescriptor
+	for _, c := range u.WebAuthnCredentials() {
+		descriptors = append(descriptors, c.Descriptor())
+	}
+	return descriptors
+}
+
+// userHandle is the opaque ID passkeys store for the account. It is the
+// user ID rather than anything personal, such as the email address.
+func userHandle(id int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+func userIDFromHandle(handle []byte) (int, bool) {
+	if len(handle) != 8 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint64(handle)), true
+}
+
+func (app *application) loadWebAuthnUser(ctx context.Context, id int) (webAuthnUser, error) {
+	user, err := app.store.Users.GetByID(ctx, id)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	creds, err := app.store.WebAuthn.ListByUser(ctx, id)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	return webAuthnUser{user: user, creds: creds}, nil
+}
+
+// putWebAuthnSession keeps the challenge for a ceremony in the session
+// until the browser answers it.
+func (app *application) putWebAuthnSession(ctx context.Context, key string, data *webauthn.SessionData) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	app.sessionManager.Put(ctx, key, string(b))
+	return nil
+}
+
+// popWebAuthnSession returns the ceremony stored under key and removes it,
+// so each challenge is answered at most once.
+func (app *application) popWebAuthnSession(ctx context.Context, key string) (webauthn.SessionData, bool) {
+	var data webauthn.SessionData
+	s := app.sessionManager.PopString(ctx, key)
+	if s == "" || json.Unmarshal([]byte(s), &data) != nil {
+		return data, false
+	}
+	return data, true
+}
+
+func (app *application) webAuthnError(w http.ResponseWriter, status int, msg string) {
+	app.writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// reauthRequired is shown when a passkey is added without the user
+// having confirmed it's them recently; see accountConfirm.
+const reauthRequired = "Please confirm it's you before adding a passkey."
+
+func (app *application) accountPasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !app.reauthenticated(ctx) {
+		app.webAuthnError(w, http.StatusForbidden, reauthRequired)
+		return
+	}
+	u, err := app.loadWebAuthnUser(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// Passkeys must be discoverable so they can log in without an email
+	// address being typed first.
+	creation, session, err := app.webAuthn.BeginRegistration(u,
+		webauthn.WithExclusions(u.descriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.putWebAuthnSession(ctx, "webAuthnRegistration", session); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, creation)
+}
+
+func (app *application) accountPasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !app.reauthenticated(ctx) {
+		app.webAuthnError(w, http.StatusForbidden, reauthRequired)
+		return
+	}
+	session, ok := app.popWebAuthnSession(ctx, "webAuthnRegistration")
+	if !ok {
+		app.webAuthnError(w, http.StatusBadRequest, "No passkey registration is in progress. Please try again.")
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	if utf8.RuneCountInString(name) > 100 {
+		app.webAuthnError(w, http.StatusUnprocessableEntity, "The name must be at most 100 characters long.")
+		return
+	}
+
+	u, err := app.loadWebAuthnUser(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	parsed, err := protocol.ParseCredentialCreationResponseBody(r.Body)
+	if err != nil {
+		app.webAuthnError(w, http.StatusBadRequest, "The passkey response could not be read.")
+		return
+	}
+	cred, err := app.webAuthn.CreateCredential(u, session, parsed)
+	if err != nil {
+		logging.FromContext(ctx).InfoContext(ctx, "passkey registration rejected", slog.String("error", err.Error()))
+		app.webAuthnError(w, http.StatusBadRequest, "The passkey could not be verified. Please try again.")
+		return
+	}
+
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+	err = app.store.WebAuthn.Insert(ctx, &store.WebAuthnCredential{
+		ID:              cred.ID,
+		UserID:          u.user.ID,
+		Name:            name,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      transports,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "passkey added", slog.Int("user_id", u.user.ID))
+	app.sendEmail(u.user.ID, mailer.Message{
+		To:      u.user.Email,
+		Subject: "A passkey was added to your Snippetbin account",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"A passkey named %q was just added to your account. It can be used to log in without your password.\n\n"+
+			"If this was not you, remove it at %s and reset your password at %s.\n",
+			u.user.Username, name, app.baseURL+"/account/", app.baseURL+"/user/password/forgot"),
+	})
+	app.sessionManager.Put(ctx, "flash", "Passkey added.")
+	app.writeJSON(w, http.StatusOK, map[string]string{"redirect": "/account/"})
+}
+
+func (app *application) accountPasskeyDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	id, err := base64.RawURLEncoding.DecodeString(r.PostForm.Get("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	err = app.store.WebAuthn.Delete(ctx, app.sessionManager.GetInt(ctx, "authenticatedUserID"), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sessionManager.Put(ctx, "flash", "Passkey removed.")
+	http.Redirect(w, r, "/account/", http.StatusSeeOther)
+}
+
+// userPasskeyLoginBegin starts a passwordless login. The browser offers
+// whichever passkeys it has for the site, so no email address is needed.
+func (app *application) userPasskeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	assertion, session, err := app.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.putWebAuthnSession(r.Context(), "webAuthnLogin", session); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, assertion)
+}
+
+func (app *application) userPasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	session, ok := app.popWebAuthnSession(ctx, "webAuthnLogin")
+	if !ok {
+		app.webAuthnError(w, http.StatusBadRequest, "No passkey login is in progress. Please try again.")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	parsed, err := protocol.ParseCredentialRequestResponseBody(r.Body)
+	if err != nil {
+		app.webAuthnError(w, http.StatusBadRequest, "The passkey response could not be read.")
+		return
+	}
+
+	var u webAuthnUser
+	lookup := func(rawID, handle []byte) (webauthn.User, error) {
+		id, ok := userIDFromHandle(handle)
+		if !ok {
+			return nil, errors.New("unknown user handle")
+		}
+		var err error
+		u, err = app.loadWebAuthnUser(ctx, id)
+		return u, err
+	}
+	_, cred, err := app.webAuthn.ValidatePasskeyLogin(lookup, session, parsed)
+	if err != nil {
+		app.metrics.Logins.WithLabelValues("failure").Inc()
+		logging.FromContext(ctx).InfoContext(ctx, "passkey login rejected", slog.String("error", err.Error()))
+		app.webAuthnError(w, http.StatusUnauthorized, "That passkey could not be used to log in.")
+		return
+	}
+	app.finishPasskeyLogin(w, r, u, cred)
+}
+
+// userPasskeySecondFactorBegin lets a user who has entered their password
+// use one of their passkeys instead of a TOTP code.
+func (app *application) userPasskeySecondFactorBegin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := app.pendingTwoFactorUser(ctx)
+	if id == 0 {
+		app.webAuthnError(w, http.StatusUnauthorized, "Your login has expired. Please log in again.")
+		return
+	}
+	u, err := app.loadWebAuthnUser(ctx, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if len(u.creds) == 0 {
+		app.webAuthnError(w, http.StatusBadRequest, "You have no passkeys.")
+		return
+	}
+	assertion, session, err := app.webAuthn.BeginLogin(u)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.putWebAuthnSession(ctx, "webAuthnSecondFactor", session); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, assertion)
+}
+
+func (app *application) userPasskeySecondFactorFinish(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := app.pendingTwoFactorUser(ctx)
+	session, ok := app.popWebAuthnSession(ctx, "webAuthnSecondFactor")
+	if id == 0 || !ok {
+		app.webAuthnError(w, http.StatusUnauthorized, "Your login has expired. Please log in again.")
+		return
+	}
+	u, err := app.loadWebAuthnUser(ctx, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	parsed, err := protocol.ParseCredentialRequestResponseBody(r.Body)
+	if err != nil {
+		app.webAuthnError(w, http.StatusBadRequest, "The passkey response could not be read.")
+		return
+	}
+	cred, err := app.webAuthn.ValidateLogin(u, session, parsed)
+	if err != nil {
+		app.recordLoginFailure(ctx, u.user.Email, app.clientIP(r), u.user)
+		logging.FromContext(ctx).InfoContext(ctx, "passkey second factor rejected", slog.String("error", err.Error()))
+		app.webAuthnError(w, http.StatusUnauthorized, "That passkey could not be used to log in.")
+		return
+	}
+	app.finishPasskeyLogin(w, r, u, cred)
+}
+
+// finishPasskeyLogin records the use of cred and logs its owner in. A
+// signature counter that went backwards means the key may have been
+// cloned, so the login is refused.
+func (app *application) finishPasskeyLogin(w http.ResponseWriter, r *http.Request, u webAuthnUser, cred *webauthn.Credential) {
+	ctx := r.Context()
+	if cred.Authenticator.CloneWarning {
+		logging.FromContext(ctx).WarnContext(ctx, "passkey signature counter went backwards",
+			slog.String("credential", base64.RawURLEncoding.EncodeToString(cred.ID)))
+		app.webAuthnError(w, http.StatusUnauthorized, "That passkey could not be used to log in.")
+		return
+	}
+	if err := app.store.WebAuthn.RecordUse(ctx, cred.ID, cred.Authenticator.SignCount, cred.Flags.BackupState); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.logIn(r, u.user); err != nil {
+		if errors.Is(err, errAccountDisabled) {
+			app.webAuthnError(w, http.StatusForbidden, "This account has been disabled.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.writeJSON(w, http.StatusOK, map[string]string{"redirect": "/snippet/create"})
+}
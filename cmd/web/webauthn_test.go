@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store"
+)
+
+// softAuthenticator is a passkey held in memory. It answers the options
+// the server sends the way a browser and authenticator would.
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+	counter    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	rand.Read(credID)
+	return &softAuthenticator{key: key, credID: credID}
+}
+
+type passkeyOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		RPID string `json:"rpId"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *softAuthenticator) clientData(typ, challenge string) []byte {
+	b, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": testBaseURL})
+	return b
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.counter)
+}
+
+// create answers a registration with a "none" attestation.
+func (a *softAuthenticator) create(t *testing.T, options []byte) []byte {
+	var opts passkeyOptions
+	if err := json.Unmarshal(options, &opts); err != nil {
+		t.Fatal(err)
+	}
+	handle, err := base64.RawURLEncoding.DecodeString(opts.PublicKey.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = handle
+
+	cose, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// User present, user verified and attested credential data included.
+	authData := a.authData(opts.PublicKey.RP.ID, 0x01|0x04|0x40)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credID)))
+	authData = append(authData, a.credID...)
+	authData = append(authData, cose...)
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(a.clientData("webauthn.create", opts.PublicKey.Challenge)),
+			"attestationObject": b64(attestation),
+		},
+	})
+	return b
+}
+
+// get signs an assertion, advancing the signature counter.
+func (a *softAuthenticator) get(t *testing.T, options []byte) []byte {
+	var opts passkeyOptions
+	if err := json.Unmarshal(options, &opts); err != nil {
+		t.Fatal(err)
+	}
+	a.counter++
+	clientData := a.clientData("webauthn.get", opts.PublicKey.Challenge)
+	authData := a.authData(opts.PublicKey.RPID, 0x01|0x04)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	return b
+}
+
+func (ts *testServer) postJSON(t *testing.T, urlPath, csrfToken string, body []byte) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+urlPath, strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CSRF-Token", csrfToken)
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	b, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.StatusCode, b
+}
+
+func TestPasskeys(t *testing.T) {
+	cfg := newConfig(t)
+	cfg.rateLimit.policies[policyDefault].Enabled = false
+	app := newTestApplication(t, cfg)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	passkey := newSoftAuthenticator(t)
+
+	// Register a passkey from the account page.
+	code, _ := ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body := ts.get(t, "/account/")
+	assert.StringContains(t, body, "You have no passkeys.")
+	csrfToken := extractCSRFToken(t, body)
+	code, _ = ts.postJSON(t, "/account/passkeys/register/begin", csrfToken, nil)
+	assert.Equal(t, code, http.StatusForbidden)
+	assert.Equal(t, ts.reauthenticate(t, store.MockUserPassword), http.StatusSeeOther)
+	code, options := ts.postJSON(t, "/account/passkeys/register/begin", csrfToken, nil)
+	assert.Equal(t, code, http.StatusOK)
+	code, result := ts.postJSON(t, "/account/passkeys/register/finish?name=Laptop", csrfToken, passkey.create(t, options))
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, string(result), `{"redirect":"/account/"}`+"\n")
+	_, _, body = ts.get(t, "/account/")
+	assert.StringContains(t, body, "<td>Laptop</td>")
+
+	// The owner is told, in case it wasn't them.
+	app.wg.Wait()
+	sent := app.mailer.(*testMailer).sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, store.MockUser.Email)
+	assert.Equal(t, sent[0].Subject, "A passkey was added to your Snippetbin account")
+	assert.StringContains(t, sent[0].Body, `"Laptop"`)
+
+	logout := func() {
+		_, _, body := ts.get(t, "/")
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		ts.postForm(t, "/user/logout", form)
+	}
+	logout()
+
+	// Log in with the passkey alone.
+	_, _, body = ts.get(t, "/user/login")
+	csrfToken = extractCSRFToken(t, body)
+	code, options = ts.postJSON(t, "/user/login/passkey/begin", csrfToken, nil)
+	assert.Equal(t, code, http.StatusOK)
+	assertion := passkey.get(t, options)
+	code, result = ts.postJSON(t, "/user/login/passkey/finish", csrfToken, assertion)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, string(result), `{"redirect":"/snippet/create"}`+"\n")
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+	creds, _ := app.store.WebAuthn.ListByUser(context.Background(), store.MockUser.ID)
+	assert.Equal(t, creds[0].SignCount, uint32(1))
+
+	// The challenge can't be answered twice.
+	code, _ = ts.postJSON(t, "/user/login/passkey/finish", csrfToken, assertion)
+	assert.Equal(t, code, http.StatusBadRequest)
+	logout()
+
+	// A counter that goes backwards looks like a cloned key.
+	_, _, body = ts.get(t, "/user/login")
+	csrfToken = extractCSRFToken(t, body)
+	_, options = ts.postJSON(t, "/user/login/passkey/begin", csrfToken, nil)
+	passkey.counter = 0
+	code, _ = ts.postJSON(t, "/user/login/passkey/finish", csrfToken, passkey.get(t, options))
+	assert.Equal(t, code, http.StatusUnauthorized)
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	passkey.counter = 5
+
+	// With TOTP on, the passkey can stand in for the code.
+	ctx := context.Background()
+	if err := app.store.TwoFactor.SetPending(ctx, store.MockUser.ID, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.store.TwoFactor.Enable(ctx, store.MockUser.ID, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	code, location := ts.login(t, store.MockUser.Email, store.MockUserPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, location, "/user/login/2fa")
+	_, _, body = ts.get(t, "/user/login/2fa")
+	assert.StringContains(t, body, "Use a passkey instead")
+	csrfToken = extractCSRFToken(t, body)
+	code, options = ts.postJSON(t, "/user/login/2fa/passkey/begin", csrfToken, nil)
+	assert.Equal(t, code, http.StatusOK)
+	code, result = ts.postJSON(t, "/user/login/2fa/passkey/finish", csrfToken, passkey.get(t, options))
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, string(result), `{"redirect":"/snippet/create"}`+"\n")
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+
+	// Remove it.
+	_, _, body = ts.get(t, "/account/")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	form.Add("id", b64(passkey.credID))
+	code, _, _ = ts.postForm(t, "/account/passkeys/delete", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	creds, _ = app.store.WebAuthn.ListByUser(ctx, store.MockUser.ID)
+	assert.Equal(t, len(creds), 0)
+	code, _, _ = ts.postForm(t, "/account/passkeys/delete", form)
+	assert.Equal(t, code, http.StatusNotFound)
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+var (
+	ErrSchemaBehind = errors.New("db: schema is behind the embedded migrations")
+	ErrSchemaDirty  = errors.New("db: schema is dirty, a previous migration failed")
+)
+
+type MigrationStatus struct {
+	Current uint
+	Latest  uint
+	Dirty   bool
+}
+
+func (s MigrationStatus) Pending() bool {
+	return s.Current < s.Latest
+}
+
+// Migrator applies the embedded SQL migrations. It pins a single connection
+// from the pool so the postgres driver's advisory lock is held for the whole
+// run, which keeps concurrent replicas from migrating at the same time.
+type Migrator struct {
+	source source.Driver
+	m      *migrate.Migrate
+}
+
+func NewMigrator(ctx context.Context, db *sql.DB, files fs.FS, dir string) (*Migrator, error) {
+	src, err := iofs.New(files, dir)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Migrator{source: src, m: m}, nil
+}
+
+func (mg *Migrator) Up() error {
+	err := mg.m.Up()
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+func (mg *Migrator) Down(steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("db: invalid number of down steps %d", steps)
+	}
+	err := mg.m.Steps(-steps)
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+func (mg *Migrator) Status() (MigrationStatus, error) {
+	var status MigrationStatus
+
+	current, dirty, err := mg.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return status, err
+	}
+	status.Current = current
+	status.Dirty = dirty
+
+	status.Latest, err = latestVersion(mg.source)
+	if err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+// CheckCurrent returns ErrSchemaBehind or ErrSchemaDirty when the database
+// is not safe to serve with the migrations embedded in this binary.
+func (mg *Migrator) CheckCurrent() (MigrationStatus, error) {
+	status, err := mg.Status()
+	if err != nil {
+		return status, err
+	}
+	if status.Dirty {
+		return status, ErrSchemaDirty
+	}
+	if status.Pending() {
+		return status, ErrSchemaBehind
+	}
+	return status, nil
+}
+
+func (mg *Migrator) Close() error {
+	srcErr, dbErr := mg.m.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+func latestVersion(src source.Driver) (uint, error) {
+	version, err := src.First()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/migrate"
+)
+
+func TestLatestVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		fs   fstest.MapFS
+		want uint
+	}{
+		{
+			name: "Sequential",
+			fs: fstest.MapFS{
+				"m/000001_a.up.sql":   {},
+				"m/000001_a.down.sql": {},
+				"m/000002_b.up.sql":   {},
+				"m/000002_b.down.sql": {},
+			},
+			want: 2,
+		},
+		{
+			name: "Gap",
+			fs: fstest.MapFS{
+				"m/000001_a.up.sql": {},
+				"m/000007_b.up.sql": {},
+			},
+			want: 7,
+		},
+		{
+			name: "Empty",
+			fs:   fstest.MapFS{"m/README": {}},
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := iofs.New(tt.fs, "m")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := latestVersion(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	src, err := iofs.New(migrate.Files, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := src.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, _, err := src.ReadDown(version); err != nil {
+			t.Errorf("migration %d has no down file: %v", version, err)
+		}
+		next, err := src.Next(version)
+		if err != nil {
+			break
+		}
+		version = next
+	}
+}
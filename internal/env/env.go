@@ -1,38 +0,0 @@
-package env
-
-import (
-	"os"
-	"strconv"
-)
-
-func GetString(key, fallback string) string {
-	val, ok := os.LookupEnv(key)
-	if !ok {
-		return fallback
-	}
-	return val
-}
-
-func GetInt(key string, fallback int) int {
-	val, ok := os.LookupEnv(key)
-	if !ok {
-		return fallback
-	}
-	intVal, err := strconv.Atoi(val)
-	if err != nil {
-		return fallback
-	}
-	return intVal
-}
-
-func GetBool(key string, fallback bool) bool {
-	val, ok := os.LookupEnv(key)
-	if !ok {
-		return fallback
-	}
-	boolVal, err := strconv.ParseBool(val)
-	if err != nil {
-		return fallback
-	}
-	return boolVal
-}
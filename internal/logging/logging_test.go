@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+func TestNewHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		level   string
+		wantErr bool
+		want    string
+	}{
+		{name: "JSON", format: "json", level: "info", want: `"msg":"hello"`},
+		{name: "Text", format: "text", level: "INFO", want: "msg=hello"},
+		{name: "Filtered by level", format: "text", level: "error", want: ""},
+		{name: "Bad format", format: "xml", level: "info", wantErr: true},
+		{name: "Bad level", format: "json", level: "loud", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h, err := NewHandler(&buf, tt.format, tt.level)
+			assert.Equal(t, err != nil, tt.wantErr)
+			if err != nil {
+				return
+			}
+			slog.New(h).Info("hello")
+			if tt.want == "" {
+				assert.Equal(t, buf.String(), "")
+				return
+			}
+			assert.StringContains(t, buf.String(), tt.want)
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, FromContext(context.Background()), slog.Default())
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := WithLogger(context.Background(), logger)
+	assert.Equal(t, FromContext(ctx), logger)
+}
@@ -0,0 +1,172 @@
+// Package loginguard slows down password guessing. Failed logins are
+// counted per account and per client IP; past a number of free attempts
+// each further failure doubles the wait before the next attempt, and an
+// account with too many failures is locked for a while.
+package loginguard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/store/cache"
+)
+
+type Config struct {
+	// Window is how long failures are remembered.
+	Window time.Duration
+	// FreeAttempts failures per account are allowed without any delay.
+	FreeAttempts int
+	// IPFreeAttempts failures per client IP, across all accounts, are
+	// allowed without any delay.
+	IPFreeAttempts int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	// LockoutThreshold failures lock the account for LockoutDuration.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.Window <= 0 {
+		errs = append(errs, errors.New("loginguard: window must be positive"))
+	}
+	if c.FreeAttempts < 0 || c.IPFreeAttempts < 0 {
+		errs = append(errs, errors.New("loginguard: free attempts must not be negative"))
+	}
+	if c.BaseDelay <= 0 || c.MaxDelay < c.BaseDelay {
+		errs = append(errs, errors.New("loginguard: base delay must be positive and not above the max delay"))
+	}
+	if c.LockoutThreshold <= c.FreeAttempts {
+		errs = append(errs, errors.New("loginguard: lockout threshold must be above the free attempts"))
+	}
+	if c.LockoutDuration <= 0 {
+		errs = append(errs, errors.New("loginguard: lockout duration must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+type Guard struct {
+	store cache.Storage
+	cfg   Config
+}
+
+func New(store cache.Storage, cfg Config) (*Guard, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Guard{store: store, cfg: cfg}, nil
+}
+
+func (g *Guard) LockoutDuration() time.Duration {
+	return g.cfg.LockoutDuration
+}
+
+// accountSubject identifies an account by a hash of its normalised email,
+// so attempts on unknown emails are counted too and no addresses end up in
+// the cache.
+func accountSubject(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "account:" + hex.EncodeToString(sum[:])
+}
+
+func ipSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns how long the client has to wait before it may try to log
+// in to the account with email from ip. Zero means it may try now.
+func (g *Guard) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	account, err := g.store.LoginAttempts.BlockedFor(ctx, accountSubject(email))
+	if err != nil {
+		return 0, err
+	}
+	client, err := g.store.LoginAttempts.BlockedFor(ctx, ipSubject(ip))
+	if err != nil {
+		return 0, err
+	}
+	return max(account, client), nil
+}
+
+// Failure records a failed login and reports whether it locked the account.
+// It only reports the failure that crosses the threshold, so the owner is
+// told once per window.
+func (g *Guard) Failure(ctx context.Context, email, ip string) (locked bool, err error) {
+	subject := accountSubject(email)
+	n, err := g.store.LoginAttempts.RecordFailure(ctx, subject, g.cfg.Window)
+	if err != nil {
+		return false, err
+	}
+	if n >= g.cfg.LockoutThreshold {
+		err = g.store.LoginAttempts.Block(ctx, subject, g.cfg.LockoutDuration)
+		locked = n == g.cfg.LockoutThreshold
+	} else if d := g.delay(n, g.cfg.FreeAttempts); d > 0 {
+		err = g.store.LoginAttempts.Block(ctx, subject, d)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	n, err = g.store.LoginAttempts.RecordFailure(ctx, ipSubject(ip), g.cfg.Window)
+	if err != nil {
+		return locked, err
+	}
+	if d := g.delay(n, g.cfg.IPFreeAttempts); d > 0 {
+		err = g.store.LoginAttempts.Block(ctx, ipSubject(ip), d)
+	}
+	return locked, err
+}
+
+// delay is the exponential backoff after the nth failure.
+func (g *Guard) delay(n, free int) time.Duration {
+	if n <= free {
+		return 0
+	}
+	d := g.cfg.BaseDelay
+	for i := free + 1; i < n && d < g.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, g.cfg.MaxDelay)
+}
+
+// Success forgets the failures of the account. Failures from the client IP
+// are kept, so logging in to one account does not reset guessing at others.
+func (g *Guard) Success(ctx context.Context, email string) error {
+	return g.store.LoginAttempts.Reset(ctx, accountSubject(email))
+}
+
+type Status struct {
+	Failures   int
+	BlockedFor time.Duration
+}
+
+func (g *Guard) AccountStatus(ctx context.Context, email string) (Status, error) {
+	return g.status(ctx, accountSubject(email))
+}
+
+func (g *Guard) IPStatus(ctx context.Context, ip string) (Status, error) {
+	return g.status(ctx, ipSubject(ip))
+}
+
+func (g *Guard) status(ctx context.Context, subject string) (Status, error) {
+	var s Status
+	var err error
+	if s.Failures, err = g.store.LoginAttempts.Failures(ctx, subject); err != nil {
+		return s, err
+	}
+	s.BlockedFor, err = g.store.LoginAttempts.BlockedFor(ctx, subject)
+	return s, err
+}
+
+// UnlockAccount lifts a lockout or backoff and forgets the failures.
+func (g *Guard) UnlockAccount(ctx context.Context, email string) error {
+	return g.store.LoginAttempts.Reset(ctx, accountSubject(email))
+}
+
+func (g *Guard) UnlockIP(ctx context.Context, ip string) error {
+	return g.store.LoginAttempts.Reset(ctx, ipSubject(ip))
+}
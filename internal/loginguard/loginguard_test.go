@@ -0,0 +1,93 @@
+package loginguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"github.com/theluminousartemis/snippetbin/internal/store/cache"
+)
+
+var testConfig = Config{
+	Window:           time.Hour,
+	FreeAttempts:     3,
+	IPFreeAttempts:   5,
+	BaseDelay:        time.Second,
+	MaxDelay:         10 * time.Second,
+	LockoutThreshold: 8,
+	LockoutDuration:  15 * time.Minute,
+}
+
+func TestDelay(t *testing.T) {
+	g, err := New(cache.NewMockStorage(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{0, 0, 0, 0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for n, d := range want {
+		assert.Equal(t, g.delay(n, 3), d)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	g, err := New(cache.NewMockStorage(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	check := func(email, ip string) time.Duration {
+		t.Helper()
+		d, err := g.Check(ctx, email, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	for range 3 {
+		locked, err := g.Failure(ctx, "alice@example.com", "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, locked, false)
+	}
+	assert.Equal(t, check("alice@example.com", "192.0.2.1"), time.Duration(0))
+
+	g.Failure(ctx, "alice@example.com", "192.0.2.1")
+	if check(" Alice@Example.com", "198.51.100.1") == 0 {
+		t.Error("expected the account to be backing off from any IP")
+	}
+	assert.Equal(t, check("bob@example.com", "198.51.100.1"), time.Duration(0))
+
+	var lockedCount int
+	for range 6 {
+		locked, _ := g.Failure(ctx, "alice@example.com", "198.51.100.1")
+		if locked {
+			lockedCount++
+		}
+	}
+	assert.Equal(t, lockedCount, 1)
+	status, err := g.AccountStatus(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, status.Failures, 10)
+	if status.BlockedFor <= 10*time.Second {
+		t.Errorf("expected a lockout, blocked for %s", status.BlockedFor)
+	}
+
+	// 198.51.100.1 has now failed six times, past its free attempts.
+	if check("carol@example.com", "198.51.100.1") == 0 {
+		t.Error("expected the IP to be backing off")
+	}
+
+	if err := g.UnlockAccount(ctx, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, check("alice@example.com", "192.0.2.1"), time.Duration(0))
+	if err := g.UnlockIP(ctx, "198.51.100.1"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, check("carol@example.com", "198.51.100.1"), time.Duration(0))
+}
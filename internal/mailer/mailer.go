@@ -0,0 +1,134 @@
+// Package mailer sends email to users. The driver is picked in
+// configuration: SMTP for production, a file outbox or the log for local
+// development and tests.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+const (
+	DriverLog  = "log"
+	DriverFile = "file"
+	DriverSMTP = "smtp"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Config struct {
+	Driver string
+	From   string
+	// SMTPAddr is host:port of the SMTP server. STARTTLS is used whenever
+	// the server offers it.
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	// OutboxDir is where the file driver writes .eml files.
+	OutboxDir string
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		errs = append(errs, fmt.Errorf("mailer: invalid from address %q", c.From))
+	}
+	switch c.Driver {
+	case DriverLog:
+	case DriverFile:
+		if c.OutboxDir == "" {
+			errs = append(errs, errors.New("mailer: the file driver needs an outbox directory"))
+		}
+	case DriverSMTP:
+		if c.SMTPAddr == "" {
+			errs = append(errs, errors.New("mailer: the smtp driver needs an address"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("mailer: unknown driver %q", c.Driver))
+	}
+	return errors.Join(errs...)
+}
+
+// New returns the Mailer for cfg.Driver.
+func New(cfg Config, logger *slog.Logger) (Mailer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Driver {
+	case DriverFile:
+		return NewFileMailer(cfg.OutboxDir, cfg.From)
+	case DriverSMTP:
+		return NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From)
+	default:
+		return NewLogMailer(logger), nil
+	}
+}
+
+// LogMailer writes messages to the log instead of sending them. Bodies
+// hold links that verify addresses and reset passwords, so they are only
+// logged at debug level.
+type LogMailer struct {
+	logger *slog.Logger
+}
+
+func NewLogMailer(logger *slog.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.logger.InfoContext(ctx, "email", slog.String("to", msg.To), slog.String("subject", msg.Subject))
+	m.logger.DebugContext(ctx, "email body", slog.String("to", msg.To), slog.String("body", msg.Body))
+	return nil
+}
+
+// encode renders msg as an RFC 5322 message with a quoted-printable UTF-8
+// body.
+func encode(msg Message, from string, now time.Time) ([]byte, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: invalid from address %q", from)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: invalid recipient %q", msg.To)
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, errors.New("mailer: subject must not contain line breaks")
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	domain := sender.Address[strings.LastIndex(sender.Address, "@")+1:]
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", sender.String())
+	fmt.Fprintf(&b, "To: %s\r\n", to.String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+	qp.Close()
+	return b.Bytes(), nil
+}
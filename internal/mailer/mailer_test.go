@@ -0,0 +1,167 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+var testMessage = Message{
+	To:      "alice@example.com",
+	Subject: "Verify your email – Snippetbin",
+	Body:    "Hi Alice,\n\nOpen https://snippetbin.example/user/verify?token=abc to continue.\n",
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	m, err := NewFileMailer(dir, "Snippetbin <no-reply@snippetbin.example>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(context.Background(), testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(files), 1)
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, msg.Header.Get("To"), "<alice@example.com>")
+	assert.Equal(t, msg.Header.Get("From"), `"Snippetbin" <no-reply@snippetbin.example>`)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, subject, testMessage.Subject)
+	assert.StringContains(t, msg.Header.Get("Message-ID"), "@snippetbin.example>")
+}
+
+func TestLogMailer(t *testing.T) {
+	for _, level := range []slog.Level{slog.LevelInfo, slog.LevelDebug} {
+		t.Run(level.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			m := NewLogMailer(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: level})))
+			if err := m.Send(context.Background(), testMessage); err != nil {
+				t.Fatal(err)
+			}
+			assert.StringContains(t, buf.String(), "to=alice@example.com")
+			assert.StringContains(t, buf.String(), "Verify your email")
+			// The body's links are as good as a password.
+			assert.Equal(t, strings.Contains(buf.String(), "token=abc"), level == slog.LevelDebug)
+		})
+	}
+}
+
+func TestEncodeRejectsHeaderInjection(t *testing.T) {
+	msg := testMessage
+	msg.Subject = "Hello\r\nBcc: everyone@example.com"
+	if _, err := encode(msg, "no-reply@snippetbin.example", time.Now()); err == nil {
+		t.Error("expected an error for a subject with a line break")
+	}
+	msg = testMessage
+	msg.To = "alice@example.com\r\nBcc: everyone@example.com"
+	if _, err := encode(msg, "no-reply@snippetbin.example", time.Now()); err == nil {
+		t.Error("expected an error for a recipient with a line break")
+	}
+}
+
+// fakeSMTPServer accepts a single message and sends its data on the
+// returned channel.
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM"), strings.HasPrefix(cmd, "RCPT TO"):
+				reply("250 OK")
+			case cmd == "DATA":
+				inData = true
+				reply("354 Go ahead")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	m, err := NewSMTPMailer(addr, "", "", "no-reply@snippetbin.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Send(ctx, testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case data := <-received:
+		assert.StringContains(t, data, "To: <alice@example.com>\r\n")
+		assert.StringContains(t, data, "Content-Transfer-Encoding: quoted-printable")
+		assert.StringContains(t, data, "https://snippetbin.example/user/verify?token=3Dabc")
+	case <-ctx.Done():
+		t.Fatal("no message received")
+	}
+}
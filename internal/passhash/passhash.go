@@ -0,0 +1,140 @@
+// Package passhash hashes passwords with Argon2id. Hashes are stored in
+// the PHC string format, which records the algorithm, version and
+// parameters, so they can be checked after the parameters change and
+// upgraded when the user next logs in. Older bcrypt hashes are still
+// checked.
+package passhash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMismatch    = errors.New("passhash: password does not match the hash")
+	ErrUnsupported = errors.New("passhash: unsupported hash format")
+)
+
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+type Params struct {
+	// Memory is in KiB.
+	Memory      int
+	Iterations  int
+	Parallelism int
+}
+
+// DefaultParams follow the OWASP recommendation of 64 MiB, three passes
+// and two lanes.
+var DefaultParams = Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 2}
+
+func (p Params) Validate() error {
+	var errs []error
+	if p.Memory < 8*p.Parallelism || p.Memory > 4*1024*1024 {
+		errs = append(errs, errors.New("passhash: memory must be at least 8 KiB per lane and at most 4 GiB"))
+	}
+	if p.Iterations < 1 || p.Iterations > 100 {
+		errs = append(errs, errors.New("passhash: iterations must be between 1 and 100"))
+	}
+	if p.Parallelism < 1 || p.Parallelism > 255 {
+		errs = append(errs, errors.New("passhash: parallelism must be between 1 and 255"))
+	}
+	return errors.Join(errs...)
+}
+
+// Hash returns the Argon2id hash of plaintext with a random salt.
+func Hash(plaintext string, p Params) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(plaintext), salt, uint32(p.Iterations), uint32(p.Memory), uint8(p.Parallelism), keyLength)
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))), nil
+}
+
+// Compare checks plaintext against an Argon2id or bcrypt hash. It returns
+// ErrMismatch if the password is wrong.
+func Compare(hash []byte, plaintext string) error {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	}
+	h, err := parse(hash)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey([]byte(plaintext), h.salt, uint32(h.params.Iterations), uint32(h.params.Memory), uint8(h.params.Parallelism), uint32(len(h.key)))
+	if subtle.ConstantTimeCompare(key, h.key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or
+// other parameters than p.
+func NeedsRehash(hash []byte, p Params) bool {
+	h, err := parse(hash)
+	return err != nil || h.params != p || len(h.salt) != saltLength || len(h.key) != keyLength
+}
+
+func isBcrypt(hash []byte) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if bytes.HasPrefix(hash, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+type argon2Hash struct {
+	params Params
+	salt   []byte
+	key    []byte
+}
+
+// parse reads a hash of the form
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+func parse(hash []byte) (*argon2Hash, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, ErrUnsupported
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnsupported
+	}
+	var h argon2Hash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.params.Memory, &h.params.Iterations, &h.params.Parallelism); err != nil {
+		return nil, ErrUnsupported
+	}
+	if h.params.Validate() != nil {
+		return nil, ErrUnsupported
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnsupported
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, ErrUnsupported
+	}
+	return &h, nil
+}
@@ -0,0 +1,69 @@
+package passhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testParams = Params{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestHash(t *testing.T) {
+	hash, err := Hash("pa$$word123", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, strings.HasPrefix(string(hash), "$argon2id$v=19$m=64,t=1,p=1$"), true)
+	assert.Equal(t, Compare(hash, "pa$$word123"), nil)
+	assert.Equal(t, errors.Is(Compare(hash, "wrong"), ErrMismatch), true)
+
+	other, _ := Hash("pa$$word123", testParams)
+	assert.Equal(t, string(hash) == string(other), false)
+
+	// Unlike bcrypt, every byte of a long password counts.
+	long := strings.Repeat("a", 72)
+	hash, _ = Hash(long+"1", testParams)
+	assert.Equal(t, errors.Is(Compare(hash, long+"2"), ErrMismatch), true)
+}
+
+func TestCompareBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pa$$word123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Compare(hash, "pa$$word123"), nil)
+	assert.Equal(t, errors.Is(Compare(hash, "wrong"), ErrMismatch), true)
+	assert.Equal(t, NeedsRehash(hash, testParams), true)
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash, _ := Hash("pa$$word123", testParams)
+	assert.Equal(t, NeedsRehash(hash, testParams), false)
+	assert.Equal(t, NeedsRehash(hash, Params{Memory: 128, Iterations: 1, Parallelism: 1}), true)
+	assert.Equal(t, NeedsRehash(hash, Params{Memory: 64, Iterations: 2, Parallelism: 1}), true)
+}
+
+func TestCompareMalformed(t *testing.T) {
+	tests := []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$",
+	}
+	for _, hash := range tests {
+		assert.Equal(t, errors.Is(Compare([]byte(hash), "pa$$word123"), ErrUnsupported), true)
+	}
+}
+
+func TestParamsValidate(t *testing.T) {
+	assert.Equal(t, DefaultParams.Validate(), nil)
+	assert.Equal(t, Params{Memory: 8, Iterations: 1, Parallelism: 2}.Validate() != nil, true)
+	assert.Equal(t, Params{Memory: 64, Iterations: 0, Parallelism: 1}.Validate() != nil, true)
+	assert.Equal(t, Params{Memory: 64, Iterations: 1, Parallelism: 0}.Validate() != nil, true)
+}
@@ -0,0 +1,106 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// breachList holds SHA-1 hashes of passwords known from data breaches, in
+// the format of Have I Been Pwned's Pwned Passwords.
+type breachList interface {
+	contains(hash [sha1.Size]byte) (bool, error)
+}
+
+// openBreachList opens path, either a file of hashes, which is loaded
+// into memory, or a directory of k-anonymity range files, which are read
+// as needed.
+func openBreachList(path string) (breachList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return rangeDir(path), nil
+	}
+	return loadHashFile(path)
+}
+
+// hashFile is a sorted list of hashes, loaded from a file with one
+// hex encoded hash per line, optionally followed by a colon and the
+// number of times it was seen.
+type hashFile [][sha1.Size]byte
+
+func loadHashFile(path string) (hashFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hashes hashFile
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hexHash, _, _ := strings.Cut(line, ":")
+		var h [sha1.Size]byte
+		if len(hexHash) != 2*sha1.Size {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, n)
+		}
+		if _, err := hex.Decode(h[:], []byte(hexHash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, n)
+		}
+		hashes = append(hashes, h)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(hashes, func(a, b [sha1.Size]byte) int { return bytes.Compare(a[:], b[:]) })
+	return hashes, nil
+}
+
+func (l hashFile) contains(hash [sha1.Size]byte) (bool, error) {
+	_, found := slices.BinarySearchFunc(l, hash, func(a, b [sha1.Size]byte) int { return bytes.Compare(a[:], b[:]) })
+	return found, nil
+}
+
+// rangeDir is a directory with a file per five hex digit hash prefix,
+// such as 5BAA6.txt, each listing the remaining 35 digits of the hashes
+// with that prefix and their counts, as served by the Pwned Passwords
+// range API. Only the file for the prefix is read, and a missing file
+// means no hashes with that prefix.
+type rangeDir string
+
+func (d rangeDir) contains(hash [sha1.Size]byte) (bool, error) {
+	h := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix, suffix := h[:5], h[5:]
+	f, err := os.Open(filepath.Join(string(d), prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		// Padding entries added to hide the real size have a count of 0.
+		if strings.EqualFold(s, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
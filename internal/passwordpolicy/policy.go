@@ -0,0 +1,67 @@
+// Package passwordpolicy decides whether a new password is good enough.
+// It rejects passwords that are easy to guess, going by a zxcvbn style
+// strength estimate, and passwords known from data breaches, going by a
+// local copy of a breached password hash list. Nothing is sent over the
+// network.
+package passwordpolicy
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+)
+
+type Config struct {
+	// MinScore is the lowest strength score, from 0 to 4, accepted.
+	MinScore int
+	// BreachedList is a file of SHA-1 hashes of breached passwords, or a
+	// directory of k-anonymity range files. Empty skips the check.
+	BreachedList string
+}
+
+func (c Config) Validate() error {
+	if c.MinScore < 0 || c.MinScore > 4 {
+		return errors.New("passwordpolicy: min score must be between 0 and 4")
+	}
+	return nil
+}
+
+type Policy struct {
+	minScore int
+	breached breachList
+}
+
+func New(cfg Config) (*Policy, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	p := &Policy{minScore: cfg.MinScore}
+	if cfg.BreachedList != "" {
+		l, err := openBreachList(cfg.BreachedList)
+		if err != nil {
+			return nil, fmt.Errorf("passwordpolicy: breached list: %w", err)
+		}
+		p.breached = l
+	}
+	return p, nil
+}
+
+// Check returns a message for the user saying why password is not
+// acceptable, or the empty string if it is. The userInputs are the
+// username, email address and the like, which make a password containing
+// them easy to guess.
+func (p *Policy) Check(password string, userInputs ...string) (string, error) {
+	if p.breached != nil {
+		found, err := p.breached.contains(sha1.Sum([]byte(password)))
+		if err != nil {
+			return "", fmt.Errorf("passwordpolicy: breached list: %w", err)
+		}
+		if found {
+			return "This password has appeared in a data breach and can't be used. Please choose a different one.", nil
+		}
+	}
+	if res := Strength(password, userInputs...); res.Score < p.minScore {
+		return "This password is too easy to guess. " + res.Feedback, nil
+	}
+	return "", nil
+}
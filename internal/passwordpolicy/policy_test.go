@@ -0,0 +1,126 @@
+package passwordpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestCheck(t *testing.T) {
+	p, err := New(Config{MinScore: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password   string
+		userInputs []string
+		want       string
+	}{
+		{"fizzy-otter-lantern-42", nil, ""},
+		{"password", nil, "This password is too easy to guess. This is a top-10 common password."},
+		{"qwertyuiop", nil, "This password is too easy to guess. This is a top-100 common password."},
+		{"P@ssw0rd", nil, "predictable substitutions"},
+		{"zxcvbnm,./", nil, "Straight rows of keys"},
+		{"abcdefghijk", nil, "Sequences like"},
+		{"abcabcabcabc", nil, `Repeats like "abcabc"`},
+		{"13/05/1987", nil, "Dates and years"},
+		{"alicewonders", []string{"alicewonders", "alice@example.com"}, "Avoid using your username or email address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got, err := p.Check(tt.password, tt.userInputs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				assert.Equal(t, got, "")
+				return
+			}
+			assert.StringContains(t, strings.ToLower(got), strings.ToLower(tt.want))
+		})
+	}
+}
+
+func TestCheckMinScore(t *testing.T) {
+	p, err := New(Config{MinScore: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := p.Check("password")
+	assert.Equal(t, msg, "")
+
+	_, err = New(Config{MinScore: 5})
+	assert.Equal(t, err != nil, true)
+}
+
+func TestCheckBreachedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	list := "# top breached passwords\n" +
+		sha1Hex("fizzy-otter-lantern-42") + ":12\n" +
+		strings.ToLower(sha1Hex("another breached password")) + "\n"
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(Config{MinScore: 3, BreachedList: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := p.Check("fizzy-otter-lantern-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.StringContains(t, msg, "appeared in a data breach")
+	msg, _ = p.Check("another breached password")
+	assert.StringContains(t, msg, "appeared in a data breach")
+	msg, _ = p.Check("quiet-harbour-velvet-17")
+	assert.Equal(t, msg, "")
+
+	if err := os.WriteFile(path, []byte("not a hash\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(Config{BreachedList: path})
+	assert.StringContains(t, err.Error(), "not a SHA-1 hash")
+}
+
+func TestCheckBreachedRangeDir(t *testing.T) {
+	dir := t.TempDir()
+	h := sha1Hex("fizzy-otter-lantern-42")
+	padding := sha1Hex("quiet-harbour-velvet-17")
+	ranges := map[string]string{
+		h[:5]:       "0000000000000000000000000000000000A:1\r\n" + h[5:] + ":3\r\n",
+		padding[:5]: padding[5:] + ":0\r\n",
+	}
+	for prefix, body := range ranges {
+		if err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := New(Config{MinScore: 3, BreachedList: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := p.Check("fizzy-otter-lantern-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.StringContains(t, msg, "appeared in a data breach")
+	// Padding entries don't count.
+	msg, _ = p.Check("quiet-harbour-velvet-17")
+	assert.Equal(t, msg, "")
+	// Nor does a prefix without a file.
+	msg, _ = p.Check("amber-canyon-whistle-93")
+	assert.Equal(t, msg, "")
+
+	_, err = New(Config{BreachedList: filepath.Join(dir, "missing")})
+	assert.Equal(t, err != nil, true)
+}
@@ -0,0 +1,796 @@
+package passwordpolicy
+
+import (
+	_ "embed"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The strength estimate follows zxcvbn: the password is split into the
+// parts an attacker would guess separately, such as common passwords,
+// keyboard walks, sequences, repeats and dates, and the guesses needed
+// for the cheapest split decide the score.
+
+//go:embed common-passwords.txt
+var commonPasswordList string
+
+// commonPasswords maps each common password to its rank, most common
+// first.
+var commonPasswords = rankedDictionary(strings.Fields(commonPasswordList))
+
+// maxLength is how much of a password is looked at. Anything longer is
+// strong enough already, and the work grows with the square of the
+// length.
+const maxLength = 100
+
+const (
+	patternDictionary = "dictionary"
+	patternUserInput  = "user_input"
+	patternSpatial    = "spatial"
+	patternRepeat     = "repeat"
+	patternSequence   = "sequence"
+	patternDate       = "date"
+	patternBruteforce = "bruteforce"
+)
+
+// match is a part of the password, from rune i to rune j inclusive, and
+// the guesses it takes to find it.
+type match struct {
+	pattern string
+	i, j    int
+	token   string
+	guesses float64
+
+	rank     int
+	l33t     bool
+	reversed bool
+	turns    int
+	baseLen  int
+}
+
+// Result is the strength of a password.
+type Result struct {
+	Guesses float64
+	// Score is from 0, too guessable, to 4, very unguessable.
+	Score int
+	// Feedback tells the user what makes the password weak. It is empty
+	// for a score of 4.
+	Feedback string
+}
+
+// Strength estimates how many guesses it takes to find password. The
+// userInputs, such as a username or email address, are guessed first.
+func Strength(password string, userInputs ...string) Result {
+	runes := []rune(password)
+	if len(runes) > maxLength {
+		runes = runes[:maxLength]
+	}
+	var inputs []string
+	for _, in := range userInputs {
+		in = strings.ToLower(in)
+		inputs = append(inputs, in)
+		// An email address is guessed by its parts too.
+		if local, domain, ok := strings.Cut(in, "@"); ok {
+			inputs = append(inputs, local)
+			inputs = append(inputs, strings.FieldsFunc(domain, func(r rune) bool { return r == '.' })...)
+		}
+	}
+	guesses, sequence := mostGuessable(runes, rankedDictionary(inputs))
+	res := Result{Guesses: guesses, Score: score(guesses)}
+	if res.Score < 4 {
+		res.Feedback = feedback(sequence)
+	}
+	return res
+}
+
+func score(guesses float64) int {
+	const delta = 5
+	switch {
+	case guesses < 1e3+delta:
+		return 0
+	case guesses < 1e6+delta:
+		return 1
+	case guesses < 1e8+delta:
+		return 2
+	case guesses < 1e10+delta:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func rankedDictionary(words []string) map[string]int {
+	d := make(map[string]int, len(words))
+	for i, w := range words {
+		w = strings.ToLower(w)
+		if _, ok := d[w]; !ok && w != "" {
+			d[w] = i + 1
+		}
+	}
+	return d
+}
+
+// feedback explains the longest guessable part of the sequence.
+func feedback(sequence []match) string {
+	var worst *match
+	for k := range sequence {
+		m := &sequence[k]
+		if m.pattern != patternBruteforce && (worst == nil || len([]rune(m.token)) > len([]rune(worst.token))) {
+			worst = m
+		}
+	}
+	const addWords = "Add another word or two. Uncommon words are better."
+	if worst == nil {
+		return addWords
+	}
+	switch worst.pattern {
+	case patternDictionary:
+		msg := "This is similar to a commonly used password."
+		if len(sequence) == 1 && !worst.l33t && !worst.reversed {
+			switch {
+			case worst.rank <= 10:
+				msg = "This is a top-10 common password."
+			case worst.rank <= 100:
+				msg = "This is a top-100 common password."
+			default:
+				msg = "This is a very common password."
+			}
+		}
+		if worst.l33t {
+			msg += ` Predictable substitutions like "@" instead of "a" don't help very much.`
+		}
+		return msg
+	case patternUserInput:
+		return "Avoid using your username or email address in your password."
+	case patternSpatial:
+		if worst.turns == 1 {
+			return "Straight rows of keys are easy to guess."
+		}
+		return "Short keyboard patterns are easy to guess."
+	case patternRepeat:
+		if worst.baseLen == 1 {
+			return `Repeats like "aaa" are easy to guess.`
+		}
+		return `Repeats like "abcabc" are only slightly harder to guess than "abc".`
+	case patternSequence:
+		return `Sequences like "abc" or "6543" are easy to guess.`
+	case patternDate:
+		return "Dates and years are easy to guess."
+	}
+	return addWords
+}
+
+// mostGuessable finds the sequence of non-overlapping matches covering
+// password that needs the fewest guesses. A sequence of l matches costs
+// l! times the product of their guesses, since the attacker has to try
+// the patterns in every order, plus a penalty for each extra match.
+func mostGuessable(password []rune, userInputs map[string]int) (float64, []match) {
+	n := len(password)
+	if n == 0 {
+		return 1, nil
+	}
+	byEnd := make([][]match, n)
+	for _, m := range omnimatch(password, userInputs) {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	type entry struct {
+		m  match
+		pi float64 // product of guesses
+		g  float64 // total guesses
+	}
+	// optimal[k][l] is the best sequence of l matches covering runes 0
+	// to k.
+	optimal := make([]map[int]entry, n)
+	for k := range optimal {
+		optimal[k] = make(map[int]entry)
+	}
+	update := func(m match, l int) {
+		k := m.j
+		pi := m.guesses
+		if l > 1 {
+			pi *= optimal[m.i-1][l-1].pi
+		}
+		g := factorial(l)*pi + math.Pow(10000, float64(l-1))
+		// A shorter sequence that is at least as good wins.
+		for other, e := range optimal[k] {
+			if other <= l && e.g <= g {
+				return
+			}
+		}
+		optimal[k][l] = entry{m: m, pi: pi, g: g}
+	}
+	bruteforceUpdate := func(k int) {
+		update(bruteforceMatch(password, 0, k), 1)
+		for i := 1; i <= k; i++ {
+			m := bruteforceMatch(password, i, k)
+			for l, e := range optimal[i-1] {
+				// Two bruteforce matches in a row are never better than
+				// one covering both.
+				if e.m.pattern == patternBruteforce {
+					continue
+				}
+				update(m, l+1)
+			}
+		}
+	}
+	for k := range n {
+		for _, m := range byEnd[k] {
+			m.guesses = estimateGuesses(m, n)
+			if m.i > 0 {
+				for l := range optimal[m.i-1] {
+					update(m, l+1)
+				}
+			} else {
+				update(m, 1)
+			}
+		}
+		bruteforceUpdate(k)
+	}
+
+	best, bestL := math.Inf(1), 0
+	for l, e := range optimal[n-1] {
+		if e.g < best || (e.g == best && l < bestL) {
+			best, bestL = e.g, l
+		}
+	}
+	sequence := make([]match, bestL)
+	for k, l := n-1, bestL; l > 0; l-- {
+		m := optimal[k][l].m
+		sequence[l-1] = m
+		k = m.i - 1
+	}
+	return best, sequence
+}
+
+func bruteforceMatch(password []rune, i, j int) match {
+	m := match{pattern: patternBruteforce, i: i, j: j, token: string(password[i : j+1])}
+	m.guesses = estimateGuesses(m, len(password))
+	return m
+}
+
+// estimateGuesses applies a floor to the guesses of a part shorter than
+// the password, so that splitting it into tiny matches doesn't pay off.
+func estimateGuesses(m match, passwordLen int) float64 {
+	length := m.j - m.i + 1
+	floor := 1.0
+	if length < passwordLen {
+		floor = 50
+		if length == 1 {
+			floor = 10
+		}
+	}
+	guesses := m.guesses
+	if m.pattern == patternBruteforce {
+		guesses = math.Pow(10, float64(length))
+		// Never cheaper than a pattern of the same length.
+		floor++
+	}
+	return math.Max(guesses, floor)
+}
+
+// omnimatch returns every match of every pattern in password.
+func omnimatch(password []rune, userInputs map[string]int) []match {
+	var matches []match
+	matches = append(matches, dictionaryMatches(password, commonPasswords, patternDictionary)...)
+	matches = append(matches, dictionaryMatches(password, userInputs, patternUserInput)...)
+	matches = append(matches, spatialMatches(password)...)
+	matches = append(matches, repeatMatches(password, userInputs)...)
+	matches = append(matches, sequenceMatches(password)...)
+	matches = append(matches, dateMatches(password)...)
+	return matches
+}
+
+// l33tTable lists the letters each substitute can stand for.
+var l33tTable = map[rune][]rune{
+	'4': {'a'}, '@': {'a'},
+	'8': {'b'},
+	'(': {'c'}, '{': {'c'}, '[': {'c'}, '<': {'c'},
+	'3': {'e'},
+	'6': {'g'}, '9': {'g'},
+	'1': {'i', 'l'}, '!': {'i'}, '|': {'i', 'l'},
+	'0': {'o'},
+	'$': {'s'}, '5': {'s'},
+	'+': {'t'}, '7': {'t'},
+	'%': {'x'},
+	'2': {'z'},
+}
+
+// dictionaryMatches finds words of dict in password, also reversed and
+// with l33t substitutions undone.
+func dictionaryMatches(password []rune, dict map[string]int, pattern string) []match {
+	if len(dict) == 0 {
+		return nil
+	}
+	maxWord := 0
+	for w := range dict {
+		maxWord = max(maxWord, len([]rune(w)))
+	}
+	lower := []rune(strings.ToLower(string(password)))
+	n := len(password)
+	var matches []match
+	for i := range n {
+		for j := i; j < n && j-i < maxWord; j++ {
+			token := string(password[i : j+1])
+			word := string(lower[i : j+1])
+			if rank, ok := dict[word]; ok {
+				matches = append(matches, dictionaryMatch(pattern, i, j, token, rank, false, false, nil))
+			}
+			if j > i {
+				reversed := []rune(word)
+				slices.Reverse(reversed)
+				if rank, ok := dict[string(reversed)]; ok {
+					matches = append(matches, dictionaryMatch(pattern, i, j, token, rank, false, true, nil))
+				}
+			}
+			for _, sub := range unl33t(lower[i : j+1]) {
+				if rank, ok := dict[sub.word]; ok {
+					matches = append(matches, dictionaryMatch(pattern, i, j, token, rank, true, false, sub.subs))
+				}
+			}
+		}
+	}
+	return matches
+}
+
+type l33tCandidate struct {
+	word string
+	// subs maps each substitute used to the letter it stands for.
+	subs map[rune]rune
+}
+
+// unl33t returns the words token could stand for with at least one
+// substitution undone.
+func unl33t(token []rune) []l33tCandidate {
+	var positions []int
+	for k, r := range token {
+		if _, ok := l33tTable[r]; ok {
+			positions = append(positions, k)
+		}
+	}
+	// Substitutes with two readings double the candidates; give up on
+	// tokens that are mostly digits and symbols.
+	if len(positions) == 0 || len(positions) > 8 {
+		return nil
+	}
+	var candidates []l33tCandidate
+	var walk func(p int, word []rune, subs map[rune]rune)
+	walk = func(p int, word []rune, subs map[rune]rune) {
+		if p == len(positions) {
+			if len(subs) > 0 {
+				candidates = append(candidates, l33tCandidate{word: string(word), subs: subs})
+			}
+			return
+		}
+		k := positions[p]
+		r := token[k]
+		// Left as it is.
+		walk(p+1, word, subs)
+		for _, letter := range l33tTable[r] {
+			// A substitute reads the same way throughout the token.
+			if prev, ok := subs[r]; ok && prev != letter {
+				continue
+			}
+			w := slices.Clone(word)
+			w[k] = letter
+			s := make(map[rune]rune, len(subs)+1)
+			for key, v := range subs {
+				s[key] = v
+			}
+			s[r] = letter
+			walk(p+1, w, s)
+		}
+	}
+	walk(0, slices.Clone(token), map[rune]rune{})
+	return candidates
+}
+
+func dictionaryMatch(pattern string, i, j int, token string, rank int, l33t, reversed bool, subs map[rune]rune) match {
+	guesses := float64(rank) * uppercaseVariations(token)
+	if l33t {
+		guesses *= l33tVariations(token, subs)
+	}
+	if reversed {
+		guesses *= 2
+	}
+	return match{pattern: pattern, i: i, j: j, token: token, guesses: guesses, rank: rank, l33t: l33t, reversed: reversed}
+}
+
+// uppercaseVariations is how many ways of capitalising the word have to
+// be tried before this one. A capital first or last letter, or all
+// capitals, are tried first.
+func uppercaseVariations(token string) float64 {
+	runes := []rune(token)
+	var upper, lower int
+	for _, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	first := unicode.IsUpper(runes[0]) && upper == 1
+	last := unicode.IsUpper(runes[len(runes)-1]) && upper == 1
+	if first || last || lower == 0 {
+		return 2
+	}
+	var variations float64
+	for k := 1; k <= min(upper, lower); k++ {
+		variations += binomial(upper+lower, k)
+	}
+	return variations
+}
+
+// l33tVariations is how many ways of substituting the word's letters
+// have to be tried before this one.
+func l33tVariations(token string, subs map[rune]rune) float64 {
+	variations := 1.0
+	lower := strings.ToLower(token)
+	for sub, letter := range subs {
+		s := strings.Count(lower, string(sub))
+		u := strings.Count(lower, string(letter))
+		if s == 0 || u == 0 {
+			variations *= 2
+			continue
+		}
+		var v float64
+		for k := 1; k <= min(s, u); k++ {
+			v += binomial(s+u, k)
+		}
+		variations *= v
+	}
+	return variations
+}
+
+// qwerty is the layout, with each key's unshifted and shifted character,
+// that keyboard walks are looked for on. Rows after the first start one
+// key to the right, which gives each key up to six neighbours.
+var qwerty = []string{
+	"`~ 1! 2@ 3# 4$ 5% 6^ 7& 8* 9( 0) -_ =+",
+	"qQ wW eE rR tT yY uU iI oO pP [{ ]} \\|",
+	"aA sS dD fF gG hH jJ kK lL ;: '\"",
+	"zZ xX cC vV bB nN mM ,< .> /?",
+}
+
+type key struct {
+	x, y    int
+	shifted bool
+}
+
+var (
+	keys      map[rune]key
+	keyAt     map[[2]int][2]rune
+	keyStarts float64
+	keyDegree float64
+)
+
+func init() {
+	keys = make(map[rune]key)
+	keyAt = make(map[[2]int][2]rune)
+	for y, row := range qwerty {
+		for k, pair := range strings.Fields(row) {
+			x := k
+			if y > 0 {
+				x++
+			}
+			r := []rune(pair)
+			keys[r[0]] = key{x: x, y: y}
+			keys[r[1]] = key{x: x, y: y, shifted: true}
+			keyAt[[2]int{x, y}] = [2]rune{r[0], r[1]}
+		}
+	}
+	var degrees int
+	for pos := range keyAt {
+		for _, n := range neighbours(pos[0], pos[1]) {
+			if _, ok := keyAt[n]; ok {
+				degrees++
+			}
+		}
+	}
+	keyStarts = float64(len(keyAt))
+	keyDegree = float64(degrees) / keyStarts
+}
+
+// neighbours lists the positions around (x, y) in a fixed order, so the
+// index is the direction of a step.
+func neighbours(x, y int) [6][2]int {
+	return [6][2]int{{x - 1, y}, {x, y - 1}, {x + 1, y - 1}, {x + 1, y}, {x, y + 1}, {x - 1, y + 1}}
+}
+
+// direction returns which neighbour of a b is, or -1.
+func direction(a, b rune) int {
+	ka, ok := keys[a]
+	if !ok {
+		return -1
+	}
+	kb, ok := keys[b]
+	if !ok {
+		return -1
+	}
+	for d, n := range neighbours(ka.x, ka.y) {
+		if n == [2]int{kb.x, kb.y} {
+			return d
+		}
+	}
+	return -1
+}
+
+// spatialMatches finds walks of three or more neighbouring keys.
+func spatialMatches(password []rune) []match {
+	var matches []match
+	n := len(password)
+	for i := 0; i < n-2; {
+		j, turns, lastDir := i, 0, -1
+		shifted := 0
+		if k, ok := keys[password[i]]; ok && k.shifted {
+			shifted++
+		}
+		for j+1 < n {
+			d := direction(password[j], password[j+1])
+			if d < 0 {
+				break
+			}
+			if d != lastDir {
+				turns++
+				lastDir = d
+			}
+			if keys[password[j+1]].shifted {
+				shifted++
+			}
+			j++
+		}
+		if j-i >= 2 {
+			m := match{pattern: patternSpatial, i: i, j: j, token: string(password[i : j+1]), turns: turns}
+			m.guesses = spatialGuesses(j-i+1, turns, shifted)
+			matches = append(matches, m)
+			i = j
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+func spatialGuesses(length, turns, shifted int) float64 {
+	var guesses float64
+	for l := 2; l <= length; l++ {
+		for t := 1; t <= min(turns, l-1); t++ {
+			guesses += binomial(l-1, t-1) * keyStarts * math.Pow(keyDegree, float64(t))
+		}
+	}
+	if shifted > 0 {
+		unshifted := length - shifted
+		if unshifted == 0 {
+			guesses *= 2
+		} else {
+			var v float64
+			for k := 1; k <= min(shifted, unshifted); k++ {
+				v += binomial(shifted+unshifted, k)
+			}
+			guesses *= v
+		}
+	}
+	return guesses
+}
+
+// repeatMatches finds a base string repeated two or more times. The
+// base is guessed first, then how often it is repeated.
+func repeatMatches(password []rune, userInputs map[string]int) []match {
+	var matches []match
+	n := len(password)
+	for i := 0; i < n-1; {
+		bestSpan, bestBase := 0, 0
+		for base := 1; base <= (n-i)/2; base++ {
+			count := 1
+			for i+(count+1)*base <= n && slices.Equal(password[i:i+base], password[i+count*base:i+(count+1)*base]) {
+				count++
+			}
+			if count > 1 && count*base > bestSpan {
+				bestSpan, bestBase = count*base, base
+			}
+		}
+		if bestSpan == 0 {
+			i++
+			continue
+		}
+		baseGuesses, _ := mostGuessable(password[i:i+bestBase], userInputs)
+		matches = append(matches, match{
+			pattern: patternRepeat,
+			i:       i,
+			j:       i + bestSpan - 1,
+			token:   string(password[i : i+bestSpan]),
+			guesses: baseGuesses * float64(bestSpan/bestBase),
+			baseLen: bestBase,
+		})
+		i += bestSpan
+	}
+	return matches
+}
+
+// sequenceMatches finds runs of three or more characters that step by
+// the same small amount, like "abc", "6543" or "acegi".
+func sequenceMatches(password []rune) []match {
+	const maxDelta = 5
+	var matches []match
+	n := len(password)
+	add := func(i, j, delta int) {
+		if j-i < 2 || delta == 0 || delta > maxDelta || delta < -maxDelta {
+			return
+		}
+		token := password[i : j+1]
+		var base float64
+		switch first := token[0]; {
+		case strings.ContainsRune("aAzZ019", first):
+			base = 4
+		case unicode.IsDigit(first):
+			base = 10
+		default:
+			base = 26
+		}
+		if delta < 0 {
+			base *= 2
+		}
+		matches = append(matches, match{pattern: patternSequence, i: i, j: j, token: string(token), guesses: base * float64(len(token))})
+	}
+	if n < 3 {
+		return nil
+	}
+	i := 0
+	lastDelta := int(password[1] - password[0])
+	for k := 1; k < n; k++ {
+		delta := int(password[k] - password[k-1])
+		if delta != lastDelta {
+			add(i, k-1, lastDelta)
+			i = k - 1
+			lastDelta = delta
+		}
+	}
+	add(i, n-1, lastDelta)
+	return matches
+}
+
+const (
+	minYearSpace    = 20
+	maxDateTokenLen = 10
+)
+
+var (
+	yearRX        = regexp.MustCompile(`^(19|20)\d\d$`)
+	dateWithSepRX = regexp.MustCompile(`^(\d{1,4})([\s/\\_.-])(\d{1,2})([\s/\\_.-])(\d{1,4})$`)
+	// dateSplits are where a run of digits of each length can be cut
+	// into day, month and year.
+	dateSplits = map[int][][2]int{
+		4: {{1, 2}, {2, 3}},
+		5: {{1, 3}, {2, 3}},
+		6: {{1, 2}, {2, 4}, {4, 5}},
+		7: {{1, 3}, {2, 3}, {4, 5}, {4, 6}},
+		8: {{2, 4}, {4, 6}},
+	}
+	referenceYear = time.Now().Year()
+)
+
+// dateMatches finds years and dates written as digits, with or without
+// separators.
+func dateMatches(password []rune) []match {
+	var matches []match
+	n := len(password)
+	for i := range n {
+		for j := i + 3; j < n && j-i < maxDateTokenLen; j++ {
+			token := string(password[i : j+1])
+			if yearRX.MatchString(token) {
+				year, _ := strconv.Atoi(token)
+				matches = append(matches, match{pattern: patternDate, i: i, j: j, token: token, guesses: yearSpace(year)})
+				continue
+			}
+			if year, ok := parseDate(token); ok {
+				guesses := yearSpace(year) * 365
+				if !isDigits(token) {
+					guesses *= 4
+				}
+				matches = append(matches, match{pattern: patternDate, i: i, j: j, token: token, guesses: guesses})
+			}
+		}
+	}
+	return matches
+}
+
+func yearSpace(year int) float64 {
+	return math.Max(math.Abs(float64(year-referenceYear)), minYearSpace)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// parseDate returns the year of token if it reads as a day, month and
+// year in some order.
+func parseDate(token string) (int, bool) {
+	if isDigits(token) {
+		for _, split := range dateSplits[len(token)] {
+			a, _ := strconv.Atoi(token[:split[0]])
+			b, _ := strconv.Atoi(token[split[0]:split[1]])
+			c, _ := strconv.Atoi(token[split[1]:])
+			if year, ok := dayMonthYear(a, b, c, len(token[split[1]:]) >= 2, len(token[:split[0]]) >= 2); ok {
+				return year, true
+			}
+		}
+		return 0, false
+	}
+	m := dateWithSepRX.FindStringSubmatch(token)
+	if m == nil || m[2] != m[4] {
+		return 0, false
+	}
+	a, _ := strconv.Atoi(m[1])
+	b, _ := strconv.Atoi(m[3])
+	c, _ := strconv.Atoi(m[5])
+	return dayMonthYear(a, b, c, len(m[5]) >= 2, len(m[1]) >= 2)
+}
+
+// dayMonthYear tries the year last, then first. Two digit years are
+// read as the nearest to the reference year.
+func dayMonthYear(a, b, c int, lastCanBeYear, firstCanBeYear bool) (int, bool) {
+	validDayMonth := func(x, y int) bool {
+		return (x >= 1 && x <= 31 && y >= 1 && y <= 12) || (y >= 1 && y <= 31 && x >= 1 && x <= 12)
+	}
+	fullYear := func(y int) (int, bool) {
+		switch {
+		case y >= 1000 && y <= 2050:
+			return y, true
+		case y >= 100:
+			return 0, false
+		case y > 50:
+			return 1900 + y, true
+		default:
+			return 2000 + y, true
+		}
+	}
+	if lastCanBeYear && validDayMonth(a, b) {
+		if y, ok := fullYear(c); ok {
+			return y, true
+		}
+	}
+	if firstCanBeYear && validDayMonth(b, c) {
+		if y, ok := fullYear(a); ok {
+			return y, true
+		}
+	}
+	return 0, false
+}
+
+func factorial(n int) float64 {
+	f := 1.0
+	for k := 2; k <= n; k++ {
+		f *= float64(k)
+	}
+	return f
+}
+
+func binomial(n, k int) float64 {
+	if k > n {
+		return 0
+	}
+	if k == 0 {
+		return 1
+	}
+	r := 1.0
+	for d := 1; d <= k; d++ {
+		r *= float64(n)
+		r /= float64(d)
+		n--
+	}
+	return r
+}
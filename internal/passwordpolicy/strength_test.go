@@ -0,0 +1,75 @@
+package passwordpolicy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+func TestStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		score    int
+		pattern  string
+	}{
+		{"", 0, ""},
+		{"password", 0, patternDictionary},
+		{"PASSWORD", 0, patternDictionary},
+		{"drowssap", 0, patternDictionary},
+		{"p4$$w0rd", 0, patternDictionary},
+		{"qwertyuiop", 0, patternDictionary},
+		{"zxcvbnm,./", 1, patternSpatial},
+		{"aaaaaaaaaaaa", 0, patternRepeat},
+		{"abcdefgh", 0, patternSequence},
+		{"97531", 0, patternSequence},
+		{"13/05/1987", 1, patternDate},
+		{"19870513", 1, patternDate},
+		{"Tr0ub4dor&3", 4, patternBruteforce},
+		{"correcthorsebatterystaple", 4, patternBruteforce},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			res := Strength(tt.password)
+			assert.Equal(t, res.Score, tt.score)
+			_, sequence := mostGuessable([]rune(tt.password), nil)
+			if tt.pattern != "" {
+				assert.Equal(t, len(sequence), 1)
+				assert.Equal(t, sequence[0].pattern, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestStrengthUserInputs(t *testing.T) {
+	withInputs := Strength("alicewonders99", "alicewonders", "alice@example.com")
+	without := Strength("alicewonders99")
+	assert.Equal(t, withInputs.Guesses < without.Guesses, true)
+	assert.StringContains(t, withInputs.Feedback, "username or email address")
+
+	// The parts of an email address count on their own.
+	res := Strength("example2024", "alice@example.com")
+	assert.Equal(t, res.Score < 3, true)
+}
+
+func TestStrengthLongPassword(t *testing.T) {
+	res := Strength(strings.Repeat("x7Kq", 1000))
+	assert.Equal(t, res.Score >= 0, true)
+}
+
+func TestUppercaseVariations(t *testing.T) {
+	tests := []struct {
+		token string
+		want  float64
+	}{
+		{"password", 1},
+		{"Password", 2},
+		{"passworD", 2},
+		{"PASSWORD", 2},
+		{"PassWord", 36},
+		{"1234", 1},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, uppercaseVariations(tt.token), tt.want)
+	}
+}
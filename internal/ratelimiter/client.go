@@ -0,0 +1,67 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// ClientIP parses the address of a request's client. remoteAddr is usually
+// host:port, but middleware such as chi's RealIP replaces it with a bare IP
+// taken from a proxy header, so both forms are accepted.
+func ClientIP(remoteAddr string) (netip.Addr, error) {
+	if ap, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return ap.Addr().Unmap(), nil
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	addr, err := netip.ParseAddr(remoteAddr)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("ratelimiter: invalid client address %q", remoteAddr)
+	}
+	return addr.WithZone("").Unmap(), nil
+}
+
+// IPKey returns the rate limit key for addr. IPv6 clients usually control a
+// whole /64, so their addresses are aggregated to ipv6PrefixLen bits rather
+// than being limited one address at a time.
+func IPKey(addr netip.Addr, ipv6PrefixLen int) string {
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, err := addr.Prefix(ipv6PrefixLen)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.String()
+}
+
+// Allowlist holds the networks that are never rate limited.
+type Allowlist []netip.Prefix
+
+func ParseAllowlist(cidrs []string) (Allowlist, error) {
+	list := make(Allowlist, 0, len(cidrs))
+	for _, s := range cidrs {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			// Accept a bare address as a single host network.
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				return nil, fmt.Errorf("ratelimiter: invalid allowlist entry %q", s)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		list = append(list, prefix.Masked())
+	}
+	return list, nil
+}
+
+func (a Allowlist) Contains(addr netip.Addr) bool {
+	for _, prefix := range a {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package ratelimiter
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       string
+	}{
+		{name: "IPv4 with port", remoteAddr: "203.0.113.7:52144", want: "203.0.113.7"},
+		{name: "IPv4 from RealIP", remoteAddr: "203.0.113.7", want: "203.0.113.7"},
+		{name: "IPv4-mapped IPv6", remoteAddr: "[::ffff:203.0.113.7]:443", want: "203.0.113.7"},
+		{name: "IPv6 with port", remoteAddr: "[2001:db8:1:2:3:4:5:6]:52144", want: "2001:db8:1:2::/64"},
+		{name: "IPv6 from RealIP", remoteAddr: "2001:db8:1:2:ffff::1", want: "2001:db8:1:2::/64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := ClientIP(tt.remoteAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, IPKey(addr, 64), tt.want)
+		})
+	}
+
+	if _, err := ClientIP("not-an-ip:80"); err == nil {
+		t.Error("expected an error for a hostname")
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	list, err := ParseAllowlist([]string{"198.51.100.0/24", "2001:db8:ffff::/48", "192.0.2.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "198.51.100.25", want: true},
+		{addr: "198.51.101.25", want: false},
+		{addr: "2001:db8:ffff:12::1", want: true},
+		{addr: "2001:db8:fffe::1", want: false},
+		{addr: "192.0.2.10", want: true},
+		{addr: "192.0.2.11", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, list.Contains(netip.MustParseAddr(tt.addr)), tt.want)
+		})
+	}
+
+	if _, err := ParseAllowlist([]string{"office"}); err == nil {
+		t.Error("expected an error for an invalid entry")
+	}
+}
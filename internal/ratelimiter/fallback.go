@@ -0,0 +1,220 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// What a FallbackLimiter does while its primary limiter is failing.
+const (
+	// OnErrorLocal counts requests in process memory instead.
+	OnErrorLocal = "local"
+	// OnErrorOpen lets every request through.
+	OnErrorOpen = "open"
+	// OnErrorClosed rejects every request.
+	OnErrorClosed = "closed"
+)
+
+type FallbackConfig struct {
+	OnError string
+	// FailureThreshold consecutive errors open the circuit, after which the
+	// primary limiter is not called again until Cooldown has passed.
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+func (c FallbackConfig) Validate() error {
+	switch c.OnError {
+	case OnErrorLocal, OnErrorOpen, OnErrorClosed:
+	default:
+		return fmt.Errorf("ratelimiter: unknown on error behaviour %q", c.OnError)
+	}
+	if c.FailureThreshold < 1 {
+		return errors.New("ratelimiter: failure threshold must be positive")
+	}
+	if c.Cooldown <= 0 {
+		return errors.New("ratelimiter: cooldown must be positive")
+	}
+	return nil
+}
+
+// FallbackLimiter wraps a limiter backed by a remote store, usually Redis,
+// with a circuit breaker so an outage of the store does not fail every
+// request.
+type FallbackLimiter struct {
+	name    string
+	primary Limiter
+	local   *MemoryRateLimiter
+	onError string
+	breaker *breaker
+}
+
+// NewFallbackLimiter wraps primary. name identifies the limiter in the logs
+// written when the circuit opens or closes.
+func NewFallbackLimiter(name string, primary Limiter, cfg FallbackConfig, logger *slog.Logger) (*FallbackLimiter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	local, err := NewMemoryRateLimiter(primary.Config())
+	if err != nil {
+		return nil, err
+	}
+	f := &FallbackLimiter{
+		name:    name,
+		primary: primary,
+		local:   local,
+		onError: cfg.OnError,
+		breaker: &breaker{threshold: cfg.FailureThreshold, cooldown: cfg.Cooldown, now: time.Now},
+	}
+	f.breaker.onChange = func(from, to breakerState, err error) {
+		attrs := []any{slog.String("limiter", name), slog.String("from", from.String()), slog.String("to", to.String())}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		switch to {
+		case breakerOpen:
+			logger.Error("rate limiter store unavailable, using fallback", append(attrs, slog.String("on_error", cfg.OnError))...)
+		case breakerHalfOpen:
+			logger.Info("rate limiter store probe", attrs...)
+		case breakerClosed:
+			logger.Info("rate limiter store recovered", attrs...)
+		}
+	}
+	return f, nil
+}
+
+func (f *FallbackLimiter) Config() Config {
+	return f.primary.Config()
+}
+
+func (f *FallbackLimiter) SetConfig(cfg Config) error {
+	if err := f.primary.SetConfig(cfg); err != nil {
+		return err
+	}
+	return f.local.SetConfig(cfg)
+}
+
+func (f *FallbackLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	if f.breaker.allow() {
+		res, err := f.primary.Allow(ctx, key)
+		if err == nil {
+			f.breaker.success()
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			// The client went away; that says nothing about the store.
+			f.breaker.cancel()
+			return Result{}, err
+		}
+		f.breaker.failure(err)
+	}
+
+	cfg := f.Config()
+	switch f.onError {
+	case OnErrorOpen:
+		return Result{Allowed: true, Limit: cfg.RequestsPerTimeFrame, Remaining: cfg.RequestsPerTimeFrame}, nil
+	case OnErrorClosed:
+		retryAfter := f.breaker.retryAfter()
+		return Result{Limit: cfg.RequestsPerTimeFrame, RetryAfter: retryAfter, ResetAfter: retryAfter}, nil
+	default:
+		return f.local.Allow(ctx, key)
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker is a consecutive-failure circuit breaker. Once open it lets a
+// single probe through every cooldown; the probe's outcome closes the
+// circuit or keeps it open.
+type breaker struct {
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	// changedAt is when the circuit opened or, while half-open, when the
+	// probe was sent.
+	changedAt time.Time
+	now       func() time.Time
+	onChange  func(from, to breakerState, err error)
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerClosed:
+		return true
+	default:
+		if b.now().Sub(b.changedAt) < b.cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen, nil)
+		return true
+	}
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.state != breakerClosed {
+		b.setState(breakerClosed, nil)
+	}
+}
+
+func (b *breaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.setState(breakerOpen, err)
+	}
+}
+
+// cancel gives up on a probe without judging the store, so the next request
+// may probe again straight away.
+func (b *breaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.changedAt = b.now().Add(-b.cooldown)
+	}
+}
+
+// retryAfter is how long until the next probe.
+func (b *breaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(b.cooldown-b.now().Sub(b.changedAt), time.Second)
+}
+
+func (b *breaker) setState(to breakerState, err error) {
+	from := b.state
+	b.state = to
+	b.changedAt = b.now()
+	if b.onChange != nil {
+		b.onChange(from, to, err)
+	}
+}
@@ -0,0 +1,122 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+// flakyLimiter allows every request, or fails them all while down is set.
+type flakyLimiter struct {
+	settings
+	down  bool
+	calls int
+}
+
+func (f *flakyLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	f.calls++
+	if f.down {
+		return Result{}, errors.New("connection refused")
+	}
+	return Result{Allowed: true, Limit: 100, Remaining: 99}, nil
+}
+
+func newTestFallbackLimiter(t *testing.T, onError string) (*FallbackLimiter, *flakyLimiter, *time.Time) {
+	t.Helper()
+	primary := &flakyLimiter{}
+	if err := primary.SetConfig(Config{RequestsPerTimeFrame: 1, Timeframe: time.Minute, Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := FallbackConfig{OnError: onError, FailureThreshold: 2, Cooldown: 10 * time.Second}
+	f, err := NewFallbackLimiter("test", primary, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 6, 12, 0, 0, 0, time.UTC)
+	f.breaker.now = func() time.Time { return now }
+	f.local.now = f.breaker.now
+	return f, primary, &now
+}
+
+func TestFallbackLimiterBreaker(t *testing.T) {
+	f, primary, now := newTestFallbackLimiter(t, OnErrorLocal)
+	ctx := context.Background()
+
+	res, err := f.Allow(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Remaining, 99)
+
+	primary.down = true
+	for range 2 {
+		if _, err := f.Allow(ctx, "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, f.breaker.state, breakerOpen)
+	assert.Equal(t, primary.calls, 3)
+
+	// While open the primary is not called and the local limit of one
+	// request per minute applies; "b" already used it during the failures.
+	res, _ = f.Allow(ctx, "b")
+	assert.Equal(t, res.Allowed, false)
+	assert.Equal(t, primary.calls, 3)
+
+	// After the cooldown a single probe goes through. It fails, so the
+	// circuit stays open for another cooldown.
+	*now = now.Add(10 * time.Second)
+	f.Allow(ctx, "c")
+	assert.Equal(t, primary.calls, 4)
+	assert.Equal(t, f.breaker.state, breakerOpen)
+	f.Allow(ctx, "c")
+	assert.Equal(t, primary.calls, 4)
+
+	primary.down = false
+	*now = now.Add(10 * time.Second)
+	res, _ = f.Allow(ctx, "c")
+	assert.Equal(t, res.Remaining, 99)
+	assert.Equal(t, f.breaker.state, breakerClosed)
+}
+
+func TestFallbackLimiterOnError(t *testing.T) {
+	tests := []struct {
+		onError     string
+		wantAllowed bool
+	}{
+		{onError: OnErrorOpen, wantAllowed: true},
+		{onError: OnErrorClosed, wantAllowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.onError, func(t *testing.T) {
+			f, primary, _ := newTestFallbackLimiter(t, tt.onError)
+			primary.down = true
+			for range 3 {
+				res, err := f.Allow(context.Background(), "a")
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, res.Allowed, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestFallbackLimiterCanceled(t *testing.T) {
+	f, primary, _ := newTestFallbackLimiter(t, OnErrorLocal)
+	primary.down = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for range 3 {
+		if _, err := f.Allow(ctx, "a"); err == nil {
+			t.Fatal("expected an error for a canceled request")
+		}
+	}
+	assert.Equal(t, f.breaker.state, breakerClosed)
+}
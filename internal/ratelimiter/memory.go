@@ -0,0 +1,94 @@
+package ratelimiter
+
+import (
+	"context"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+const memoryShards = 32
+
+// MemoryRateLimiter is a fixed window limiter kept in process memory. Counts
+// are not shared between replicas, so it suits a single instance or a
+// fallback while Redis is unavailable.
+type MemoryRateLimiter struct {
+	settings
+	seed   maphash.Seed
+	shards [memoryShards]memoryShard
+	now    func() time.Time
+
+	sweepMu   sync.Mutex
+	nextSweep time.Time
+}
+
+type memoryShard struct {
+	mu      sync.Mutex
+	windows map[string]memoryWindow
+}
+
+type memoryWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+func NewMemoryRateLimiter(cfg Config) (*MemoryRateLimiter, error) {
+	rl := &MemoryRateLimiter{seed: maphash.MakeSeed(), now: time.Now}
+	for i := range rl.shards {
+		rl.shards[i].windows = make(map[string]memoryWindow)
+	}
+	if err := rl.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+func (m *MemoryRateLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	cfg := m.Config()
+	now := m.now()
+	m.sweep(now, cfg.Timeframe)
+
+	shard := &m.shards[maphash.String(m.seed, key)%memoryShards]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	w, ok := shard.windows[key]
+	if !ok || !now.Before(w.resetAt) {
+		w = memoryWindow{resetAt: now.Add(cfg.Timeframe)}
+	}
+	res := Result{Limit: cfg.RequestsPerTimeFrame, ResetAfter: w.resetAt.Sub(now)}
+	if w.count >= cfg.RequestsPerTimeFrame {
+		res.RetryAfter = res.ResetAfter
+		return res, nil
+	}
+	w.count++
+	shard.windows[key] = w
+	res.Allowed = true
+	res.Remaining = cfg.RequestsPerTimeFrame - w.count
+	return res, nil
+}
+
+// sweep drops expired windows at most once per time frame, so memory does
+// not grow with every client ever seen. Callers that find a sweep already
+// running carry on without waiting for it.
+func (m *MemoryRateLimiter) sweep(now time.Time, every time.Duration) {
+	if !m.sweepMu.TryLock() {
+		return
+	}
+	defer m.sweepMu.Unlock()
+	if now.Before(m.nextSweep) {
+		return
+	}
+	m.nextSweep = now.Add(every)
+
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mu.Lock()
+		for k, w := range shard.windows {
+			if !now.Before(w.resetAt) {
+				delete(shard.windows, k)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
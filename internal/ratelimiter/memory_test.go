@@ -0,0 +1,50 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	rl, err := NewMemoryRateLimiter(Config{RequestsPerTimeFrame: 2, Timeframe: time.Minute, Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 6, 12, 0, 0, 0, time.UTC)
+	rl.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	allow := func(key string) Result {
+		t.Helper()
+		res, err := rl.Allow(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	assert.Equal(t, allow("a").Remaining, 1)
+	assert.Equal(t, allow("a").Remaining, 0)
+	res := allow("a")
+	assert.Equal(t, res.Allowed, false)
+	assert.Equal(t, res.RetryAfter, time.Minute)
+	assert.Equal(t, allow("b").Allowed, true)
+
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, allow("a").RetryAfter, 30*time.Second)
+
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, allow("a").Allowed, true)
+
+	// The next call after a full time frame drops the windows that ended.
+	now = now.Add(2 * time.Minute)
+	allow("c")
+	total := 0
+	for i := range rl.shards {
+		total += len(rl.shards[i].windows)
+	}
+	assert.Equal(t, total, 1)
+}
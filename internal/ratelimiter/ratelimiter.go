@@ -2,15 +2,105 @@ package ratelimiter
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/store/cache"
+)
+
+const (
+	StrategyFixedWindow          = "fixed-window"
+	StrategySlidingWindowLog     = "sliding-window-log"
+	StrategySlidingWindowCounter = "sliding-window-counter"
+	StrategyGCRA                 = "gcra"
+	StrategyMemory               = "memory"
 )
 
-// type Config struct {
-// 	RequestsPerTimeFrame int
-// 	Timeframe            time.Duration
-// 	Enabled              bool
-// }
+// Config is read on every call to Allow, so it can be changed with
+// SetConfig while the server is running.
+type Config struct {
+	RequestsPerTimeFrame int
+	Timeframe            time.Duration
+	// Burst is how many requests GCRA lets through at once. Zero means
+	// RequestsPerTimeFrame. The other strategies ignore it.
+	Burst   int
+	Enabled bool
+}
+
+func (c Config) Validate() error {
+	if c.RequestsPerTimeFrame < 1 {
+		return errors.New("ratelimiter: requests per time frame must be positive")
+	}
+	if c.Timeframe < time.Second {
+		return errors.New("ratelimiter: time frame must be at least 1s")
+	}
+	if c.Burst < 0 {
+		return errors.New("ratelimiter: burst must not be negative")
+	}
+	return nil
+}
+
+// Result is the outcome of a call to Allow and the quota left for the key.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long a rejected client has to wait.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the full quota is available again.
+	ResetAfter time.Duration
+}
+
+func newResult(limit int, res cache.RateLimitResult) Result {
+	return Result{
+		Allowed:    res.Allowed,
+		Limit:      limit,
+		Remaining:  res.Remaining,
+		RetryAfter: res.RetryAfter,
+		ResetAfter: res.ResetAfter,
+	}
+}
 
 type Limiter interface {
-	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+	Allow(ctx context.Context, key string) (Result, error)
+	Config() Config
+	SetConfig(Config) error
+}
+
+// New returns the limiter for strategy. Every strategy except memory is
+// backed by Redis.
+func New(strategy string, store cache.Storage, cfg Config) (Limiter, error) {
+	switch strategy {
+	case StrategyFixedWindow:
+		return NewRedisFixedWindowRateLimiter(store, cfg)
+	case StrategySlidingWindowLog:
+		return NewRedisSlidingWindowLogRateLimiter(store, cfg)
+	case StrategySlidingWindowCounter:
+		return NewRedisSlidingWindowCounterRateLimiter(store, cfg)
+	case StrategyGCRA:
+		return NewRedisGCRARateLimiter(store, cfg)
+	case StrategyMemory:
+		return NewMemoryRateLimiter(cfg)
+	default:
+		return nil, fmt.Errorf("ratelimiter: unknown strategy %q", strategy)
+	}
+}
+
+// settings holds the Config shared by every limiter implementation.
+type settings struct {
+	cfg atomic.Pointer[Config]
+}
+
+func (s *settings) Config() Config {
+	return *s.cfg.Load()
+}
+
+func (s *settings) SetConfig(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.cfg.Store(&cfg)
+	return nil
 }
@@ -3,42 +3,33 @@ package ratelimiter
 import (
 	"context"
 	"fmt"
-	"log/slog"
-	"time"
 
 	"github.com/theluminousartemis/snippetbin/internal/store/cache"
 )
 
+// RedisFixedWindowRateLimiter counts requests in consecutive windows. It is
+// the cheapest strategy but lets up to twice the limit through around a
+// window boundary.
 type RedisFixedWindowRateLimiter struct {
-	store  cache.Storage
-	limit  int
-	window time.Duration
+	settings
+	store cache.Storage
 }
 
-func NewRedisFixedWindowRateLimiter(store cache.Storage, limit int, window time.Duration) *RedisFixedWindowRateLimiter {
-	return &RedisFixedWindowRateLimiter{
-		store:  store,
-		limit:  limit,
-		window: window,
+func NewRedisFixedWindowRateLimiter(store cache.Storage, cfg Config) (*RedisFixedWindowRateLimiter, error) {
+	rl := &RedisFixedWindowRateLimiter{store: store}
+	if err := rl.SetConfig(cfg); err != nil {
+		return nil, err
 	}
+	return rl, nil
 }
 
-func (r *RedisFixedWindowRateLimiter) Allow(ctx context.Context, ip string) (bool, time.Duration, error) {
-	slog.Info(ip)
-	key := fmt.Sprintf("ratelimit:%s", ip)
+func (r *RedisFixedWindowRateLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	cfg := r.Config()
+	key = fmt.Sprintf("ratelimit:%s", key)
 
-	count, err := r.store.RedisRateLimit.Increment(ctx, key)
+	res, err := r.store.RedisRateLimit.FixedWindow(ctx, key, cfg.RequestsPerTimeFrame, cfg.Timeframe)
 	if err != nil {
-		return false, 0, err
+		return Result{}, err
 	}
-
-	if count > r.limit {
-		ttl, err := r.store.RedisRateLimit.TTL(ctx, key)
-		if err != nil {
-			return false, 0, err
-		}
-		return false, ttl, nil
-	}
-
-	return true, 0, nil
+	return newResult(cfg.RequestsPerTimeFrame, res), nil
 }
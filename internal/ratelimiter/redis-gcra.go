@@ -0,0 +1,39 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/theluminousartemis/snippetbin/internal/store/cache"
+)
+
+// RedisGCRARateLimiter is a token bucket implemented with the generic cell
+// rate algorithm: requests refill at RequestsPerTimeFrame per Timeframe and
+// up to Burst can be spent at once. It stores a single timestamp per client.
+type RedisGCRARateLimiter struct {
+	settings
+	store cache.Storage
+}
+
+func NewRedisGCRARateLimiter(store cache.Storage, cfg Config) (*RedisGCRARateLimiter, error) {
+	rl := &RedisGCRARateLimiter{store: store}
+	if err := rl.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+func (r *RedisGCRARateLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	cfg := r.Config()
+	key = fmt.Sprintf("ratelimit:gcra:%s", key)
+
+	burst := cfg.Burst
+	if burst == 0 {
+		burst = cfg.RequestsPerTimeFrame
+	}
+	res, err := r.store.RedisRateLimit.GCRA(ctx, key, cfg.RequestsPerTimeFrame, cfg.Timeframe, burst)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(burst, res), nil
+}
@@ -0,0 +1,35 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/theluminousartemis/snippetbin/internal/store/cache"
+)
+
+// RedisSlidingWindowCounterRateLimiter approximates a sliding window from
+// the counts of the current and previous fixed windows, using two counters
+// per client.
+type RedisSlidingWindowCounterRateLimiter struct {
+	settings
+	store cache.Storage
+}
+
+func NewRedisSlidingWindowCounterRateLimiter(store cache.Storage, cfg Config) (*RedisSlidingWindowCounterRateLimiter, error) {
+	rl := &RedisSlidingWindowCounterRateLimiter{store: store}
+	if err := rl.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+func (r *RedisSlidingWindowCounterRateLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	cfg := r.Config()
+	key = fmt.Sprintf("ratelimit:swc:%s", key)
+
+	res, err := r.store.RedisRateLimit.SlidingWindowCounter(ctx, key, cfg.RequestsPerTimeFrame, cfg.Timeframe)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(cfg.RequestsPerTimeFrame, res), nil
+}
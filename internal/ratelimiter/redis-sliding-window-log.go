@@ -0,0 +1,34 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/theluminousartemis/snippetbin/internal/store/cache"
+)
+
+// RedisSlidingWindowLogRateLimiter keeps the timestamp of every request in
+// the window. It is exact, at the cost of memory proportional to the limit.
+type RedisSlidingWindowLogRateLimiter struct {
+	settings
+	store cache.Storage
+}
+
+func NewRedisSlidingWindowLogRateLimiter(store cache.Storage, cfg Config) (*RedisSlidingWindowLogRateLimiter, error) {
+	rl := &RedisSlidingWindowLogRateLimiter{store: store}
+	if err := rl.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+func (r *RedisSlidingWindowLogRateLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	cfg := r.Config()
+	key = fmt.Sprintf("ratelimit:swl:%s", key)
+
+	res, err := r.store.RedisRateLimit.SlidingWindowLog(ctx, key, cfg.RequestsPerTimeFrame, cfg.Timeframe)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(cfg.RequestsPerTimeFrame, res), nil
+}
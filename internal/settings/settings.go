@@ -0,0 +1,328 @@
+// Package settings loads configuration from, in increasing order of
+// precedence, built-in defaults, a YAML or TOML file, environment variables
+// and command-line flags. Unlike os.Getenv based loading, a value that fails
+// to parse is an error rather than a silent fallback to the default.
+package settings
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+type variable struct {
+	key    string
+	env    string
+	usage  string
+	set    func(string) error
+	get    func() string
+	redact func(string) string
+
+	flagValue *string
+}
+
+// Loader collects variables bound to Go values and fills them in Load.
+type Loader struct {
+	name string
+	vars []*variable
+	keys map[string]*variable
+
+	configFile  string
+	printConfig bool
+}
+
+func New(name string) *Loader {
+	return &Loader{name: name, keys: make(map[string]*variable)}
+}
+
+func (l *Loader) add(v *variable) {
+	if _, ok := l.keys[v.key]; ok {
+		panic("settings: duplicate key " + v.key)
+	}
+	l.keys[v.key] = v
+	l.vars = append(l.vars, v)
+}
+
+func (l *Loader) String(p *string, key, env, def, usage string) {
+	*p = def
+	l.add(&variable{
+		key: key, env: env, usage: usage,
+		set: func(s string) error { *p = s; return nil },
+		get: func() string { return *p },
+	})
+}
+
+// Secret is a string that is never printed.
+func (l *Loader) Secret(p *string, key, env, def, usage string) {
+	l.String(p, key, env, def, usage)
+	l.keys[key].redact = func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return redacted
+	}
+}
+
+// URL is a string holding a URL or DSN whose password is never printed.
+func (l *Loader) URL(p *string, key, env, def, usage string) {
+	l.String(p, key, env, def, usage)
+	l.keys[key].redact = func(s string) string {
+		u, err := url.Parse(s)
+		if err != nil {
+			return redacted
+		}
+		return u.Redacted()
+	}
+}
+
+func (l *Loader) Int(p *int, key, env string, def int, usage string) {
+	*p = def
+	l.add(&variable{
+		key: key, env: env, usage: usage,
+		set: func(s string) error {
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				return errors.New("not an integer")
+			}
+			*p = v
+			return nil
+		},
+		get: func() string { return strconv.Itoa(*p) },
+	})
+}
+
+func (l *Loader) Float(p *float64, key, env string, def float64, usage string) {
+	*p = def
+	l.add(&variable{
+		key: key, env: env, usage: usage,
+		set: func(s string) error {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return errors.New("not a number")
+			}
+			*p = v
+			return nil
+		},
+		get: func() string { return strconv.FormatFloat(*p, 'g', -1, 64) },
+	})
+}
+
+func (l *Loader) Bool(p *bool, key, env string, def bool, usage string) {
+	*p = def
+	l.add(&variable{
+		key: key, env: env, usage: usage,
+		set: func(s string) error {
+			v, err := strconv.ParseBool(s)
+			if err != nil {
+				return errors.New("not a boolean")
+			}
+			*p = v
+			return nil
+		},
+		get: func() string { return strconv.FormatBool(*p) },
+	})
+}
+
+func (l *Loader) Duration(p *time.Duration, key, env string, def time.Duration, usage string) {
+	*p = def
+	l.add(&variable{
+		key: key, env: env, usage: usage,
+		set: func(s string) error {
+			v, err := time.ParseDuration(s)
+			if err != nil {
+				return errors.New("not a duration")
+			}
+			*p = v
+			return nil
+		},
+		get: func() string { return p.String() },
+	})
+}
+
+// StringSlice is a comma separated list of strings.
+func (l *Loader) StringSlice(p *[]string, key, env string, def []string, usage string) {
+	*p = def
+	l.add(&variable{
+		key: key, env: env, usage: usage,
+		set: func(s string) error {
+			var vals []string
+			for _, v := range strings.Split(s, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					vals = append(vals, v)
+				}
+			}
+			*p = vals
+			return nil
+		},
+		get: func() string { return strings.Join(*p, ",") },
+	})
+}
+
+// Load applies the config file, the environment and args on top of the
+// defaults, and returns the arguments left after the flags. lookupEnv is
+// normally os.LookupEnv.
+func (l *Loader) Load(args []string, lookupEnv func(string) (string, bool)) ([]string, error) {
+	fs := flag.NewFlagSet(l.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&l.configFile, "config", "", "path to a YAML or TOML config file (env CONFIG_FILE)")
+	fs.BoolVar(&l.printConfig, "print-config", false, "print the effective configuration and exit")
+	for _, v := range l.vars {
+		fs.Func(v.key, v.usage, func(s string) error {
+			v.flagValue = &s
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if l.configFile == "" {
+		l.configFile, _ = lookupEnv("CONFIG_FILE")
+	}
+	if l.configFile != "" {
+		if err := l.loadFile(l.configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, v := range l.vars {
+		if v.env == "" {
+			continue
+		}
+		if s, ok := lookupEnv(v.env); ok {
+			if err := v.set(s); err != nil {
+				errs = append(errs, fmt.Errorf("env %s=%q: %w", v.env, s, err))
+			}
+		}
+	}
+	for _, v := range l.vars {
+		if v.flagValue != nil {
+			if err := v.set(*v.flagValue); err != nil {
+				errs = append(errs, fmt.Errorf("flag -%s=%q: %w", v.key, *v.flagValue, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return fs.Args(), nil
+}
+
+// PrintRequested reports whether -print-config was passed.
+func (l *Loader) PrintRequested() bool {
+	return l.printConfig
+}
+
+func (l *Loader) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("config file %s: unsupported extension %q", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", raw, values)
+
+	var errs []error
+	for key, s := range values {
+		v, ok := l.keys[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("config file %s: unknown key %q", path, key))
+			continue
+		}
+		if err := v.set(s); err != nil {
+			errs = append(errs, fmt.Errorf("config file %s: %s=%q: %w", path, key, s, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func flatten(prefix string, in map[string]any, out map[string]string) {
+	for k, val := range in {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch val := val.(type) {
+		case map[string]any:
+			flatten(key, val, out)
+		case []any:
+			parts := make([]string, len(val))
+			for i, item := range val {
+				parts[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(parts, ",")
+		case nil:
+			out[key] = ""
+		default:
+			out[key] = fmt.Sprint(val)
+		}
+	}
+}
+
+// Print writes every setting as key=value, sorted by key, with secrets
+// redacted.
+func (l *Loader) Print(w io.Writer) {
+	for _, attr := range l.Attrs() {
+		fmt.Fprintf(w, "%s=%s\n", attr.Key, attr.Value)
+	}
+}
+
+// Attrs returns every setting, sorted by key, with secrets redacted, for
+// logging the effective configuration at startup.
+func (l *Loader) Attrs() []slog.Attr {
+	vars := make([]*variable, len(l.vars))
+	copy(vars, l.vars)
+	sort.Slice(vars, func(i, j int) bool { return vars[i].key < vars[j].key })
+
+	attrs := make([]slog.Attr, len(vars))
+	for i, v := range vars {
+		s := v.get()
+		if v.redact != nil {
+			s = v.redact(s)
+		}
+		attrs[i] = slog.String(v.key, s)
+	}
+	return attrs
+}
+
+// Usage writes the flags, their environment variables and defaults to w.
+func (l *Loader) Usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage of %s:\n", l.name)
+	fmt.Fprintf(w, "  -config string\n    \tpath to a YAML or TOML config file (env CONFIG_FILE)\n")
+	fmt.Fprintf(w, "  -print-config\n    \tprint the effective configuration and exit\n")
+	for _, v := range l.vars {
+		def := v.get()
+		if v.redact != nil {
+			def = v.redact(def)
+		}
+		fmt.Fprintf(w, "  -%s\n    \t%s (env %s, default %q)\n", v.key, v.usage, v.env, def)
+	}
+}
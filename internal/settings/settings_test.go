@@ -0,0 +1,176 @@
+package settings
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+type testConfig struct {
+	addr     string
+	dsn      string
+	password string
+	conns    int
+	enabled  bool
+	timeout  time.Duration
+	hosts    []string
+}
+
+func newTestLoader(cfg *testConfig) *Loader {
+	l := New("test")
+	l.String(&cfg.addr, "addr", "ADDR", ":4000", "")
+	l.URL(&cfg.dsn, "db.addr", "DB_ADDR", "postgres://u:p@localhost/db", "")
+	l.Secret(&cfg.password, "redis.password", "REDIS_PASSWORD", "", "")
+	l.Int(&cfg.conns, "db.max_open_conns", "DB_MAX_OPEN_CONNS", 30, "")
+	l.Bool(&cfg.enabled, "ratelimiter.enabled", "RATELIMITER_ENABLED", true, "")
+	l.Duration(&cfg.timeout, "shutdown.timeout", "SHUTDOWN_TIMEOUT", 30*time.Second, "")
+	l.StringSlice(&cfg.hosts, "hosts", "HOSTS", nil, "")
+	return l
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func lookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+addr: ":5000"
+db:
+  max_open_conns: 10
+ratelimiter:
+  enabled: false
+shutdown:
+  timeout: 1m
+hosts: [a, b]
+`)
+	env := map[string]string{
+		"CONFIG_FILE":       file,
+		"DB_MAX_OPEN_CONNS": "20",
+	}
+
+	var cfg testConfig
+	l := newTestLoader(&cfg)
+	rest, err := l.Load([]string{"-shutdown.timeout", "2m", "migrate", "up"}, lookup(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, cfg.addr, ":5000")
+	assert.Equal(t, cfg.conns, 20)
+	assert.Equal(t, cfg.enabled, false)
+	assert.Equal(t, cfg.timeout, 2*time.Minute)
+	assert.Equal(t, strings.Join(cfg.hosts, "|"), "a|b")
+	assert.Equal(t, strings.Join(rest, " "), "migrate up")
+}
+
+func TestLoadTOML(t *testing.T) {
+	file := writeFile(t, "config.toml", `
+addr = ":6000"
+
+[db]
+max_open_conns = 5
+`)
+	var cfg testConfig
+	l := newTestLoader(&cfg)
+	if _, err := l.Load([]string{"-config", file}, lookup(nil)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cfg.addr, ":6000")
+	assert.Equal(t, cfg.conns, 5)
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{
+			name:    "Malformed env int",
+			env:     map[string]string{"DB_MAX_OPEN_CONNS": "3O"},
+			wantErr: `env DB_MAX_OPEN_CONNS="3O": not an integer`,
+		},
+		{
+			name:    "Malformed env bool",
+			env:     map[string]string{"RATELIMITER_ENABLED": "yes please"},
+			wantErr: "not a boolean",
+		},
+		{
+			name:    "Malformed flag duration",
+			args:    []string{"-shutdown.timeout=30"},
+			wantErr: `flag -shutdown.timeout="30": not a duration`,
+		},
+		{
+			name:    "Unknown file key",
+			file:    "db:\n  max_open_con: 3\n",
+			wantErr: `unknown key "db.max_open_con"`,
+		},
+		{
+			name:    "Malformed file value",
+			file:    "shutdown:\n  timeout: 30\n",
+			wantErr: "not a duration",
+		},
+		{
+			name:    "Unknown flag",
+			args:    []string{"-nope"},
+			wantErr: "flag provided but not defined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, "config.yml", tt.file)}, args...)
+			}
+			var cfg testConfig
+			l := newTestLoader(&cfg)
+			_, err := l.Load(args, lookup(tt.env))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			assert.StringContains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	var cfg testConfig
+	l := newTestLoader(&cfg)
+	env := map[string]string{
+		"DB_ADDR":        "postgres://admin:hunter2@db:5432/snippetbin",
+		"REDIS_PASSWORD": "swordfish",
+	}
+	if _, err := l.Load(nil, lookup(env)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	l.Print(&buf)
+	out := buf.String()
+
+	assert.StringContains(t, out, "db.addr=postgres://admin:xxxxx@db:5432/snippetbin\n")
+	assert.StringContains(t, out, "redis.password=REDACTED\n")
+	assert.StringContains(t, out, "addr=:4000\n")
+	assert.Equal(t, strings.Contains(out, "hunter2"), false)
+	assert.Equal(t, strings.Contains(out, "swordfish"), false)
+}
@@ -0,0 +1,58 @@
+package signedtoken
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+func TestSigner(t *testing.T) {
+	s, err := NewSigner([]byte(strings.Repeat("k", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 6, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	token := s.Sign("verify-email", time.Hour, "42", "alice@example.com")
+	fields, err := s.Verify("verify-email", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, strings.Join(fields, ","), "42,alice@example.com")
+
+	other, _ := NewSigner([]byte(strings.Repeat("x", 32)))
+	tampered := token[:len(token)-2] + "AA"
+	tests := []struct {
+		name    string
+		signer  *Signer
+		purpose string
+		token   string
+		wantErr error
+	}{
+		{name: "Wrong purpose", signer: s, purpose: "reset-password", token: token, wantErr: ErrInvalid},
+		{name: "Wrong key", signer: other, purpose: "verify-email", token: token, wantErr: ErrInvalid},
+		{name: "Tampered signature", signer: s, purpose: "verify-email", token: tampered, wantErr: ErrInvalid},
+		{name: "Not a token", signer: s, purpose: "verify-email", token: "hello", wantErr: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.signer.Verify(tt.purpose, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v; expected %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	now = now.Add(time.Hour + time.Second)
+	if _, err := s.Verify("verify-email", token); !errors.Is(err, ErrExpired) {
+		t.Errorf("got %v; expected %v", err, ErrExpired)
+	}
+
+	if _, err := NewSigner([]byte("short")); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
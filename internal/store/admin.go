@@ -0,0 +1,182 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Role decides what a user may do in the admin area. Moderators can look
+// users up and disable them; admins can also force password resets and
+// change roles.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Roles lists the roles from least to most privileged.
+var Roles = []Role{RoleUser, RoleModerator, RoleAdmin}
+
+func ParseRole(s string) (Role, error) {
+	r := Role(s)
+	if !slices.Contains(Roles, r) {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return r, nil
+}
+
+// AtLeast reports whether r has every permission of min.
+func (r Role) AtLeast(min Role) bool {
+	return slices.Index(Roles, r) >= slices.Index(Roles, min)
+}
+
+// UserSummary is a user as listed in the admin area.
+type UserSummary struct {
+	User
+	// Snippets is the number of unexpired snippets the user owns.
+	Snippets int
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Search returns users whose username or email contains query, or whose ID
+// is query, in the order they signed up. An empty query matches everyone.
+func (m *PostgresUserModel) Search(ctx context.Context, query string, limit, offset int) ([]UserSummary, error) {
+	stmt := `SELECT u.id, u.username, u.email, u.created_at, u.email_verified_at, u.totp_enabled_at IS NOT NULL,
+			u.role, u.disabled_at, u.password_reset_required,
+			(SELECT count(*) FROM snippets s WHERE s.user_id = u.id AND s.expires > NOW())
+		FROM users u
+		WHERE $1 = '' OR u.username ILIKE $2 OR u.email ILIKE $2 OR u.id::text = $1
+		ORDER BY u.id LIMIT $3 OFFSET $4`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	rows, err := m.DB.QueryContext(ctx, stmt, query, "%"+likeEscaper.Replace(query)+"%", limit, offset)
+	logQuery(ctx, "users.search", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []UserSummary
+	for rows.Next() {
+		var u UserSummary
+		var verifiedAt, disabledAt sql.NullTime
+		err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.CreatedAt, &verifiedAt, &u.TwoFactorEnabled,
+			&u.Role, &disabledAt, &u.PasswordResetRequired, &u.Snippets)
+		if err != nil {
+			return nil, err
+		}
+		u.EmailVerifiedAt = verifiedAt.Time
+		u.DisabledAt = disabledAt.Time
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetRole gives the user role and records the change in the audit log.
+func (m *PostgresUserModel) SetRole(ctx context.Context, id int, role Role, actorID int, ip string) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var old Role
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		start := time.Now()
+		err := tx.QueryRowContext(ctx, "SELECT role FROM users WHERE id = $1 FOR UPDATE", id).Scan(&old)
+		logQuery(ctx, "users.get_role", start, err)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+		if old == role {
+			return nil
+		}
+
+		start = time.Now()
+		_, err = tx.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, id)
+		logQuery(ctx, "users.update_role", start, err)
+		if err != nil {
+			return err
+		}
+		return insertAuditEvent(ctx, tx, &AuditEvent{UserID: id, ActorID: actorID, Action: AuditRoleChanged, OldValue: string(old), NewValue: string(role), IP: ip})
+	})
+}
+
+// SetDisabled disables or re-enables the user and records the change in
+// the audit log. Disabling also logs the user out everywhere.
+func (m *PostgresUserModel) SetDisabled(ctx context.Context, id int, disabled bool, actorID int, ip string) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var wasDisabled bool
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		start := time.Now()
+		err := tx.QueryRowContext(ctx, "SELECT disabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE", id).Scan(&wasDisabled)
+		logQuery(ctx, "users.get_disabled", start, err)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+		if wasDisabled == disabled {
+			return nil
+		}
+
+		start = time.Now()
+		_, err = tx.ExecContext(ctx, "UPDATE users SET disabled_at = CASE WHEN $1 THEN NOW() END WHERE id = $2", disabled, id)
+		logQuery(ctx, "users.update_disabled", start, err)
+		if err != nil {
+			return err
+		}
+		action := AuditEnabled
+		if disabled {
+			action = AuditDisabled
+			start = time.Now()
+			_, err = tx.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = $1", id)
+			logQuery(ctx, "user_sessions.delete_for_user", start, err)
+			if err != nil {
+				return err
+			}
+		}
+		return insertAuditEvent(ctx, tx, &AuditEvent{UserID: id, ActorID: actorID, Action: action, IP: ip})
+	})
+}
+
+// RequirePasswordReset stops the user's password from logging them in
+// until they reset it, logs them out everywhere and records the change in
+// the audit log.
+func (m *PostgresUserModel) RequirePasswordReset(ctx context.Context, id int, actorID int, ip string) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		start := time.Now()
+		res, err := tx.ExecContext(ctx, "UPDATE users SET password_reset_required = true WHERE id = $1", id)
+		logQuery(ctx, "users.require_password_reset", start, err)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNoRecord
+		}
+
+		start = time.Now()
+		_, err = tx.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = $1", id)
+		logQuery(ctx, "user_sessions.delete_for_user", start, err)
+		if err != nil {
+			return err
+		}
+		return insertAuditEvent(ctx, tx, &AuditEvent{UserID: id, ActorID: actorID, Action: AuditPasswordResetForced, IP: ip})
+	})
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditUsernameChanged      = "username_changed"
+	AuditEmailChangeRequested = "email_change_requested"
+	AuditEmailChanged         = "email_changed"
+	AuditRoleChanged          = "role_changed"
+	AuditDisabled             = "disabled"
+	AuditEnabled              = "enabled"
+	AuditPasswordResetForced  = "password_reset_forced"
+	AuditUnlocked             = "unlocked"
+	// AuditIPUnlocked is recorded for the admin who unlocked the client IP
+	// in NewValue, as the IP belongs to no account.
+	AuditIPUnlocked = "ip_unlocked"
+)
+
+// AuditEvent is one change to a user's account details.
+type AuditEvent struct {
+	UserID int
+	// ActorID is the moderator or admin who made the change, or 0 if the
+	// user made it themselves or it was made from the command line.
+	ActorID   int
+	Action    string
+	OldValue  string
+	NewValue  string
+	IP        string
+	CreatedAt time.Time
+}
+
+// PostgresAuditModel reads the audit log. Events are written by the
+// models that make the changes, in the same transaction.
+type PostgresAuditModel struct {
+	DB *sql.DB
+}
+
+// Insert records an event that is not part of another change, such as a
+// request that still has to be confirmed.
+func (m *PostgresAuditModel) Insert(ctx context.Context, e *AuditEvent) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return insertAuditEvent(ctx, tx, e)
+	})
+}
+
+// ListByUser returns the user's audit log, newest first.
+func (m *PostgresAuditModel) ListByUser(ctx context.Context, userID int) ([]AuditEvent, error) {
+	stmt := `SELECT COALESCE(actor_id, 0), action, old_value, new_value, ip, created_at FROM user_audit_log
+		WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	logQuery(ctx, "user_audit_log.list_by_user", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		e := AuditEvent{UserID: userID}
+		if err := rows.Scan(&e.ActorID, &e.Action, &e.OldValue, &e.NewValue, &e.IP, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func insertAuditEvent(ctx context.Context, tx *sql.Tx, e *AuditEvent) error {
+	stmt := `INSERT INTO user_audit_log (user_id, actor_id, action, old_value, new_value, ip)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6) RETURNING created_at`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	err := tx.QueryRowContext(ctx, stmt, e.UserID, e.ActorID, e.Action, e.OldValue, e.NewValue, e.IP).Scan(&e.CreatedAt)
+	logQuery(ctx, "user_audit_log.insert", start, err)
+	return err
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LoginAttemptsRedisStore counts failed logins for a subject, such as an
+// account or a client IP, and blocks subjects from trying again for a while.
+type LoginAttemptsRedisStore struct {
+	rdb *redis.Client
+}
+
+func failuresKey(subject string) string { return "login:failures:" + subject }
+func blockedKey(subject string) string  { return "login:blocked:" + subject }
+
+// The counter expires window after the first failure, so failures are
+// forgotten in batches rather than one by one.
+var recordFailureScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// A block never shortens an existing longer one.
+var blockScript = redis.NewScript(`
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], '1', 'PX', ARGV[1])
+end
+return 0
+`)
+
+// RecordFailure adds a failed attempt for subject and returns the number
+// of failures in the current window.
+func (s *LoginAttemptsRedisStore) RecordFailure(ctx context.Context, subject string, window time.Duration) (int, error) {
+	return recordFailureScript.Run(ctx, s.rdb, []string{failuresKey(subject)}, window.Milliseconds()).Int()
+}
+
+func (s *LoginAttemptsRedisStore) Failures(ctx context.Context, subject string) (int, error) {
+	n, err := s.rdb.Get(ctx, failuresKey(subject)).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (s *LoginAttemptsRedisStore) Block(ctx context.Context, subject string, d time.Duration) error {
+	return blockScript.Run(ctx, s.rdb, []string{blockedKey(subject)}, d.Milliseconds()).Err()
+}
+
+// BlockedFor returns how long subject remains blocked, or zero.
+func (s *LoginAttemptsRedisStore) BlockedFor(ctx context.Context, subject string) (time.Duration, error) {
+	ttl, err := s.rdb.PTTL(ctx, blockedKey(subject)).Result()
+	if err != nil {
+		return 0, err
+	}
+	return max(ttl, 0), nil
+}
+
+// Reset forgets the failures of subject and lifts any block.
+func (s *LoginAttemptsRedisStore) Reset(ctx context.Context, subject string) error {
+	return s.rdb.Del(ctx, failuresKey(subject), blockedKey(subject)).Err()
+}
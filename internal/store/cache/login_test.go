@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+func TestLoginAttempts(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	s := &LoginAttemptsRedisStore{rdb: rdb}
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		n, err := s.RecordFailure(ctx, "account:a", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, n, i)
+	}
+	assert.Equal(t, mr.TTL("login:failures:account:a"), time.Hour)
+
+	if err := s.Block(ctx, "account:a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// A shorter block does not cut the current one short.
+	if err := s.Block(ctx, "account:a", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	d, err := s.BlockedFor(ctx, "account:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, d, time.Minute)
+
+	mr.FastForward(time.Minute)
+	d, _ = s.BlockedFor(ctx, "account:a")
+	assert.Equal(t, d, time.Duration(0))
+
+	if err := s.Block(ctx, "account:a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reset(ctx, "account:a"); err != nil {
+		t.Fatal(err)
+	}
+	n, _ := s.Failures(ctx, "account:a")
+	assert.Equal(t, n, 0)
+	d, _ = s.BlockedFor(ctx, "account:a")
+	assert.Equal(t, d, time.Duration(0))
+}
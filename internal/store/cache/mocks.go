@@ -2,24 +2,90 @@ package cache
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
 func NewMockStorage() Storage {
 	return Storage{
-		RedisRateLimit: &MockRedisRateLimit{},
+		RedisRateLimit: &MockRedisRateLimit{counts: make(map[string]int)},
+		LoginAttempts:  &MockLoginAttempts{failures: make(map[string]int), blockedUntil: make(map[string]time.Time)},
 	}
 }
 
+// MockRedisRateLimit counts every call for a key against the limit
+// regardless of algorithm. Windows never expire.
 type MockRedisRateLimit struct {
-	count int
+	mu     sync.Mutex
+	counts map[string]int
 }
 
-func (m *MockRedisRateLimit) Increment(ctx context.Context, key string) (int, error) {
-	m.count++
-	return m.count, nil
+func (m *MockRedisRateLimit) hit(key string, limit int) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[key]++
+	if m.counts[key] > limit {
+		return RateLimitResult{RetryAfter: time.Second, ResetAfter: time.Second}, nil
+	}
+	return RateLimitResult{Allowed: true, Remaining: limit - m.counts[key], ResetAfter: time.Second}, nil
+}
+
+func (m *MockRedisRateLimit) FixedWindow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	return m.hit(key, limit)
+}
+
+func (m *MockRedisRateLimit) SlidingWindowLog(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	return m.hit(key, limit)
+}
+
+func (m *MockRedisRateLimit) SlidingWindowCounter(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	return m.hit(key, limit)
+}
+
+func (m *MockRedisRateLimit) GCRA(ctx context.Context, key string, limit int, window time.Duration, burst int) (RateLimitResult, error) {
+	return m.hit(key, limit)
+}
+
+// MockLoginAttempts keeps failures in memory. Failure windows never expire;
+// blocks use the wall clock.
+type MockLoginAttempts struct {
+	mu           sync.Mutex
+	failures     map[string]int
+	blockedUntil map[string]time.Time
+}
+
+func (m *MockLoginAttempts) RecordFailure(ctx context.Context, subject string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[subject]++
+	return m.failures[subject], nil
+}
+
+func (m *MockLoginAttempts) Failures(ctx context.Context, subject string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.failures[subject], nil
+}
+
+func (m *MockLoginAttempts) Block(ctx context.Context, subject string, d time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if until := time.Now().Add(d); until.After(m.blockedUntil[subject]) {
+		m.blockedUntil[subject] = until
+	}
+	return nil
+}
+
+func (m *MockLoginAttempts) BlockedFor(ctx context.Context, subject string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return max(time.Until(m.blockedUntil[subject]), 0), nil
 }
 
-func (m *MockRedisRateLimit) TTL(ctx context.Context, key string) (time.Duration, error) {
-	return time.Second, nil
+func (m *MockLoginAttempts) Reset(ctx context.Context, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, subject)
+	delete(m.blockedUntil, subject)
+	return nil
 }
@@ -2,38 +2,167 @@ package cache
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// RateLimitResult is the outcome of counting one request against a limit.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long a rejected caller should wait before trying
+	// again. It is zero for allowed requests.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the full quota is available again.
+	ResetAfter time.Duration
+}
+
+// Every algorithm runs as a single Lua script so the read, the decision and
+// the write happen atomically in Redis. The current time is passed in by the
+// caller in milliseconds, and each script returns
+// {allowed, remaining, retry_after_ms, reset_after_ms}.
+
+var fixedWindowScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+local ttl = redis.call('PTTL', KEYS[1])
+local limit = tonumber(ARGV[2])
+if count > limit then
+	return {0, 0, ttl, ttl}
+end
+return {1, limit - count, 0, ttl}
+`)
+
+var slidingWindowLogScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count >= limit then
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	local retry = tonumber(oldest[2]) + window - now
+	return {0, 0, retry, retry}
+end
+redis.call('ZADD', KEYS[1], now, ARGV[4])
+redis.call('PEXPIRE', KEYS[1], window)
+local first = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {1, limit - count - 1, 0, tonumber(first[2]) + window - now}
+`)
+
+// slidingWindowCounterScript weights the previous fixed window's count by
+// how much of it still overlaps the sliding window. KEYS[1] is the current
+// window and KEYS[2] the previous one.
+var slidingWindowCounterScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local elapsed = now % window
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local estimate = previous * (window - elapsed) / window + current
+if estimate + 1 > limit then
+	local retry = window - elapsed
+	if current + 1 <= limit and previous > 0 then
+		local wait = math.ceil(window * (previous - (limit - current - 1)) / previous) - elapsed
+		if wait < retry then
+			retry = wait
+		end
+	end
+	if retry < 1 then
+		retry = 1
+	end
+	return {0, 0, retry, window - elapsed}
+end
+redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], window * 2)
+return {1, math.floor(limit - estimate - 1), 0, window - elapsed}
+`)
+
+// gcraScript implements the generic cell rate algorithm. The key holds the
+// theoretical arrival time (TAT) of the next request; a request is allowed
+// when it does not arrive more than the burst tolerance before the TAT.
+var gcraScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local tolerance = interval * tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local new_tat = tat + interval
+local allow_at = new_tat - tolerance
+if now < allow_at then
+	return {0, 0, math.ceil(allow_at - now), math.ceil(tat - now)}
+end
+redis.call('SET', KEYS[1], tostring(new_tat), 'PX', math.ceil(new_tat - now))
+return {1, math.floor((now - allow_at) / interval), 0, math.ceil(new_tat - now)}
+`)
+
 type RateLimitRedisStore struct {
 	rdb *redis.Client
+	now func() time.Time
 }
 
-var window time.Duration = time.Minute * 2
+func (r *RateLimitRedisStore) FixedWindow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	return r.run(ctx, fixedWindowScript, []string{key}, window.Milliseconds(), limit)
+}
 
-// func (r *RateLimitRedisStore) GetCount(ctx context.Context, key string) (int, error) {
-// 	count, err := r.rdb.Get(ctx, key).Int()
-// 	if err == redis.Nil {
-// 		return 0, nil
-// 	}
-// 	return count, err
+func (r *RateLimitRedisStore) SlidingWindowLog(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	member, err := randomMember()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return r.run(ctx, slidingWindowLogScript, []string{key}, r.nowMillis(), window.Milliseconds(), limit, member)
+}
 
-// }
-func (r *RateLimitRedisStore) Increment(ctx context.Context, key string) (int, error) {
-	count, err := r.rdb.Incr(ctx, key).Result()
+func (r *RateLimitRedisStore) SlidingWindowCounter(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+	now := r.nowMillis()
+	current := now / window.Milliseconds()
+	keys := []string{
+		fmt.Sprintf("%s:%d", key, current),
+		fmt.Sprintf("%s:%d", key, current-1),
+	}
+	return r.run(ctx, slidingWindowCounterScript, keys, now, window.Milliseconds(), limit)
+}
+
+// GCRA allows limit requests per window spread evenly, plus bursts of up to
+// burst requests at once.
+func (r *RateLimitRedisStore) GCRA(ctx context.Context, key string, limit int, window time.Duration, burst int) (RateLimitResult, error) {
+	interval := float64(window.Milliseconds()) / float64(limit)
+	return r.run(ctx, gcraScript, []string{key}, r.nowMillis(), interval, burst)
+}
+
+func (r *RateLimitRedisStore) run(ctx context.Context, script *redis.Script, keys []string, args ...any) (RateLimitResult, error) {
+	vals, err := script.Run(ctx, r.rdb, keys, args...).Int64Slice()
 	if err != nil {
-		return 0, err
+		return RateLimitResult{}, err
 	}
-	if count == 1 {
-		if err := r.rdb.Expire(ctx, key, window).Err(); err != nil {
-			return 0, err
-		}
+	if len(vals) != 4 {
+		return RateLimitResult{}, fmt.Errorf("cache: rate limit script returned %d values", len(vals))
 	}
-	return int(count), nil
+	return RateLimitResult{
+		Allowed:    vals[0] == 1,
+		Remaining:  int(max(vals[1], 0)),
+		RetryAfter: time.Duration(max(vals[2], 0)) * time.Millisecond,
+		ResetAfter: time.Duration(max(vals[3], 0)) * time.Millisecond,
+	}, nil
+}
 
+func (r *RateLimitRedisStore) nowMillis() int64 {
+	return r.now().UnixMilli()
 }
-func (r *RateLimitRedisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
-	return r.rdb.TTL(ctx, key).Result()
+
+func randomMember() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) advance(mr *miniredis.Miniredis, d time.Duration) {
+	c.now = c.now.Add(d)
+	mr.FastForward(d)
+}
+
+func newTestRateLimitStore(t *testing.T) (*RateLimitRedisStore, *miniredis.Miniredis, *testClock) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	clock := &testClock{now: time.Date(2025, 6, 6, 12, 0, 0, 0, time.UTC)}
+	return &RateLimitRedisStore{rdb: rdb, now: clock.Now}, mr, clock
+}
+
+type limitFunc func(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error)
+
+// allowed sends n requests and returns how many got through.
+func allowed(t *testing.T, fn limitFunc, n, limit int, window time.Duration) int {
+	t.Helper()
+	count := 0
+	for range n {
+		res, err := fn(context.Background(), "ratelimit:test", limit, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed {
+			count++
+		}
+	}
+	return count
+}
+
+func TestFixedWindow(t *testing.T) {
+	s, mr, clock := newTestRateLimitStore(t)
+	ctx := context.Background()
+
+	for i := range 3 {
+		res, err := s.FixedWindow(ctx, "ratelimit:test", 3, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, res.Allowed, true)
+		assert.Equal(t, res.Remaining, 2-i)
+	}
+
+	res, err := s.FixedWindow(ctx, "ratelimit:test", 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, false)
+	assert.Equal(t, res.RetryAfter, time.Minute)
+	assert.Equal(t, mr.TTL("ratelimit:test"), time.Minute)
+
+	clock.advance(mr, time.Minute)
+	assert.Equal(t, allowed(t, s.FixedWindow, 4, 3, time.Minute), 3)
+}
+
+func TestSlidingWindowLog(t *testing.T) {
+	s, mr, clock := newTestRateLimitStore(t)
+	ctx := context.Background()
+
+	assert.Equal(t, allowed(t, s.SlidingWindowLog, 2, 4, time.Minute), 2)
+	clock.advance(mr, 40*time.Second)
+	assert.Equal(t, allowed(t, s.SlidingWindowLog, 3, 4, time.Minute), 2)
+
+	// Unlike a fixed window, crossing the minute boundary does not reset
+	// the count: only the two requests made at 0s have left the window.
+	clock.advance(mr, 20*time.Second)
+	res, err := s.SlidingWindowLog(ctx, "ratelimit:test", 4, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, true)
+	assert.Equal(t, res.Remaining, 1)
+	assert.Equal(t, allowed(t, s.SlidingWindowLog, 2, 4, time.Minute), 1)
+
+	res, err = s.SlidingWindowLog(ctx, "ratelimit:test", 4, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, false)
+	assert.Equal(t, res.RetryAfter, 40*time.Second)
+}
+
+func TestSlidingWindowCounter(t *testing.T) {
+	s, mr, clock := newTestRateLimitStore(t)
+	ctx := context.Background()
+
+	// Start exactly on a window boundary.
+	clock.now = clock.now.Truncate(time.Minute)
+	assert.Equal(t, allowed(t, s.SlidingWindowCounter, 10, 10, time.Minute), 10)
+
+	// A quarter into the next window 75% of the previous count still
+	// applies, so only 2 more requests fit.
+	clock.advance(mr, 75*time.Second)
+	assert.Equal(t, allowed(t, s.SlidingWindowCounter, 5, 10, time.Minute), 2)
+
+	res, err := s.SlidingWindowCounter(ctx, "ratelimit:test", 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, false)
+	assert.Equal(t, res.RetryAfter, 3*time.Second)
+}
+
+func TestGCRA(t *testing.T) {
+	s, mr, clock := newTestRateLimitStore(t)
+	ctx := context.Background()
+	gcra := func(burst int) limitFunc {
+		return func(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error) {
+			return s.GCRA(ctx, key, limit, window, burst)
+		}
+	}
+
+	// 60 requests per minute refills one per second; a burst of 5 may be
+	// spent at once.
+	assert.Equal(t, allowed(t, gcra(5), 10, 60, time.Minute), 5)
+
+	res, err := s.GCRA(ctx, "ratelimit:test", 60, time.Minute, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, false)
+	assert.Equal(t, res.RetryAfter, time.Second)
+
+	clock.advance(mr, time.Second)
+	assert.Equal(t, allowed(t, gcra(5), 2, 60, time.Minute), 1)
+
+	clock.advance(mr, 10*time.Second)
+	res, err = s.GCRA(ctx, "ratelimit:test", 60, time.Minute, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, true)
+	assert.Equal(t, res.Remaining, 4)
+}
@@ -9,14 +9,23 @@ import (
 
 type Storage struct {
 	RedisRateLimit interface {
-		// GetCount(ctx context.Context, key string) (int, error)
-		Increment(ctx context.Context, key string) (int, error)
-		TTL(ctx context.Context, key string) (time.Duration, error)
+		FixedWindow(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error)
+		SlidingWindowLog(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error)
+		SlidingWindowCounter(ctx context.Context, key string, limit int, window time.Duration) (RateLimitResult, error)
+		GCRA(ctx context.Context, key string, limit int, window time.Duration, burst int) (RateLimitResult, error)
+	}
+	LoginAttempts interface {
+		RecordFailure(ctx context.Context, subject string, window time.Duration) (int, error)
+		Failures(ctx context.Context, subject string) (int, error)
+		Block(ctx context.Context, subject string, d time.Duration) error
+		BlockedFor(ctx context.Context, subject string) (time.Duration, error)
+		Reset(ctx context.Context, subject string) error
 	}
 }
 
 func NewRedisStore(rdb *redis.Client) Storage {
 	return Storage{
-		RedisRateLimit: &RateLimitRedisStore{rdb: rdb},
+		RedisRateLimit: &RateLimitRedisStore{rdb: rdb, now: time.Now},
+		LoginAttempts:  &LoginAttemptsRedisStore{rdb: rdb},
 	}
 }
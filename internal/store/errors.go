@@ -9,4 +9,5 @@ var (
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
 	ErrDuplicateUsername  = errors.New("models: duplicate username")
+	ErrLastOwner          = errors.New("models: organization must keep an owner")
 )
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Identity is an account at an OpenID Connect provider linked to a user.
+type Identity struct {
+	Issuer      string
+	Subject     string
+	UserID      int
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+// PostgresIdentityModel stores the accounts at OpenID Connect providers
+// that users log in with.
+type PostgresIdentityModel struct {
+	DB *sql.DB
+}
+
+// GetUserID returns the user linked to the subject at issuer, or
+// ErrNoRecord, and records the login.
+func (m *PostgresIdentityModel) GetUserID(ctx context.Context, issuer, subject string) (int, error) {
+	var id int
+	stmt := "UPDATE user_identities SET last_login_at = NOW() WHERE issuer = $1 AND subject = $2 RETURNING user_id"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, issuer, subject).Scan(&id)
+	logQuery(ctx, "user_identities.get_user_id", start, err)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNoRecord
+	}
+	return id, err
+}
+
+func (m *PostgresIdentityModel) ListByUser(ctx context.Context, userID int) ([]Identity, error) {
+	stmt := "SELECT issuer, subject, created_at, last_login_at FROM user_identities WHERE user_id = $1 ORDER BY created_at, issuer"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	logQuery(ctx, "user_identities.list_by_user", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		i := Identity{UserID: userID}
+		var lastLoginAt sql.NullTime
+		if err := rows.Scan(&i.Issuer, &i.Subject, &i.CreatedAt, &lastLoginAt); err != nil {
+			return nil, err
+		}
+		i.LastLoginAt = lastLoginAt.Time
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+// Link links the subject at issuer to an existing user. The provider has
+// vouched for the user's email address, so it is marked verified too.
+func (m *PostgresIdentityModel) Link(ctx context.Context, issuer, subject string, userID int) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		stmt := `INSERT INTO user_identities (issuer, subject, user_id, last_login_at) VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (issuer, subject) DO NOTHING`
+		start := time.Now()
+		_, err := tx.ExecContext(ctx, stmt, issuer, subject, userID)
+		logQuery(ctx, "user_identities.insert", start, err)
+		if err != nil {
+			return err
+		}
+		start = time.Now()
+		res, err := tx.ExecContext(ctx, "UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1", userID)
+		logQuery(ctx, "users.mark_email_verified", start, err)
+		return checkAffected(res, err)
+	})
+}
+
+// InsertUser creates a user with a verified email address for the subject
+// at issuer. Like Users.Insert it returns ErrDuplicateEmail or
+// ErrDuplicateUsername if either is taken.
+func (m *PostgresIdentityModel) InsertUser(ctx context.Context, user *User, issuer, subject string) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		stmt := `INSERT INTO users (username, email, password, created_at, email_verified_at) VALUES ($1, $2, $3, NOW(), NOW())
+			RETURNING id, created_at, email_verified_at`
+		start := time.Now()
+		err := tx.QueryRowContext(ctx, stmt, user.Username, user.Email, user.Password.hash).Scan(&user.ID, &user.CreatedAt, &user.EmailVerifiedAt)
+		logQuery(ctx, "users.insert", start, err)
+		if err != nil {
+			return userInsertError(err)
+		}
+		start = time.Now()
+		_, err = tx.ExecContext(ctx, "INSERT INTO user_identities (issuer, subject, user_id, last_login_at) VALUES ($1, $2, $3, NOW())",
+			issuer, subject, user.ID)
+		logQuery(ctx, "user_identities.insert", start, err)
+		return err
+	})
+}
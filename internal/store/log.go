@@ -0,0 +1,26 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/logging"
+)
+
+var SlowQueryThreshold = 500 * time.Millisecond
+
+// logQuery reports failed and slow queries through the request-scoped logger
+// so they carry the same request ID as the access log.
+func logQuery(ctx context.Context, op string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	logger := logging.FromContext(ctx)
+	switch {
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		logger.LogAttrs(ctx, slog.LevelWarn, "query failed", slog.String("op", op), slog.Duration("duration", elapsed), slog.String("error", err.Error()))
+	case elapsed > SlowQueryThreshold:
+		logger.LogAttrs(ctx, slog.LevelWarn, "slow query", slog.String("op", op), slog.Duration("duration", elapsed))
+	}
+}
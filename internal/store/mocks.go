@@ -1,41 +1,211 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func NewStorage() Storage {
+	twoFactor := &MockTwoFactorStore{
+		enrollments:   make(map[int]*TwoFactor),
+		recoveryCodes: make(map[int]map[string]bool),
+	}
+	sessions := &MockSessionStore{sessions: make(map[string]Session)}
+	snippets := &MockSnippetStore{}
+	audit := &MockAuditStore{}
+	users := &MockUserStore{provisioned: make(map[int]User), upgraded: make(map[int]password), deleted: make(map[int]bool),
+		usernames: make(map[int]string), emails: make(map[int]string),
+		roles: make(map[int]Role), disabled: make(map[int]time.Time), resetRequired: make(map[int]bool),
+		twoFactor: twoFactor, sessions: sessions, snippets: snippets, audit: audit}
+	users.orgs = &MockOrganizationStore{users: users, snippets: snippets, members: make(map[int]map[int]mockMembership)}
 	return Storage{
-		Snippets: &MockSnippetStore{},
-		Users:    &MockUserStore{},
+		Snippets:       snippets,
+		Users:          users,
+		PasswordResets: &MockPasswordResetStore{users: users, tokens: make(map[string]mockResetToken)},
+		TwoFactor:      twoFactor,
+		WebAuthn:       &MockWebAuthnStore{},
+		Identities:     &MockIdentityStore{users: users, links: make(map[mockIdentity]int)},
+		Sessions:       sessions,
+		Audit:          audit,
+		Organizations:  users.orgs,
 	}
 }
 
 type MockSnippetStore struct {
 	Snippet Snippet
+
+	mu sync.Mutex
+	// inserted holds the snippets made by Insert, which are numbered from
+	// 2 after Snippet.
+	inserted []Snippet
 }
 
 func (m *MockSnippetStore) Insert(ctx context.Context, s *Snippet) (int, error) {
-	return 2, nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := *s
+	saved.ID = int64(2 + len(m.inserted))
+	saved.Created = time.Now()
+	m.inserted = append(m.inserted, saved)
+	return int(saved.ID), nil
+}
+
+func (m *MockSnippetStore) ListByUser(ctx context.Context, userID int) ([]Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var snippets []Snippet
+	for _, s := range m.inserted {
+		if s.UserID == userID {
+			snippets = append(snippets, s)
+		}
+	}
+	return snippets, nil
+}
+
+// removeOwner deletes the snippets of userID, or keeps them without an
+// owner, as deleting the user does.
+func (m *MockSnippetStore) removeOwner(userID int, purge bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.inserted[:0]
+	for _, s := range m.inserted {
+		if s.UserID == userID {
+			if purge {
+				continue
+			}
+			s.UserID = 0
+		}
+		kept = append(kept, s)
+	}
+	m.inserted = kept
+}
+
+// removeOrg deletes the snippets of an organization, as deleting it does.
+func (m *MockSnippetStore) removeOrg(orgID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inserted = slices.DeleteFunc(m.inserted, func(s Snippet) bool {
+		return s.OrgID == orgID
+	})
+}
+
+func (m *MockSnippetStore) Stats(ctx context.Context) (SnippetStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 1 + len(m.inserted)
+	owned := 0
+	for _, s := range m.inserted {
+		if s.UserID != 0 {
+			owned++
+		}
+	}
+	return SnippetStats{Total: n, Active: n, Owned: owned}, nil
 }
 
 func (m *MockSnippetStore) Get(ctx context.Context, id int64) (*Snippet, error) {
-	switch id {
-	case 1:
+	if id == 1 {
 		return &m.Snippet, nil
-	default:
-		return nil, ErrNoRecord
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.inserted {
+		if s.ID == id && s.Expires.After(time.Now()) {
+			return &s, nil
+		}
+	}
+	return nil, ErrNoRecord
+}
+
+func (m *MockSnippetStore) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
 }
 
-type MockUserStore struct{}
+type MockUserStore struct {
+	twoFactor *MockTwoFactorStore
+	sessions  *MockSessionStore
+	snippets  *MockSnippetStore
+	audit     *MockAuditStore
+	orgs      *MockOrganizationStore
+
+	mu sync.Mutex
+	// provisioned holds users created by MockIdentityStore.InsertUser.
+	provisioned map[int]User
+	// upgraded holds password hashes replaced by UpgradePassword.
+	upgraded map[int]password
+	deleted  map[int]bool
+	// usernames and emails hold the details changed by UpdateUsername and
+	// UpdateEmail.
+	usernames map[int]string
+	emails    map[int]string
+	// roles, disabled and resetRequired hold the changes made from the
+	// admin area.
+	roles         map[int]Role
+	disabled      map[int]time.Time
+	resetRequired map[int]bool
+}
+
+// MockUserPassword is the password of MockUser.
+const MockUserPassword = "pa$$word123"
 
 var MockUser = User{
-	ID:        1,
-	Username:  "validusername",
-	Email:     "valid@example.com",
+	ID:              1,
+	Username:        "validusername",
+	Email:           "valid@example.com",
+	Password:        mockPassword(MockUserPassword),
+	CreatedAt:       time.Now(),
+	EmailVerifiedAt: time.Now(),
+	Role:            RoleUser,
+}
+
+// MockUnverifiedUser has the same password as MockUser but has not
+// verified their email address.
+var MockUnverifiedUser = User{
+	ID:        3,
+	Username:  "unverifiedusername",
+	Email:     "unverified@example.com",
+	Password:  mockPassword(MockUserPassword),
 	CreatedAt: time.Now(),
+	Role:      RoleUser,
+}
+
+// MockModeratorUser and MockAdminUser have the same password as MockUser
+// and can use the admin area.
+var MockModeratorUser = User{
+	ID:              4,
+	Username:        "moderatorusername",
+	Email:           "moderator@example.com",
+	Password:        mockPassword(MockUserPassword),
+	CreatedAt:       time.Now(),
+	EmailVerifiedAt: time.Now(),
+	Role:            RoleModerator,
+}
+
+var MockAdminUser = User{
+	ID:              5,
+	Username:        "adminusername",
+	Email:           "admin@example.com",
+	Password:        mockPassword(MockUserPassword),
+	CreatedAt:       time.Now(),
+	EmailVerifiedAt: time.Now(),
+	Role:            RoleAdmin,
+}
+
+// mockPassword hashes with bcrypt at the lowest cost to keep tests fast.
+// It also stands in for hashes made before Argon2id, which are upgraded on
+// login.
+func mockPassword(plaintext string) password {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return password{hash: hash}
 }
 
 func (m *MockUserStore) Insert(ctx context.Context, u *User) error {
@@ -45,36 +215,729 @@ func (m *MockUserStore) Insert(ctx context.Context, u *User) error {
 	if u.Email == "duplicate@example.com" {
 		return ErrDuplicateEmail
 	}
+	u.ID = 2
+	u.CreatedAt = time.Now()
 	return nil
 }
 
 func (m *MockUserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
-	switch email {
-	case "valid@example.com":
-		return &MockUser, nil
-	default:
+	for _, u := range m.all() {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// all returns the current state of every user that has not been deleted.
+func (m *MockUserStore) all() []User {
+	users := []User{MockUser, MockUnverifiedUser, MockModeratorUser, MockAdminUser}
+	m.mu.Lock()
+	for _, u := range m.provisioned {
+		users = append(users, u)
+	}
+	m.mu.Unlock()
+	var current []User
+	for _, u := range users {
+		if c, err := m.current(u); err == nil {
+			current = append(current, *c)
+		}
+	}
+	return current
+}
+
+// current returns a copy of u with the state tests may have changed.
+func (m *MockUserStore) current(u User) (*User, error) {
+	if tf, err := m.twoFactor.Get(context.Background(), u.ID); err == nil {
+		u.TwoFactorEnabled = tf.Enabled()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.deleted[u.ID] {
 		return nil, ErrInvalidCredentials
 	}
+	if p, ok := m.upgraded[u.ID]; ok {
+		u.Password = p
+	}
+	if name, ok := m.usernames[u.ID]; ok {
+		u.Username = name
+	}
+	if email, ok := m.emails[u.ID]; ok {
+		u.Email = email
+	}
+	if role, ok := m.roles[u.ID]; ok {
+		u.Role = role
+	}
+	u.DisabledAt = m.disabled[u.ID]
+	u.PasswordResetRequired = m.resetRequired[u.ID]
+	return &u, nil
 }
 
-func (m *MockUserStore) Exists(ctx context.Context, id int) (bool, error) {
-	switch id {
-	case 1:
-		return true, nil
+func (m *MockUserStore) GetByID(ctx context.Context, id int) (*User, error) {
+	for _, u := range m.all() {
+		if u.ID == id {
+			return &u, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+func (m *MockUserStore) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	switch {
+	case id == MockUser.ID && email == MockUser.Email:
+		return nil
+	case id == MockUnverifiedUser.ID && email == MockUnverifiedUser.Email:
+		return nil
 	default:
+		return ErrNoRecord
+	}
+}
+
+func (m *MockUserStore) PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword, keepSession string) error {
+	if currentPassword != MockUserPassword {
+		return ErrInvalidCredentials
+	}
+	m.mu.Lock()
+	delete(m.resetRequired, id)
+	m.mu.Unlock()
+	_, err := m.sessions.DeleteAllForUser(ctx, id, keepSession)
+	return err
+}
+
+func (m *MockUserStore) UpgradePassword(ctx context.Context, user *User, plaintext string) (bool, error) {
+	if !user.Password.NeedsRehash() {
 		return false, nil
 	}
+	if err := user.Password.Set(plaintext); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.upgraded[user.ID] = user.Password
+	return true, nil
 }
 
-func (m *MockUserStore) GetByID(ctx context.Context, id int) (*User, error) {
-	switch id {
-	case 1:
-		return &MockUser, nil
-	default:
-		return nil, ErrInvalidCredentials
+func (m *MockUserStore) UpdateUsername(ctx context.Context, id int, username, ip string) error {
+	u, err := m.GetByID(ctx, id)
+	if err != nil {
+		return ErrNoRecord
+	}
+	if u.Username == username {
+		return nil
+	}
+	for _, other := range m.all() {
+		if other.Username == username {
+			return ErrDuplicateUsername
+		}
+	}
+	if username == "duplicateusername" {
+		return ErrDuplicateUsername
+	}
+	m.mu.Lock()
+	m.usernames[id] = username
+	m.mu.Unlock()
+	return m.audit.Insert(ctx, &AuditEvent{UserID: id, Action: AuditUsernameChanged, OldValue: u.Username, NewValue: username, IP: ip})
+}
+
+func (m *MockUserStore) UpdateEmail(ctx context.Context, id int, oldEmail, newEmail, ip string) error {
+	u, err := m.GetByID(ctx, id)
+	if err != nil || u.Email != oldEmail {
+		return ErrNoRecord
+	}
+	if _, err := m.GetByEmail(ctx, newEmail); err == nil || newEmail == "duplicate@example.com" {
+		return ErrDuplicateEmail
+	}
+	m.mu.Lock()
+	m.emails[id] = newEmail
+	m.mu.Unlock()
+	return m.audit.Insert(ctx, &AuditEvent{UserID: id, Action: AuditEmailChanged, OldValue: oldEmail, NewValue: newEmail, IP: ip})
+}
+
+func (m *MockUserStore) Search(ctx context.Context, query string, limit, offset int) ([]UserSummary, error) {
+	var users []UserSummary
+	for _, u := range m.all() {
+		if query != "" && !strings.Contains(u.Username, query) && !strings.Contains(u.Email, query) && strconv.Itoa(u.ID) != query {
+			continue
+		}
+		owned, _ := m.snippets.ListByUser(ctx, u.ID)
+		users = append(users, UserSummary{User: u, Snippets: len(owned)})
+	}
+	slices.SortFunc(users, func(a, b UserSummary) int { return a.ID - b.ID })
+	users = users[min(offset, len(users)):]
+	return users[:min(limit, len(users))], nil
+}
+
+func (m *MockUserStore) SetRole(ctx context.Context, id int, role Role, actorID int, ip string) error {
+	u, err := m.GetByID(ctx, id)
+	if err != nil {
+		return ErrNoRecord
+	}
+	if u.Role == role {
+		return nil
+	}
+	m.mu.Lock()
+	m.roles[id] = role
+	m.mu.Unlock()
+	return m.audit.Insert(ctx, &AuditEvent{UserID: id, ActorID: actorID, Action: AuditRoleChanged, OldValue: string(u.Role), NewValue: string(role), IP: ip})
+}
+
+func (m *MockUserStore) SetDisabled(ctx context.Context, id int, disabled bool, actorID int, ip string) error {
+	u, err := m.GetByID(ctx, id)
+	if err != nil {
+		return ErrNoRecord
+	}
+	if u.Disabled() == disabled {
+		return nil
+	}
+	action := AuditEnabled
+	m.mu.Lock()
+	if disabled {
+		action = AuditDisabled
+		m.disabled[id] = time.Now()
+	} else {
+		delete(m.disabled, id)
+	}
+	m.mu.Unlock()
+	if disabled {
+		if _, err := m.sessions.DeleteAllForUser(ctx, id, ""); err != nil {
+			return err
+		}
+	}
+	return m.audit.Insert(ctx, &AuditEvent{UserID: id, ActorID: actorID, Action: action, IP: ip})
+}
+
+func (m *MockUserStore) RequirePasswordReset(ctx context.Context, id int, actorID int, ip string) error {
+	if _, err := m.GetByID(ctx, id); err != nil {
+		return ErrNoRecord
+	}
+	m.mu.Lock()
+	m.resetRequired[id] = true
+	m.mu.Unlock()
+	if _, err := m.sessions.DeleteAllForUser(ctx, id, ""); err != nil {
+		return err
+	}
+	return m.audit.Insert(ctx, &AuditEvent{UserID: id, ActorID: actorID, Action: AuditPasswordResetForced, IP: ip})
+}
+
+func (m *MockUserStore) Delete(ctx context.Context, id int, purgeSnippets bool) error {
+	if _, err := m.GetByID(ctx, id); err != nil {
+		return ErrNoRecord
+	}
+	if err := m.orgs.release(id); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.deleted[id] = true
+	m.mu.Unlock()
+	m.snippets.removeOwner(id, purgeSnippets)
+	_, err := m.sessions.DeleteAllForUser(ctx, id, "")
+	return err
+}
+
+type mockResetToken struct {
+	userID int
+	expiry time.Time
+}
+
+// MockPasswordResetStore keeps tokens in memory so a test can follow the
+// link from a reset email.
+type MockPasswordResetStore struct {
+	users *MockUserStore
+
+	mu     sync.Mutex
+	tokens map[string]mockResetToken
+}
+
+func (m *MockPasswordResetStore) Insert(ctx context.Context, userID int, hash []byte, expiry time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[string(hash)] = mockResetToken{userID: userID, expiry: expiry}
+	return nil
+}
+
+func (m *MockPasswordResetStore) GetUserID(ctx context.Context, hash []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[string(hash)]
+	if !ok || !time.Now().Before(t.expiry) {
+		return 0, ErrNoRecord
+	}
+	return t.userID, nil
+}
+
+func (m *MockPasswordResetStore) Reset(ctx context.Context, hash []byte, newPassword string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[string(hash)]
+	if !ok || !time.Now().Before(t.expiry) {
+		return 0, ErrNoRecord
+	}
+	for k, other := range m.tokens {
+		if other.userID == t.userID {
+			delete(m.tokens, k)
+		}
+	}
+	if _, err := m.users.sessions.DeleteAllForUser(ctx, t.userID, ""); err != nil {
+		return 0, err
+	}
+	m.users.mu.Lock()
+	delete(m.users.resetRequired, t.userID)
+	m.users.mu.Unlock()
+	return t.userID, nil
+}
+
+func (m *MockPasswordResetStore) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+type MockTwoFactorStore struct {
+	mu            sync.Mutex
+	enrollments   map[int]*TwoFactor
+	recoveryCodes map[int]map[string]bool
+}
+
+func (m *MockTwoFactorStore) Get(ctx context.Context, userID int) (*TwoFactor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tf, ok := m.enrollments[userID]
+	if !ok {
+		return nil, ErrNoRecord
+	}
+	c := *tf
+	return &c, nil
+}
+
+func (m *MockTwoFactorStore) SetPending(ctx context.Context, userID int, secret []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tf, ok := m.enrollments[userID]; ok && tf.Enabled() {
+		return ErrNoRecord
+	}
+	m.enrollments[userID] = &TwoFactor{Secret: secret}
+	return nil
+}
+
+func (m *MockTwoFactorStore) Enable(ctx context.Context, userID int, step int64, recoveryCodes [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tf, ok := m.enrollments[userID]
+	if !ok || tf.Enabled() {
+		return ErrNoRecord
+	}
+	tf.EnabledAt = time.Now()
+	tf.LastStep = step
+	m.recoveryCodes[userID] = make(map[string]bool)
+	for _, hash := range recoveryCodes {
+		m.recoveryCodes[userID][string(hash)] = true
+	}
+	return nil
+}
+
+func (m *MockTwoFactorStore) UseStep(ctx context.Context, userID int, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tf, ok := m.enrollments[userID]
+	if !ok || !tf.Enabled() || tf.LastStep >= step {
+		return ErrNoRecord
+	}
+	tf.LastStep = step
+	return nil
+}
+
+func (m *MockTwoFactorStore) UseRecoveryCode(ctx context.Context, userID int, hash []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.recoveryCodes[userID][string(hash)] {
+		return ErrNoRecord
+	}
+	delete(m.recoveryCodes[userID], string(hash))
+	return nil
+}
+
+func (m *MockTwoFactorStore) Disable(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.enrollments, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+
+type MockWebAuthnStore struct {
+	mu    sync.Mutex
+	creds []WebAuthnCredential
+}
+
+func (m *MockWebAuthnStore) Insert(ctx context.Context, c *WebAuthnCredential) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.CreatedAt = time.Now()
+	m.creds = append(m.creds, *c)
+	return nil
+}
+
+func (m *MockWebAuthnStore) ListByUser(ctx context.Context, userID int) ([]WebAuthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var creds []WebAuthnCredential
+	for _, c := range m.creds {
+		if c.UserID == userID {
+			creds = append(creds, c)
+		}
+	}
+	return creds, nil
+}
+
+func (m *MockWebAuthnStore) RecordUse(ctx context.Context, id []byte, signCount uint32, backupState bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.creds {
+		if bytes.Equal(m.creds[i].ID, id) {
+			m.creds[i].SignCount = signCount
+			m.creds[i].BackupState = backupState
+			m.creds[i].LastUsedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrNoRecord
+}
+
+func (m *MockWebAuthnStore) Delete(ctx context.Context, userID int, id []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.creds, func(c WebAuthnCredential) bool {
+		return c.UserID == userID && bytes.Equal(c.ID, id)
+	})
+	if i < 0 {
+		return ErrNoRecord
+	}
+	m.creds = slices.Delete(m.creds, i, i+1)
+	return nil
+}
+
+type mockIdentity struct {
+	issuer, subject string
+}
+
+// MockIdentityStore links identities in memory. Users it creates can be
+// fetched from the MockUserStore it was made with.
+type MockIdentityStore struct {
+	users *MockUserStore
+
+	mu    sync.Mutex
+	links map[mockIdentity]int
+}
+
+func (m *MockIdentityStore) GetUserID(ctx context.Context, issuer, subject string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.links[mockIdentity{issuer, subject}]
+	if !ok {
+		return 0, ErrNoRecord
+	}
+	return id, nil
+}
+
+func (m *MockIdentityStore) ListByUser(ctx context.Context, userID int) ([]Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var identities []Identity
+	for link, id := range m.links {
+		if id == userID {
+			identities = append(identities, Identity{Issuer: link.issuer, Subject: link.subject, UserID: id})
+		}
+	}
+	return identities, nil
+}
+
+func (m *MockIdentityStore) Link(ctx context.Context, issuer, subject string, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.links[mockIdentity{issuer, subject}]; !ok {
+		m.links[mockIdentity{issuer, subject}] = userID
+	}
+	return nil
+}
+
+func (m *MockIdentityStore) InsertUser(ctx context.Context, user *User, issuer, subject string) error {
+	m.users.mu.Lock()
+	defer m.users.mu.Unlock()
+	taken := []User{MockUser, MockUnverifiedUser, {Username: "duplicateusername", Email: "duplicate@example.com"}}
+	for _, u := range m.users.provisioned {
+		taken = append(taken, u)
+	}
+	for _, u := range taken {
+		switch {
+		case u.Email == user.Email:
+			return ErrDuplicateEmail
+		case u.Username == user.Username:
+			return ErrDuplicateUsername
+		}
+	}
+	user.ID = 100 + len(m.users.provisioned)
+	user.CreatedAt = time.Now()
+	user.EmailVerifiedAt = time.Now()
+	m.users.provisioned[user.ID] = *user
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.links[mockIdentity{issuer, subject}] = user.ID
+	return nil
+}
+
+// MockSessionStore keeps sessions in memory so tests can revoke them.
+type MockSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func (m *MockSessionStore) Insert(ctx context.Context, s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.CreatedAt = time.Now()
+	s.LastSeenAt = s.CreatedAt
+	m.sessions[s.ID] = *s
+	return nil
+}
+
+func (m *MockSessionStore) Touch(ctx context.Context, id string, userID int, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.UserID != userID {
+		return ErrNoRecord
+	}
+	s.LastSeenAt = time.Now()
+	s.IP = ip
+	m.sessions[id] = s
+	return nil
+}
+
+func (m *MockSessionStore) ListByUser(ctx context.Context, userID int) ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sessions []Session
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b Session) int {
+		return b.LastSeenAt.Compare(a.LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (m *MockSessionStore) Delete(ctx context.Context, userID int, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.UserID != userID {
+		return ErrNoRecord
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MockSessionStore) DeleteAllForUser(ctx context.Context, userID int, keep string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, s := range m.sessions {
+		if s.UserID == userID && id != keep {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MockSessionStore) DeleteExpired(ctx context.Context, lifetime time.Duration) (int64, error) {
+	return 0, nil
+}
+
+// MockAuditStore keeps the audit log in memory.
+type MockAuditStore struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (m *MockAuditStore) Insert(ctx context.Context, e *AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.CreatedAt = time.Now()
+	m.events = append(m.events, *e)
+	return nil
+}
+
+func (m *MockAuditStore) ListByUser(ctx context.Context, userID int) ([]AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []AuditEvent
+	for i := len(m.events) - 1; i >= 0; i-- {
+		if m.events[i].UserID == userID {
+			events = append(events, m.events[i])
+		}
 	}
+	return events, nil
+}
+
+type mockMembership struct {
+	role     OrgRole
+	joinedAt time.Time
 }
 
-func (m *MockUserStore) PasswordUpdate(ctx context.Context, id int, currentPassword string, newPassword string) error {
+// MockOrganizationStore keeps organizations in memory, numbered from 1.
+type MockOrganizationStore struct {
+	users    *MockUserStore
+	snippets *MockSnippetStore
+
+	mu      sync.Mutex
+	orgs    []Organization
+	members map[int]map[int]mockMembership
+}
+
+func (m *MockOrganizationStore) Insert(ctx context.Context, org *Organization, ownerID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	org.ID = len(m.orgs) + 1
+	org.CreatedAt = time.Now()
+	org.Role = OrgRoleOwner
+	m.orgs = append(m.orgs, *org)
+	m.members[org.ID] = map[int]mockMembership{ownerID: {role: OrgRoleOwner, joinedAt: org.CreatedAt}}
 	return nil
 }
+
+func (m *MockOrganizationStore) GetForMember(ctx context.Context, id, userID int) (*Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mem, ok := m.members[id][userID]
+	if !ok {
+		return nil, ErrNoRecord
+	}
+	org := m.orgs[id-1]
+	org.Role = mem.role
+	return &org, nil
+}
+
+func (m *MockOrganizationStore) ListByUser(ctx context.Context, userID int) ([]Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orgs []Organization
+	for _, org := range m.orgs {
+		if mem, ok := m.members[org.ID][userID]; ok {
+			org.Role = mem.role
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs, nil
+}
+
+func (m *MockOrganizationStore) ListMembers(ctx context.Context, orgID int) ([]OrgMember, error) {
+	m.mu.Lock()
+	memberships := m.members[orgID]
+	m.mu.Unlock()
+	var members []OrgMember
+	for _, u := range m.users.all() {
+		if mem, ok := memberships[u.ID]; ok {
+			members = append(members, OrgMember{UserID: u.ID, Username: u.Username, Email: u.Email, Role: mem.role, JoinedAt: mem.joinedAt})
+		}
+	}
+	slices.SortStableFunc(members, func(a, b OrgMember) int {
+		return strings.Compare(string(b.Role), string(a.Role))
+	})
+	return members, nil
+}
+
+func (m *MockOrganizationStore) SetMember(ctx context.Context, orgID, userID int, role OrgRole) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members, ok := m.members[orgID]
+	if !ok {
+		return ErrNoRecord
+	}
+	mem, ok := members[userID]
+	if !ok {
+		mem.joinedAt = time.Now()
+	}
+	old := mem.role
+	mem.role = role
+	members[userID] = mem
+	if !mockHasOwner(members) {
+		members[userID] = mockMembership{role: old, joinedAt: mem.joinedAt}
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func (m *MockOrganizationStore) RemoveMember(ctx context.Context, orgID, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mem, ok := m.members[orgID][userID]
+	if !ok {
+		return ErrNoRecord
+	}
+	delete(m.members[orgID], userID)
+	if !mockHasOwner(m.members[orgID]) {
+		m.members[orgID][userID] = mem
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// release is releaseOrganizations for a user about to be deleted.
+func (m *MockOrganizationStore) release(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var emptied []int
+	for orgID, members := range m.members {
+		mem := members[userID]
+		if mem.role != OrgRoleOwner {
+			continue
+		}
+		if len(members) == 1 {
+			emptied = append(emptied, orgID)
+			continue
+		}
+		delete(members, userID)
+		owned := mockHasOwner(members)
+		members[userID] = mem
+		if !owned {
+			return ErrLastOwner
+		}
+	}
+	for _, orgID := range emptied {
+		delete(m.members, orgID)
+		m.snippets.removeOrg(orgID)
+	}
+	return nil
+}
+
+func mockHasOwner(members map[int]mockMembership) bool {
+	for _, mem := range members {
+		if mem.role == OrgRoleOwner {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MockOrganizationStore) SetRetention(ctx context.Context, orgID, days int) (int64, error) {
+	m.mu.Lock()
+	if orgID < 1 || orgID > len(m.orgs) {
+		m.mu.Unlock()
+		return 0, ErrNoRecord
+	}
+	m.orgs[orgID-1].RetentionDays = days
+	m.mu.Unlock()
+
+	m.snippets.mu.Lock()
+	defer m.snippets.mu.Unlock()
+	var shortened int64
+	for i, s := range m.snippets.inserted {
+		if limit := s.Created.AddDate(0, 0, days); s.OrgID == orgID && s.Expires.After(limit) {
+			m.snippets.inserted[i].Expires = limit
+			shortened++
+		}
+	}
+	return shortened, nil
+}
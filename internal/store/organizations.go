@@ -0,0 +1,315 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// OrgRole is a member's role in an organization. Owners manage its
+// members and retention policy; members create and view its snippets.
+type OrgRole string
+
+const (
+	OrgRoleMember OrgRole = "member"
+	OrgRoleOwner  OrgRole = "owner"
+)
+
+var OrgRoles = []OrgRole{OrgRoleMember, OrgRoleOwner}
+
+func ParseOrgRole(s string) (OrgRole, error) {
+	r := OrgRole(s)
+	if !slices.Contains(OrgRoles, r) {
+		return "", fmt.Errorf("unknown organization role %q", s)
+	}
+	return r, nil
+}
+
+// Organization is a team whose snippets only its members can view.
+type Organization struct {
+	ID   int
+	Name string
+	// RetentionDays is the longest any of the organization's snippets is
+	// kept.
+	RetentionDays int
+	CreatedAt     time.Time
+	// Role is the role of the user the organization was looked up for.
+	Role OrgRole
+}
+
+type OrgMember struct {
+	UserID   int
+	Username string
+	Email    string
+	Role     OrgRole
+	JoinedAt time.Time
+}
+
+type PostgresOrganizationModel struct {
+	DB *sql.DB
+}
+
+// Insert creates org with ownerID as its first owner.
+func (m *PostgresOrganizationModel) Insert(ctx context.Context, org *Organization, ownerID int) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		stmt := "INSERT INTO organizations (name, retention_days) VALUES ($1, $2) RETURNING id, created_at"
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		start := time.Now()
+		err := tx.QueryRowContext(ctx, stmt, org.Name, org.RetentionDays).Scan(&org.ID, &org.CreatedAt)
+		logQuery(ctx, "organizations.insert", start, err)
+		if err != nil {
+			return err
+		}
+
+		start = time.Now()
+		_, err = tx.ExecContext(ctx, "INSERT INTO organization_members (org_id, user_id, role) VALUES ($1, $2, $3)", org.ID, ownerID, OrgRoleOwner)
+		logQuery(ctx, "organization_members.insert", start, err)
+		org.Role = OrgRoleOwner
+		return err
+	})
+}
+
+// GetForMember returns the organization with id if userID is a member of
+// it, and ErrNoRecord otherwise.
+func (m *PostgresOrganizationModel) GetForMember(ctx context.Context, id, userID int) (*Organization, error) {
+	stmt := `SELECT o.id, o.name, o.retention_days, o.created_at, m.role FROM organizations o
+		JOIN organization_members m ON m.org_id = o.id WHERE o.id = $1 AND m.user_id = $2`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	var org Organization
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, id, userID).Scan(&org.ID, &org.Name, &org.RetentionDays, &org.CreatedAt, &org.Role)
+	logQuery(ctx, "organizations.get_for_member", start, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// ListByUser returns the organizations userID is a member of, by name.
+func (m *PostgresOrganizationModel) ListByUser(ctx context.Context, userID int) ([]Organization, error) {
+	stmt := `SELECT o.id, o.name, o.retention_days, o.created_at, m.role FROM organizations o
+		JOIN organization_members m ON m.org_id = o.id WHERE m.user_id = $1 ORDER BY o.name, o.id`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	logQuery(ctx, "organizations.list_by_user", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []Organization
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.RetentionDays, &org.CreatedAt, &org.Role); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+// ListMembers returns the organization's members, owners first.
+func (m *PostgresOrganizationModel) ListMembers(ctx context.Context, orgID int) ([]OrgMember, error) {
+	stmt := `SELECT u.id, u.username, u.email, m.role, m.created_at FROM organization_members m
+		JOIN users u ON u.id = m.user_id WHERE m.org_id = $1 ORDER BY m.role = 'owner' DESC, u.username`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	rows, err := m.DB.QueryContext(ctx, stmt, orgID)
+	logQuery(ctx, "organization_members.list", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []OrgMember
+	for rows.Next() {
+		var mem OrgMember
+		if err := rows.Scan(&mem.UserID, &mem.Username, &mem.Email, &mem.Role, &mem.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, mem)
+	}
+	return members, rows.Err()
+}
+
+// SetMember adds userID to the organization with role, or changes their
+// role if they are already a member. It returns ErrLastOwner rather than
+// leave the organization without an owner.
+func (m *PostgresOrganizationModel) SetMember(ctx context.Context, orgID, userID int, role OrgRole) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		if err := lockOrganization(ctx, tx, orgID); err != nil {
+			return err
+		}
+		stmt := `INSERT INTO organization_members (org_id, user_id, role) VALUES ($1, $2, $3)
+			ON CONFLICT (org_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		start := time.Now()
+		_, err := tx.ExecContext(ctx, stmt, orgID, userID, role)
+		logQuery(ctx, "organization_members.upsert", start, err)
+		if err != nil {
+			return err
+		}
+		return checkOwners(ctx, tx, orgID)
+	})
+}
+
+// RemoveMember takes userID out of the organization. The snippets they
+// created in it stay with the organization. It returns ErrNoRecord if they
+// were not a member and ErrLastOwner if they are its only owner.
+func (m *PostgresOrganizationModel) RemoveMember(ctx context.Context, orgID, userID int) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		if err := lockOrganization(ctx, tx, orgID); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		start := time.Now()
+		res, err := tx.ExecContext(ctx, "DELETE FROM organization_members WHERE org_id = $1 AND user_id = $2", orgID, userID)
+		logQuery(ctx, "organization_members.delete", start, err)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNoRecord
+		}
+		return checkOwners(ctx, tx, orgID)
+	})
+}
+
+// SetRetention changes how long the organization keeps snippets. Existing
+// snippets are shortened to fit, and the number shortened is returned;
+// any that are now past their expiry stop being served straight away.
+func (m *PostgresOrganizationModel) SetRetention(ctx context.Context, orgID, days int) (int64, error) {
+	var shortened int64
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		start := time.Now()
+		res, err := tx.ExecContext(ctx, "UPDATE organizations SET retention_days = $1 WHERE id = $2", days, orgID)
+		logQuery(ctx, "organizations.update_retention", start, err)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNoRecord
+		}
+
+		stmt := `UPDATE snippets SET expires = created + make_interval(days => $2)
+			WHERE org_id = $1 AND expires > created + make_interval(days => $2)`
+		start = time.Now()
+		res, err = tx.ExecContext(ctx, stmt, orgID, days)
+		logQuery(ctx, "snippets.apply_retention", start, err)
+		if err != nil {
+			return err
+		}
+		shortened, err = res.RowsAffected()
+		return err
+	})
+	return shortened, err
+}
+
+// lockOrganization serializes changes to an organization's members, so two
+// owners cannot demote each other at once. It returns ErrNoRecord if there
+// is no such organization.
+func lockOrganization(ctx context.Context, tx *sql.Tx, orgID int) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	var id int
+	start := time.Now()
+	err := tx.QueryRowContext(ctx, "SELECT id FROM organizations WHERE id = $1 FOR UPDATE", orgID).Scan(&id)
+	logQuery(ctx, "organizations.lock", start, err)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoRecord
+	}
+	return err
+}
+
+func checkOwners(ctx context.Context, tx *sql.Tx, orgID int) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	var owners int
+	start := time.Now()
+	err := tx.QueryRowContext(ctx, "SELECT count(*) FROM organization_members WHERE org_id = $1 AND role = 'owner'", orgID).Scan(&owners)
+	logQuery(ctx, "organization_members.count_owners", start, err)
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// releaseOrganizations runs before userID's account is deleted, which also
+// deletes their memberships. Organizations they are the only member of are
+// deleted along with their snippets. If they are the only owner of one that
+// has other members, ErrLastOwner is returned: nobody could manage it
+// afterwards.
+func releaseOrganizations(ctx context.Context, tx *sql.Tx, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	rows, err := tx.QueryContext(ctx, "SELECT org_id FROM organization_members WHERE user_id = $1 AND role = 'owner' ORDER BY org_id", userID)
+	logQuery(ctx, "organization_members.list_owned", start, err)
+	if err != nil {
+		return err
+	}
+	var owned []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		owned = append(owned, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, orgID := range owned {
+		if err := lockOrganization(ctx, tx, orgID); err != nil {
+			return err
+		}
+		var others, otherOwners int
+		stmt := `SELECT count(*), count(*) FILTER (WHERE role = 'owner') FROM organization_members
+			WHERE org_id = $1 AND user_id <> $2`
+		start = time.Now()
+		err := tx.QueryRowContext(ctx, stmt, orgID, userID).Scan(&others, &otherOwners)
+		logQuery(ctx, "organization_members.count_others", start, err)
+		if err != nil {
+			return err
+		}
+		switch {
+		case others == 0:
+			start = time.Now()
+			_, err = tx.ExecContext(ctx, "DELETE FROM organizations WHERE id = $1", orgID)
+			logQuery(ctx, "organizations.delete", start, err)
+			if err != nil {
+				return err
+			}
+		case otherOwners == 0:
+			return ErrLastOwner
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// NewToken returns a random token to hand to the user and the hash to
+// store in its place, so a leaked database cannot be used to reset
+// passwords.
+func NewToken() (plaintext string, hash []byte, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	plaintext = base64.RawURLEncoding.EncodeToString(b)
+	return plaintext, HashToken(plaintext), nil
+}
+
+// HashToken returns the stored form of a token made by NewToken.
+func HashToken(plaintext string) []byte {
+	sum := sha256.Sum256([]byte(plaintext))
+	return sum[:]
+}
+
+type PostgresPasswordResetModel struct {
+	DB *sql.DB
+}
+
+func (m *PostgresPasswordResetModel) Insert(ctx context.Context, userID int, hash []byte, expiry time.Time) error {
+	stmt := "INSERT INTO password_reset_tokens (hash, user_id, expiry) VALUES ($1, $2, $3)"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	_, err := m.DB.ExecContext(ctx, stmt, hash, userID, expiry)
+	logQuery(ctx, "password_resets.insert", start, err)
+	return err
+}
+
+// GetUserID returns the user an unexpired token belongs to, or ErrNoRecord.
+func (m *PostgresPasswordResetModel) GetUserID(ctx context.Context, hash []byte) (int, error) {
+	var id int
+	stmt := "SELECT user_id FROM password_reset_tokens WHERE hash = $1 AND expiry > NOW()"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, hash).Scan(&id)
+	logQuery(ctx, "password_resets.get_user_id", start, err)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNoRecord
+	}
+	return id, err
+}
+
+// Reset sets a new password for the owner of an unexpired token and
+// returns their ID. It deletes every reset token and session the user
+// has, so the token is single use and existing sessions are logged out.
+// It returns ErrNoRecord if the token is unknown, expired or already
+// used.
+func (m *PostgresPasswordResetModel) Reset(ctx context.Context, hash []byte, newPassword string) (int, error) {
+	var user User
+	if err := user.Password.Set(newPassword); err != nil {
+		return 0, err
+	}
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		// Deleting the row claims it, so concurrent requests with the same
+		// token cannot both succeed.
+		stmt := "DELETE FROM password_reset_tokens WHERE hash = $1 AND expiry > NOW() RETURNING user_id"
+		start := time.Now()
+		err := tx.QueryRowContext(ctx, stmt, hash).Scan(&user.ID)
+		logQuery(ctx, "password_resets.claim", start, err)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+
+		stmt = "UPDATE users SET password = $1, password_reset_required = false WHERE id = $2"
+		start = time.Now()
+		_, err = tx.ExecContext(ctx, stmt, user.Password.hash, user.ID)
+		logQuery(ctx, "users.reset_password", start, err)
+		if err != nil {
+			return err
+		}
+
+		stmt = "DELETE FROM password_reset_tokens WHERE user_id = $1"
+		start = time.Now()
+		_, err = tx.ExecContext(ctx, stmt, user.ID)
+		logQuery(ctx, "password_resets.delete_for_user", start, err)
+		if err != nil {
+			return err
+		}
+
+		stmt = "DELETE FROM user_sessions WHERE user_id = $1"
+		start = time.Now()
+		_, err = tx.ExecContext(ctx, stmt, user.ID)
+		logQuery(ctx, "user_sessions.delete_for_user", start, err)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+func (m *PostgresPasswordResetModel) DeleteExpired(ctx context.Context) (int64, error) {
+	stmt := "DELETE FROM password_reset_tokens WHERE expiry <= NOW()"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, stmt)
+	logQuery(ctx, "password_resets.delete_expired", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
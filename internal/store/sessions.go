@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Session is one login of a user, shown so they can end the ones they
+// don't recognise.
+type Session struct {
+	ID         string
+	UserID     int
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	IP         string
+	UserAgent  string
+}
+
+type PostgresSessionModel struct {
+	DB *sql.DB
+}
+
+func (m *PostgresSessionModel) Insert(ctx context.Context, s *Session) error {
+	stmt := `INSERT INTO user_sessions (id, user_id, ip, user_agent) VALUES ($1, $2, $3, $4)
+		RETURNING created_at, last_seen_at`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, s.ID, s.UserID, s.IP, s.UserAgent).Scan(&s.CreatedAt, &s.LastSeenAt)
+	logQuery(ctx, "user_sessions.insert", start, err)
+	return err
+}
+
+// Touch records that the session was used from ip. It returns ErrNoRecord
+// if the session was revoked. The time is only written once a minute, so
+// most requests cost a single read.
+func (m *PostgresSessionModel) Touch(ctx context.Context, id string, userID int, ip string) error {
+	stmt := `WITH touched AS (
+			UPDATE user_sessions SET last_seen_at = NOW(), ip = $3
+			WHERE id = $1 AND user_id = $2 AND last_seen_at < NOW() - interval '1 minute'
+		)
+		SELECT EXISTS (SELECT 1 FROM user_sessions WHERE id = $1 AND user_id = $2)`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	var exists bool
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, id, userID, ip).Scan(&exists)
+	logQuery(ctx, "user_sessions.touch", start, err)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// ListByUser returns the user's sessions, most recently used first.
+func (m *PostgresSessionModel) ListByUser(ctx context.Context, userID int) ([]Session, error) {
+	stmt := `SELECT id, user_id, created_at, last_seen_at, ip, user_agent FROM user_sessions
+		WHERE user_id = $1 ORDER BY last_seen_at DESC, created_at DESC`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	logQuery(ctx, "user_sessions.list_by_user", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.CreatedAt, &s.LastSeenAt, &s.IP, &s.UserAgent); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Delete revokes one of the user's sessions, or returns ErrNoRecord.
+func (m *PostgresSessionModel) Delete(ctx context.Context, userID int, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, "DELETE FROM user_sessions WHERE id = $1 AND user_id = $2", id, userID)
+	logQuery(ctx, "user_sessions.delete", start, err)
+	return checkAffected(res, err)
+}
+
+// DeleteAllForUser revokes every session the user has except keep, which
+// may be empty.
+func (m *PostgresSessionModel) DeleteAllForUser(ctx context.Context, userID int, keep string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = $1 AND id <> $2", userID, keep)
+	logQuery(ctx, "user_sessions.delete_for_user", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteExpired removes sessions created more than lifetime ago, which
+// the session store has already forgotten.
+func (m *PostgresSessionModel) DeleteExpired(ctx context.Context, lifetime time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, "DELETE FROM user_sessions WHERE created_at < $1", time.Now().Add(-lifetime))
+	logQuery(ctx, "user_sessions.delete_expired", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -15,6 +15,11 @@ type Snippet struct {
 	IV         []byte
 	Created    time.Time
 	Expires    time.Time
+	// UserID is the owner, or 0 if the snippet has none.
+	UserID int
+	// OrgID is the organization the snippet was created in, or 0. Only
+	// its members may view the snippet.
+	OrgID int
 }
 
 type PostgresSnippet struct {
@@ -25,14 +30,16 @@ func (m *PostgresSnippet) Insert(ctx context.Context, snippet *Snippet) (int, er
 	// log.Printf("data layer title: %s, content: %s, expires: %d", title, content, expires)
 	//	stmt := `INSERT INTO snippets (title, content, created, expires)
 	// VALUES ($1, $2, NOW(), NOW() + ($3 || ' days')::INTERVAL)
-	stmt := `INSERT INTO snippets (title, content, iv,created, expires)
-  VALUES ($1, $2, $3,NOW(), $4)
+	stmt := `INSERT INTO snippets (title, content, iv,created, expires, user_id, org_id)
+  VALUES ($1, $2, $3,NOW(), $4, NULLIF($5, 0), NULLIF($6, 0))
   RETURNING id
   `
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
 	var id int
-	err := m.DB.QueryRowContext(ctx, stmt, snippet.Title, snippet.Ciphertext, snippet.IV, snippet.Expires).Scan(&id)
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, snippet.Title, snippet.Ciphertext, snippet.IV, snippet.Expires, snippet.UserID, snippet.OrgID).Scan(&id)
+	logQuery(ctx, "snippets.insert", start, err)
 	if err != nil {
 		return 0, err
 	}
@@ -40,12 +47,14 @@ func (m *PostgresSnippet) Insert(ctx context.Context, snippet *Snippet) (int, er
 }
 
 func (m *PostgresSnippet) Get(ctx context.Context, id int64) (*Snippet, error) {
-	stmt := "SELECT id, title, content, iv,created, expires FROM snippets WHERE expires > NOW() and id=$1"
+	stmt := "SELECT id, title, content, iv,created, expires, COALESCE(user_id, 0), COALESCE(org_id, 0) FROM snippets WHERE expires > NOW() and id=$1"
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
+	start := time.Now()
 	row := m.DB.QueryRowContext(ctx, stmt, id)
 	var s Snippet
-	err := row.Scan(&s.ID, &s.Title, &s.Ciphertext, &s.IV, &s.Created, &s.Expires)
+	err := row.Scan(&s.ID, &s.Title, &s.Ciphertext, &s.IV, &s.Created, &s.Expires, &s.UserID, &s.OrgID)
+	logQuery(ctx, "snippets.get", start, err)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -56,6 +65,66 @@ func (m *PostgresSnippet) Get(ctx context.Context, id int64) (*Snippet, error) {
 	return &s, nil
 }
 
+// ListByUser returns the unexpired snippets owned by userID, newest
+// first, without their content.
+func (m *PostgresSnippet) ListByUser(ctx context.Context, userID int) ([]Snippet, error) {
+	stmt := "SELECT id, title, created, expires, COALESCE(org_id, 0) FROM snippets WHERE user_id = $1 AND expires > NOW() ORDER BY created DESC, id"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	logQuery(ctx, "snippets.list_by_user", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+	for rows.Next() {
+		s := Snippet{UserID: userID}
+		if err := rows.Scan(&s.ID, &s.Title, &s.Created, &s.Expires, &s.OrgID); err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	return snippets, rows.Err()
+}
+
+// SnippetStats counts snippets without looking at their contents.
+type SnippetStats struct {
+	Total int
+	// Active snippets have not expired; the rest are waiting to be
+	// cleaned up.
+	Active int
+	// Owned is the number of active snippets that have an owner.
+	Owned int
+}
+
+func (m *PostgresSnippet) Stats(ctx context.Context) (SnippetStats, error) {
+	stmt := `SELECT count(*), count(*) FILTER (WHERE expires > NOW()),
+		count(*) FILTER (WHERE expires > NOW() AND user_id IS NOT NULL) FROM snippets`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	var s SnippetStats
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt).Scan(&s.Total, &s.Active, &s.Owned)
+	logQuery(ctx, "snippets.stats", start, err)
+	return s, err
+}
+
+func (m *PostgresSnippet) DeleteExpired(ctx context.Context) (int64, error) {
+	stmt := "DELETE FROM snippets WHERE expires <= NOW()"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	result, err := m.DB.ExecContext(ctx, stmt)
+	logQuery(ctx, "snippets.delete_expired", start, err)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // func (m *PostgresSnippet) Latest() ([]Snippet, error) {
 // 	stmt := "SELECT id, title, content, created, expires FROM snippets WHERE expires > NOW() ORDER BY id DESC LIMIT 10"
 // 	rows, err := m.DB.Query(stmt)
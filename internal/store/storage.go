@@ -12,21 +12,86 @@ type Storage struct {
 	Snippets interface {
 		Insert(context.Context, *Snippet) (int, error)
 		Get(context.Context, int64) (*Snippet, error)
+		ListByUser(ctx context.Context, userID int) ([]Snippet, error)
+		Stats(context.Context) (SnippetStats, error)
+		DeleteExpired(context.Context) (int64, error)
 		// Latest() ([]Snippet, error)
 	}
 	Users interface {
 		Insert(context.Context, *User) error
 		GetByEmail(context.Context, string) (*User, error)
-		Exists(context.Context, int) (bool, error)
 		GetByID(context.Context, int) (*User, error)
-		PasswordUpdate(context.Context, int, string, string) error
+		MarkEmailVerified(context.Context, int, string) error
+		PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword, keepSession string) error
+		UpgradePassword(ctx context.Context, user *User, plaintext string) (bool, error)
+		UpdateUsername(ctx context.Context, id int, username, ip string) error
+		UpdateEmail(ctx context.Context, id int, oldEmail, newEmail, ip string) error
+		Search(ctx context.Context, query string, limit, offset int) ([]UserSummary, error)
+		SetRole(ctx context.Context, id int, role Role, actorID int, ip string) error
+		SetDisabled(ctx context.Context, id int, disabled bool, actorID int, ip string) error
+		RequirePasswordReset(ctx context.Context, id int, actorID int, ip string) error
+		Delete(ctx context.Context, id int, purgeSnippets bool) error
+	}
+	PasswordResets interface {
+		Insert(ctx context.Context, userID int, hash []byte, expiry time.Time) error
+		GetUserID(ctx context.Context, hash []byte) (int, error)
+		Reset(ctx context.Context, hash []byte, newPassword string) (int, error)
+		DeleteExpired(context.Context) (int64, error)
+	}
+	TwoFactor interface {
+		Get(ctx context.Context, userID int) (*TwoFactor, error)
+		SetPending(ctx context.Context, userID int, secret []byte) error
+		Enable(ctx context.Context, userID int, step int64, recoveryCodes [][]byte) error
+		UseStep(ctx context.Context, userID int, step int64) error
+		UseRecoveryCode(ctx context.Context, userID int, hash []byte) error
+		Disable(ctx context.Context, userID int) error
+	}
+	WebAuthn interface {
+		Insert(context.Context, *WebAuthnCredential) error
+		ListByUser(ctx context.Context, userID int) ([]WebAuthnCredential, error)
+		RecordUse(ctx context.Context, id []byte, signCount uint32, backupState bool) error
+		Delete(ctx context.Context, userID int, id []byte) error
+	}
+	Identities interface {
+		GetUserID(ctx context.Context, issuer, subject string) (int, error)
+		ListByUser(ctx context.Context, userID int) ([]Identity, error)
+		Link(ctx context.Context, issuer, subject string, userID int) error
+		InsertUser(ctx context.Context, user *User, issuer, subject string) error
+	}
+	Sessions interface {
+		Insert(context.Context, *Session) error
+		Touch(ctx context.Context, id string, userID int, ip string) error
+		ListByUser(ctx context.Context, userID int) ([]Session, error)
+		Delete(ctx context.Context, userID int, id string) error
+		DeleteAllForUser(ctx context.Context, userID int, keep string) (int64, error)
+		DeleteExpired(ctx context.Context, lifetime time.Duration) (int64, error)
+	}
+	Audit interface {
+		Insert(context.Context, *AuditEvent) error
+		ListByUser(ctx context.Context, userID int) ([]AuditEvent, error)
+	}
+	Organizations interface {
+		Insert(ctx context.Context, org *Organization, ownerID int) error
+		GetForMember(ctx context.Context, id, userID int) (*Organization, error)
+		ListByUser(ctx context.Context, userID int) ([]Organization, error)
+		ListMembers(ctx context.Context, orgID int) ([]OrgMember, error)
+		SetMember(ctx context.Context, orgID, userID int, role OrgRole) error
+		RemoveMember(ctx context.Context, orgID, userID int) error
+		SetRetention(ctx context.Context, orgID, days int) (int64, error)
 	}
 }
 
 func NewPostgresStore(db *sql.DB) Storage {
 	return Storage{
-		Snippets: &PostgresSnippet{DB: db},
-		Users:    &PostgresUserModel{DB: db},
+		Snippets:       &PostgresSnippet{DB: db},
+		Users:          &PostgresUserModel{DB: db},
+		PasswordResets: &PostgresPasswordResetModel{DB: db},
+		TwoFactor:      &PostgresTwoFactorModel{DB: db},
+		WebAuthn:       &PostgresWebAuthnModel{DB: db},
+		Identities:     &PostgresIdentityModel{DB: db},
+		Sessions:       &PostgresSessionModel{DB: db},
+		Audit:          &PostgresAuditModel{DB: db},
+		Organizations:  &PostgresOrganizationModel{DB: db},
 	}
 }
 
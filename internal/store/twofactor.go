@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// TwoFactor is a user's TOTP enrollment. Secret is encrypted by the caller
+// before it is stored.
+type TwoFactor struct {
+	Secret    []byte
+	EnabledAt time.Time
+	// LastStep is the time step of the last accepted code, so a code
+	// cannot be replayed.
+	LastStep int64
+}
+
+func (t *TwoFactor) Enabled() bool {
+	return !t.EnabledAt.IsZero()
+}
+
+type PostgresTwoFactorModel struct {
+	DB *sql.DB
+}
+
+// Get returns the user's enrollment, or ErrNoRecord if they have not
+// started one.
+func (m *PostgresTwoFactorModel) Get(ctx context.Context, userID int) (*TwoFactor, error) {
+	var tf TwoFactor
+	var enabledAt sql.NullTime
+	stmt := "SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = $1 AND totp_secret IS NOT NULL"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&tf.Secret, &enabledAt, &tf.LastStep)
+	logQuery(ctx, "two_factor.get", start, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	tf.EnabledAt = enabledAt.Time
+	return &tf, nil
+}
+
+// SetPending starts an enrollment with secret, replacing any unconfirmed
+// one. It returns ErrNoRecord if two-factor authentication is already on.
+func (m *PostgresTwoFactorModel) SetPending(ctx context.Context, userID int, secret []byte) error {
+	stmt := "UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2 AND totp_enabled_at IS NULL"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, stmt, secret, userID)
+	logQuery(ctx, "two_factor.set_pending", start, err)
+	return checkAffected(res, err)
+}
+
+// Enable confirms a pending enrollment with the step of the code the user
+// entered, and replaces their recovery codes with the given hashes.
+func (m *PostgresTwoFactorModel) Enable(ctx context.Context, userID int, step int64, recoveryCodes [][]byte) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		stmt := "UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $1 WHERE id = $2 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL"
+		start := time.Now()
+		res, err := tx.ExecContext(ctx, stmt, step, userID)
+		logQuery(ctx, "two_factor.enable", start, err)
+		if err := checkAffected(res, err); err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, hashes [][]byte) error {
+	start := time.Now()
+	_, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+	logQuery(ctx, "recovery_codes.delete_for_user", start, err)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		start := time.Now()
+		_, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)", userID, hash)
+		logQuery(ctx, "recovery_codes.insert", start, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseStep records that a code for step was accepted. It returns
+// ErrNoRecord if a code for the same or a later step was already used, so
+// two requests racing with one code cannot both succeed.
+func (m *PostgresTwoFactorModel) UseStep(ctx context.Context, userID int, step int64) error {
+	stmt := "UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_enabled_at IS NOT NULL AND totp_last_step < $1"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, stmt, step, userID)
+	logQuery(ctx, "two_factor.use_step", start, err)
+	return checkAffected(res, err)
+}
+
+// UseRecoveryCode deletes the recovery code with hash, or returns
+// ErrNoRecord if the user has no such code.
+func (m *PostgresTwoFactorModel) UseRecoveryCode(ctx context.Context, userID int, hash []byte) error {
+	stmt := "DELETE FROM recovery_codes WHERE user_id = $1 AND hash = $2"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, stmt, userID, hash)
+	logQuery(ctx, "recovery_codes.use", start, err)
+	return checkAffected(res, err)
+}
+
+// Disable removes the user's enrollment and recovery codes.
+func (m *PostgresTwoFactorModel) Disable(ctx context.Context, userID int) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		stmt := "UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = $1"
+		start := time.Now()
+		_, err := tx.ExecContext(ctx, stmt, userID)
+		logQuery(ctx, "two_factor.disable", start, err)
+		if err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(ctx, tx, userID, nil)
+	})
+}
+
+// checkAffected turns an update or delete that matched no rows into
+// ErrNoRecord.
+func checkAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
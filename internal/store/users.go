@@ -4,10 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/theluminousartemis/snippetbin/internal/passhash"
 )
 
 type User struct {
@@ -16,6 +17,27 @@ type User struct {
 	Email     string
 	Password  password
 	CreatedAt time.Time
+	// EmailVerifiedAt is zero until the user follows the link sent to
+	// their email address.
+	EmailVerifiedAt time.Time
+	// TwoFactorEnabled is set once the user has confirmed a TOTP
+	// enrollment, after which logins need a code as well as the password.
+	TwoFactorEnabled bool
+	Role             Role
+	// DisabledAt is zero unless a moderator has disabled the account.
+	DisabledAt time.Time
+	// PasswordResetRequired is set by an admin who suspects the password
+	// is known to someone else. The password no longer logs the user in
+	// until they reset it.
+	PasswordResetRequired bool
+}
+
+func (u User) EmailVerified() bool {
+	return !u.EmailVerifiedAt.IsZero()
+}
+
+func (u User) Disabled() bool {
+	return !u.DisabledAt.IsZero()
 }
 
 type password struct {
@@ -23,8 +45,12 @@ type password struct {
 	hash []byte
 }
 
+// PasswordHashParams are used for new password hashes. Hashes made with
+// other parameters, or with bcrypt, are upgraded on the next login.
+var PasswordHashParams = passhash.DefaultParams
+
 func (p *password) Set(plaintext string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), 12)
+	hash, err := passhash.Hash(plaintext, PasswordHashParams)
 	if err != nil {
 		return err
 	}
@@ -32,8 +58,45 @@ func (p *password) Set(plaintext string) error {
 	return nil
 }
 
+// Compare returns ErrInvalidCredentials if plaintext is not the password.
 func (p *password) Compare(plaintext string) error {
-	return bcrypt.CompareHashAndPassword(p.hash, []byte(plaintext))
+	err := passhash.Compare(p.hash, plaintext)
+	if errors.Is(err, passhash.ErrMismatch) {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+func (p *password) NeedsRehash() bool {
+	return passhash.NeedsRehash(p.hash, PasswordHashParams)
+}
+
+var dummyPassword struct {
+	sync.Mutex
+	params passhash.Params
+	hash   []byte
+}
+
+// CompareDummyPassword takes as long as comparing plaintext with a real
+// password, for logins to an email address without an account, so the
+// response time doesn't give away which addresses are registered.
+func CompareDummyPassword(plaintext string) error {
+	dummyPassword.Lock()
+	if dummyPassword.hash == nil || dummyPassword.params != PasswordHashParams {
+		hash, err := passhash.Hash("dummy password", PasswordHashParams)
+		if err != nil {
+			dummyPassword.Unlock()
+			return err
+		}
+		dummyPassword.params, dummyPassword.hash = PasswordHashParams, hash
+	}
+	hash := dummyPassword.hash
+	dummyPassword.Unlock()
+
+	if err := passhash.Compare(hash, plaintext); err != nil && !errors.Is(err, passhash.ErrMismatch) {
+		return err
+	}
+	return nil
 }
 
 type PostgresUserModel struct {
@@ -41,32 +104,44 @@ type PostgresUserModel struct {
 }
 
 func (m *PostgresUserModel) Insert(ctx context.Context, user *User) error {
-	stmt := "INSERT INTO users (username, email, password, created_at) VALUES($1, $2, $3, NOW())"
+	stmt := "INSERT INTO users (username, email, password, created_at) VALUES($1, $2, $3, NOW()) RETURNING id, created_at"
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, stmt, user.Username, user.Email, user.Password.hash)
-	if err != nil {
-		if pgErr, ok := err.(*pq.Error); ok {
-			switch pgErr.Constraint {
-			case "users_email_key":
-				return ErrDuplicateEmail
-			case "users_username_key":
-				return ErrDuplicateUsername
-			}
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, user.Username, user.Email, user.Password.hash).Scan(&user.ID, &user.CreatedAt)
+	logQuery(ctx, "users.insert", start, err)
+	return userInsertError(err)
+}
+
+// userInsertError maps unique violations on users, from an insert or an
+// update, to ErrDuplicateEmail and ErrDuplicateUsername.
+func userInsertError(err error) error {
+	if pgErr, ok := err.(*pq.Error); ok {
+		switch pgErr.Constraint {
+		case "users_email_key":
+			return ErrDuplicateEmail
+		case "users_username_key":
+			return ErrDuplicateUsername
 		}
-		return err
 	}
-	return nil
+	return err
 }
 
 func (m *PostgresUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	// var id int
 	// var hashedPassword []byte
 	var user User
-	stmt := "SELECT id, password FROM users WHERE email=$1"
+	var verifiedAt, disabledAt sql.NullTime
+	stmt := `SELECT id, username, email, password, created_at, email_verified_at, totp_enabled_at IS NOT NULL,
+		role, disabled_at, password_reset_required FROM users WHERE email=$1`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&user.ID, &user.Password.hash)
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&user.ID, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt, &verifiedAt, &user.TwoFactorEnabled,
+		&user.Role, &disabledAt, &user.PasswordResetRequired)
+	logQuery(ctx, "users.get_by_email", start, err)
+	user.EmailVerifiedAt = verifiedAt.Time
+	user.DisabledAt = disabledAt.Time
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrInvalidCredentials
@@ -86,22 +161,19 @@ func (m *PostgresUserModel) GetByEmail(ctx context.Context, email string) (*User
 	return &user, err
 }
 
-func (m *PostgresUserModel) Exists(ctx context.Context, id int) (bool, error) {
-	var exists bool
-
-	stmt := "SELECT EXISTS(SELECT 1 FROM users WHERE id=$1)"
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
-	defer cancel()
-	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&exists)
-	return exists, err
-}
-
 func (m *PostgresUserModel) GetByID(ctx context.Context, id int) (*User, error) {
 	var user User
-	stmt := "SELECT username, email, created_at FROM users WHERE id=$1"
+	var verifiedAt, disabledAt sql.NullTime
+	stmt := `SELECT id, username, email, created_at, email_verified_at, totp_enabled_at IS NOT NULL,
+		role, disabled_at, password_reset_required FROM users WHERE id=$1`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&user.Username, &user.Email, &user.CreatedAt)
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &verifiedAt, &user.TwoFactorEnabled,
+		&user.Role, &disabledAt, &user.PasswordResetRequired)
+	logQuery(ctx, "users.get_by_id", start, err)
+	user.EmailVerifiedAt = verifiedAt.Time
+	user.DisabledAt = disabledAt.Time
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrInvalidCredentials
@@ -112,7 +184,33 @@ func (m *PostgresUserModel) GetByID(ctx context.Context, id int) (*User, error)
 	return &user, nil
 }
 
-func (m *PostgresUserModel) PasswordUpdate(ctx context.Context, id int, currentPassword string, newPassword string) error {
+// MarkEmailVerified records that the user with id confirmed they own email.
+// It returns ErrNoRecord if the user no longer has that address, so a link
+// sent to an old address cannot verify a new one.
+func (m *PostgresUserModel) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	stmt := "UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1 AND email = $2"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, stmt, id, email)
+	logQuery(ctx, "users.mark_email_verified", start, err)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// PasswordUpdate changes the user's password and, in the same
+// transaction, revokes every session of theirs except keepSession, so
+// whoever else knew the old password is logged out.
+func (m *PostgresUserModel) PasswordUpdate(ctx context.Context, id int, currentPassword string, newPassword string, keepSession string) error {
 	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
 		user, err := m.getPasswordByID(ctx, tx, id)
 		if err != nil {
@@ -123,17 +221,151 @@ func (m *PostgresUserModel) PasswordUpdate(ctx context.Context, id int, currentP
 			return ErrInvalidCredentials
 		}
 
-		user.Password.Set(newPassword)
-		return m.updatePassword(ctx, tx, user)
+		if err := user.Password.Set(newPassword); err != nil {
+			return err
+		}
+		if err := m.updatePassword(ctx, tx, user); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		start := time.Now()
+		_, err = tx.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = $1 AND id <> $2", id, keepSession)
+		logQuery(ctx, "user_sessions.delete_for_user", start, err)
+		return err
+	})
+}
+
+// UpgradePassword rehashes the user's password with the current
+// parameters if their stored hash is outdated. plaintext must already have
+// been checked against it. It reports whether the hash was replaced; a
+// password changed in the meantime is left alone.
+func (m *PostgresUserModel) UpgradePassword(ctx context.Context, user *User, plaintext string) (bool, error) {
+	if !user.Password.NeedsRehash() {
+		return false, nil
+	}
+	old := user.Password.hash
+	if err := user.Password.Set(plaintext); err != nil {
+		return false, err
+	}
+	stmt := "UPDATE users SET password = $1 WHERE id = $2 AND password = $3"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, stmt, user.Password.hash, user.ID, old)
+	logQuery(ctx, "users.upgrade_password", start, err)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UpdateUsername renames the user and records the change in the audit log.
+// It returns ErrDuplicateUsername if someone else has the name.
+func (m *PostgresUserModel) UpdateUsername(ctx context.Context, id int, username, ip string) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var old string
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		start := time.Now()
+		err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = $1 FOR UPDATE", id).Scan(&old)
+		logQuery(ctx, "users.get_username", start, err)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+		if old == username {
+			return nil
+		}
+
+		start = time.Now()
+		_, err = tx.ExecContext(ctx, "UPDATE users SET username = $1 WHERE id = $2", username, id)
+		logQuery(ctx, "users.update_username", start, err)
+		if err != nil {
+			return userInsertError(err)
+		}
+		return insertAuditEvent(ctx, tx, &AuditEvent{UserID: id, Action: AuditUsernameChanged, OldValue: old, NewValue: username, IP: ip})
+	})
+}
+
+// UpdateEmail moves the user from oldEmail to newEmail, which they have
+// just proved they own, and records the change in the audit log. It
+// returns ErrNoRecord if the user no longer has oldEmail, so a
+// confirmation link works only once, and ErrDuplicateEmail if newEmail
+// has been taken since it was requested.
+func (m *PostgresUserModel) UpdateEmail(ctx context.Context, id int, oldEmail, newEmail, ip string) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		stmt := "UPDATE users SET email = $3, email_verified_at = NOW() WHERE id = $1 AND email = $2"
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		start := time.Now()
+		res, err := tx.ExecContext(ctx, stmt, id, oldEmail, newEmail)
+		logQuery(ctx, "users.update_email", start, err)
+		if err != nil {
+			return userInsertError(err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNoRecord
+		}
+		return insertAuditEvent(ctx, tx, &AuditEvent{UserID: id, Action: AuditEmailChanged, OldValue: oldEmail, NewValue: newEmail, IP: ip})
+	})
+}
+
+// Delete removes the user. Their sessions, tokens, passkeys and linked
+// identities go with them; their snippets are deleted too if
+// purgeSnippets is set, and are otherwise kept without an owner.
+// Organizations the user is the only member of are deleted too. It returns
+// ErrLastOwner if the user is the only owner of an organization with other
+// members. Callers check it's the user asking first.
+func (m *PostgresUserModel) Delete(ctx context.Context, id int, purgeSnippets bool) error {
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		if err := releaseOrganizations(ctx, tx, id); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+		if purgeSnippets {
+			start := time.Now()
+			_, err := tx.ExecContext(ctx, "DELETE FROM snippets WHERE user_id = $1", id)
+			logQuery(ctx, "snippets.delete_for_user", start, err)
+			if err != nil {
+				return err
+			}
+		}
+		start := time.Now()
+		res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+		logQuery(ctx, "users.delete", start, err)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNoRecord
+		}
+		return nil
 	})
 }
 
 func (m *PostgresUserModel) getPasswordByID(ctx context.Context, tx *sql.Tx, id int) (*User, error) {
-	var user User
+	user := User{ID: id}
 	stmt := "SELECT password from users WHERE ID = $1"
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
+	start := time.Now()
 	err := tx.QueryRowContext(ctx, stmt, id).Scan(&user.Password.hash)
+	logQuery(ctx, "users.get_password", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -141,11 +373,13 @@ func (m *PostgresUserModel) getPasswordByID(ctx context.Context, tx *sql.Tx, id
 }
 
 func (m *PostgresUserModel) updatePassword(ctx context.Context, tx *sql.Tx, u *User) error {
-	stmt := "UPDATE users SET password = $1 WHERE id = $2"
+	stmt := "UPDATE users SET password = $1, password_reset_required = false WHERE id = $2"
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
 
+	start := time.Now()
 	_, err := tx.ExecContext(ctx, stmt, u.Password.hash, u.ID)
+	logQuery(ctx, "users.update_password", start, err)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// WebAuthnCredential is a passkey or security key registered to a user.
+type WebAuthnCredential struct {
+	ID              []byte
+	UserID          int
+	Name            string
+	PublicKey       []byte
+	AttestationType string
+	Transports      []string
+	AAGUID          []byte
+	SignCount       uint32
+	BackupEligible  bool
+	BackupState     bool
+	CreatedAt       time.Time
+	LastUsedAt      time.Time
+}
+
+// EncodedID is the credential ID in the unpadded base64url form that
+// browsers use.
+func (c WebAuthnCredential) EncodedID() string {
+	return base64.RawURLEncoding.EncodeToString(c.ID)
+}
+
+type PostgresWebAuthnModel struct {
+	DB *sql.DB
+}
+
+func (m *PostgresWebAuthnModel) Insert(ctx context.Context, c *WebAuthnCredential) error {
+	stmt := `INSERT INTO webauthn_credentials
+		(id, user_id, name, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING created_at`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	err := m.DB.QueryRowContext(ctx, stmt, c.ID, c.UserID, c.Name, c.PublicKey, c.AttestationType,
+		pq.Array(c.Transports), c.AAGUID, int64(c.SignCount), c.BackupEligible, c.BackupState).Scan(&c.CreatedAt)
+	logQuery(ctx, "webauthn_credentials.insert", start, err)
+	return err
+}
+
+// ListByUser returns the user's credentials, oldest first.
+func (m *PostgresWebAuthnModel) ListByUser(ctx context.Context, userID int) ([]WebAuthnCredential, error) {
+	stmt := `SELECT id, user_id, name, public_key, attestation_type, transports, aaguid, sign_count,
+		backup_eligible, backup_state, created_at, last_used_at
+		FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at, id`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	logQuery(ctx, "webauthn_credentials.list_by_user", start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []WebAuthnCredential
+	for rows.Next() {
+		var c WebAuthnCredential
+		var signCount int64
+		var lastUsedAt sql.NullTime
+		err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.PublicKey, &c.AttestationType, pq.Array(&c.Transports),
+			&c.AAGUID, &signCount, &c.BackupEligible, &c.BackupState, &c.CreatedAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		c.SignCount = uint32(signCount)
+		c.LastUsedAt = lastUsedAt.Time
+		creds = append(creds, c)
+	}
+	return creds, rows.Err()
+}
+
+// RecordUse stores the state the authenticator reported when it was last
+// used to log in.
+func (m *PostgresWebAuthnModel) RecordUse(ctx context.Context, id []byte, signCount uint32, backupState bool) error {
+	stmt := "UPDATE webauthn_credentials SET sign_count = $1, backup_state = $2, last_used_at = NOW() WHERE id = $3"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, stmt, int64(signCount), backupState, id)
+	logQuery(ctx, "webauthn_credentials.record_use", start, err)
+	return checkAffected(res, err)
+}
+
+// Delete removes one of the user's credentials, or returns ErrNoRecord if
+// they have no credential with id.
+func (m *PostgresWebAuthnModel) Delete(ctx context.Context, userID int, id []byte) error {
+	stmt := "DELETE FROM webauthn_credentials WHERE user_id = $1 AND id = $2"
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	start := time.Now()
+	res, err := m.DB.ExecContext(ctx, stmt, userID, id)
+	logQuery(ctx, "webauthn_credentials.delete", start, err)
+	return checkAffected(res, err)
+}
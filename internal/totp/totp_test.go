@@ -0,0 +1,64 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theluminousartemis/snippetbin/internal/assert"
+)
+
+// The SHA1 test vectors from RFC 6238 appendix B, truncated to six digits.
+func TestCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, Code(secret, time.Unix(tt.unix, 0)), tt.want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+
+	step, ok := Verify(secret, Code(secret, now), now, 0)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, step, Step(now))
+
+	// Neighbouring periods are accepted to allow for clock drift.
+	_, ok = Verify(secret, Code(secret, now.Add(-Period)), now, 0)
+	assert.Equal(t, ok, true)
+	_, ok = Verify(secret, Code(secret, now.Add(Period)), now, 0)
+	assert.Equal(t, ok, true)
+	_, ok = Verify(secret, Code(secret, now.Add(2*Period)), now, 0)
+	assert.Equal(t, ok, false)
+
+	// A code cannot be used again once its step has been accepted.
+	_, ok = Verify(secret, Code(secret, now), now, step)
+	assert.Equal(t, ok, false)
+
+	_, ok = Verify(secret, "12345", now, 0)
+	assert.Equal(t, ok, false)
+}
+
+func TestURI(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	uri := URI("Snippetbin", "bob@example.com", secret)
+	assert.StringContains(t, uri, "otpauth://totp/Snippetbin:bob@example.com?")
+	assert.StringContains(t, uri, "secret="+EncodeSecret(secret))
+	assert.StringContains(t, uri, "issuer=Snippetbin")
+	assert.Equal(t, strings.Contains(EncodeSecret(secret), "="), false)
+}
@@ -0,0 +1,6 @@
+package migrate
+
+import "embed"
+
+//go:embed "migrations"
+var Files embed.FS
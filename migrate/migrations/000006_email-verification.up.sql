@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN email_verified_at timestamp(0) WITH TIME ZONE;
+
+-- Accounts created before verification existed keep working as they did.
+UPDATE users SET email_verified_at = created_at;
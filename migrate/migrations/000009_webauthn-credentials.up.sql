@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name varchar(100) NOT NULL,
+    public_key bytea NOT NULL,
+    attestation_type text NOT NULL,
+    transports text[] NOT NULL DEFAULT '{}',
+    aaguid bytea,
+    sign_count bigint NOT NULL DEFAULT 0,
+    backup_eligible boolean NOT NULL,
+    backup_state boolean NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) WITH TIME ZONE
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
@@ -0,0 +1,12 @@
+-- user_identities links accounts at an OpenID Connect provider to users.
+-- The provider's subject identifier is stable, unlike the email address.
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_login_at timestamp(0) WITH TIME ZONE,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
@@ -0,0 +1,13 @@
+-- user_sessions lists the sessions each user is logged in with. A session
+-- whose row is gone is logged out on its next request.
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id text PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_seen_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ip text NOT NULL,
+    user_agent text NOT NULL
+);
+
+CREATE INDEX user_sessions_user_id_idx ON user_sessions (user_id);
+CREATE INDEX user_sessions_created_at_idx ON user_sessions (created_at);
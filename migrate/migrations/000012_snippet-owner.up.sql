@@ -0,0 +1,6 @@
+-- Snippets remember who created them, so a user's snippets can be
+-- exported or deleted with their account. Snippets from before this and
+-- from deleted accounts have no owner.
+ALTER TABLE snippets ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS snippets_user_id_idx ON snippets (user_id);
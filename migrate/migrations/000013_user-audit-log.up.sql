@@ -0,0 +1,14 @@
+-- user_audit_log records changes to a user's account details, with the
+-- values before and after, so a support question can be answered without
+-- digging through backups.
+CREATE TABLE IF NOT EXISTS user_audit_log (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    action text NOT NULL,
+    old_value text NOT NULL,
+    new_value text NOT NULL,
+    ip text NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_audit_log_user_id_idx ON user_audit_log (user_id, created_at);
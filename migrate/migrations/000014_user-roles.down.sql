@@ -0,0 +1,6 @@
+ALTER TABLE user_audit_log DROP COLUMN IF EXISTS actor_id;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS role,
+    DROP COLUMN IF EXISTS disabled_at,
+    DROP COLUMN IF EXISTS password_reset_required;
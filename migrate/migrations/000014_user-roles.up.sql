@@ -0,0 +1,11 @@
+-- role decides who can use the admin area. A disabled user cannot log in,
+-- and one who must reset their password cannot log in with it until they
+-- have.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin')),
+    ADD COLUMN IF NOT EXISTS disabled_at timestamp(0) WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS password_reset_required boolean NOT NULL DEFAULT false;
+
+-- actor_id is the moderator or admin who made a change on the user's
+-- behalf. It is NULL for changes the user made themselves.
+ALTER TABLE user_audit_log ADD COLUMN IF NOT EXISTS actor_id bigint REFERENCES users ON DELETE SET NULL;
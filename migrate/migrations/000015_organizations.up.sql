@@ -0,0 +1,23 @@
+-- Organizations group users so snippets can be shared within a team. A
+-- snippet created in an organization can only be viewed by its members,
+-- even with the key, and lives at most retention_days.
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    retention_days integer NOT NULL DEFAULT 365 CHECK (retention_days > 0),
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    org_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('member', 'owner')),
+    created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON organization_members (user_id);
+
+ALTER TABLE snippets ADD COLUMN IF NOT EXISTS org_id bigint REFERENCES organizations ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS snippets_org_id_idx ON snippets (org_id);
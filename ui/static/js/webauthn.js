@@ -0,0 +1,121 @@
+// Passkey ceremonies. A button with data-passkey="register" or "get" runs
+// one: it fetches options from data-begin, asks the browser for a
+// credential and posts the result to data-finish, which answers with where
+// to go next.
+(function () {
+	if (!window.PublicKeyCredential) {
+		return;
+	}
+
+	function toBytes(s) {
+		s = s.replace(/-/g, "+").replace(/_/g, "/");
+		var bin = atob(s + "===".slice((s.length + 3) % 4));
+		var bytes = new Uint8Array(bin.length);
+		for (var i = 0; i < bin.length; i++) {
+			bytes[i] = bin.charCodeAt(i);
+		}
+		return bytes.buffer;
+	}
+
+	function toBase64URL(buf) {
+		var bytes = new Uint8Array(buf);
+		var bin = "";
+		for (var i = 0; i < bytes.length; i++) {
+			bin += String.fromCharCode(bytes[i]);
+		}
+		return btoa(bin).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+	}
+
+	function decodeDescriptors(list) {
+		(list || []).forEach(function (c) {
+			c.id = toBytes(c.id);
+		});
+	}
+
+	function encodeCredential(cred) {
+		var r = cred.response;
+		var response = { clientDataJSON: toBase64URL(r.clientDataJSON) };
+		if (r.attestationObject) {
+			response.attestationObject = toBase64URL(r.attestationObject);
+			if (r.getTransports) {
+				response.transports = r.getTransports();
+			}
+		} else {
+			response.authenticatorData = toBase64URL(r.authenticatorData);
+			response.signature = toBase64URL(r.signature);
+			if (r.userHandle) {
+				response.userHandle = toBase64URL(r.userHandle);
+			}
+		}
+		return {
+			id: cred.id,
+			rawId: toBase64URL(cred.rawId),
+			type: cred.type,
+			authenticatorAttachment: cred.authenticatorAttachment,
+			clientExtensionResults: cred.getClientExtensionResults(),
+			response: response
+		};
+	}
+
+	function post(url, body) {
+		var token = document.querySelector("input[name=csrf_token]");
+		return fetch(url, {
+			method: "POST",
+			credentials: "same-origin",
+			headers: {
+				"Content-Type": "application/json",
+				"X-CSRF-Token": token ? token.value : ""
+			},
+			body: body ? JSON.stringify(body) : null
+		}).then(function (rs) {
+			return rs.json().then(function (data) {
+				if (!rs.ok) {
+					throw new Error(data.error || "Something went wrong. Please try again.");
+				}
+				return data;
+			});
+		});
+	}
+
+	function run(button) {
+		var kind = button.getAttribute("data-passkey");
+		var errors = document.querySelector("[data-passkey-error]");
+		var finish = button.getAttribute("data-finish");
+		if (kind === "register") {
+			var name = document.querySelector("[data-passkey-name]");
+			if (name && name.value) {
+				finish += "?name=" + encodeURIComponent(name.value);
+			}
+		}
+		errors.hidden = true;
+		button.disabled = true;
+
+		post(button.getAttribute("data-begin")).then(function (options) {
+			var pk = options.publicKey;
+			pk.challenge = toBytes(pk.challenge);
+			if (kind === "register") {
+				pk.user.id = toBytes(pk.user.id);
+				decodeDescriptors(pk.excludeCredentials);
+				return navigator.credentials.create({ publicKey: pk });
+			}
+			decodeDescriptors(pk.allowCredentials);
+			return navigator.credentials.get({ publicKey: pk });
+		}).then(function (cred) {
+			return post(finish, encodeCredential(cred));
+		}).then(function (data) {
+			window.location = data.redirect;
+		}).catch(function (err) {
+			errors.textContent = err.name === "NotAllowedError" ? "The passkey prompt was cancelled." : err.message;
+			errors.hidden = false;
+			button.disabled = false;
+		});
+	}
+
+	var buttons = document.querySelectorAll("[data-passkey]");
+	for (var i = 0; i < buttons.length; i++) {
+		buttons[i].hidden = false;
+		buttons[i].addEventListener("click", function (e) {
+			run(e.currentTarget);
+		});
+	}
+})();